@@ -0,0 +1,35 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Emmrys-Jay/ecommerce-api/repository"
+	util "github.com/Emmrys-Jay/ecommerce-api/util"
+	"github.com/gin-gonic/gin"
+)
+
+// GetAbandonedCartReport returns the abandonment rate and recovered revenue of carts abandoned over the number of
+// days in the "days" query param, 30 by default
+func (a *AdminController) GetAbandonedCartReport(ctx *gin.Context) {
+	var days = 30
+	var err error
+
+	daysString := ctx.Query("days")
+	if daysString != "" {
+		days, err = strconv.Atoi(daysString)
+		if err != nil || days < 1 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid params - days"})
+			return
+		}
+	}
+
+	report, err := repository.GetAbandonmentReport(a.UserController.Database, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
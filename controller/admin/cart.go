@@ -119,13 +119,13 @@ func (a *AdminController) DeleteCartItem(ctx *gin.Context) {
 func (a *AdminController) DeleteAllUserCartItems(ctx *gin.Context) {
 	collection := db.GetCollection(a.UserController.Database, "cart")
 
-	username := ctx.Param("username")
-	if username == "" {
+	userID := ctx.Param("user-id")
+	if userID == "" {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - no id specified"})
 		return
 	}
 
-	result, err := repository.DeleteAllUserCartItems(collection, username)
+	result, err := repository.DeleteAllUserCartItems(collection, userID)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
 		return
@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/repository"
+	util "github.com/Emmrys-Jay/ecommerce-api/util"
+	"github.com/gin-gonic/gin"
+)
+
+// SaveCategorySchema creates or replaces the attribute schema of the category in the url.
+// Existing products are checked against it the next time they are saved.
+func (a *AdminController) SaveCategorySchema(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "category_schemas")
+	var req entity.CategorySchema
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	req.Category = ctx.Param("category")
+	if req.Category == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid url param"})
+		return
+	}
+
+	if err := repository.SaveCategorySchema(collection, &req); err != nil {
+		if errors.Is(err, repository.ErrInvalidAttribute) {
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, req)
+}
+
+// DeleteCategorySchema removes the attribute schema of a category
+func (a *AdminController) DeleteCategorySchema(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "category_schemas")
+
+	category := ctx.Param("category")
+	if category == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid url param"})
+		return
+	}
+
+	result, err := repository.DeleteCategorySchema(collection, category)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	if result.DeletedCount == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "category has no attribute schema"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"success": "deleted category schema"})
+}
@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Emmrys-Jay/ecommerce-api/currency"
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/repository"
+	util "github.com/Emmrys-Jay/ecommerce-api/util"
+	"github.com/gin-gonic/gin"
+)
+
+// AddExchangeRate adds a rate to the exchange-rate table. The rate is the number of units
+// of the currency worth one unit of the store base currency.
+func (a *AdminController) AddExchangeRate(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "exchange_rates")
+	var req entity.ExchangeRate
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	if code, err := currency.Normalize(req.Currency); err != nil || code == currency.Base() {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "currency must be a valid code other than the base currency"})
+		return
+	}
+
+	rate, err := repository.AddExchangeRate(collection, req)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, rate)
+}
+
+// GetExchangeRates lists the exchange-rate table, optionally filtered with the "currency" query param
+func (a *AdminController) GetExchangeRates(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "exchange_rates")
+
+	code := ctx.Query("currency")
+	if code != "" {
+		var err error
+		if code, err = currency.Normalize(code); err != nil {
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+			return
+		}
+	}
+
+	rates, err := repository.GetExchangeRates(collection, code)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"base_currency": currency.Base(), "data": rates})
+}
+
+// DeleteExchangeRate removes an entry from the exchange-rate table
+func (a *AdminController) DeleteExchangeRate(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "exchange_rates")
+
+	id := ctx.Param("id")
+	if id == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - no id specified"})
+		return
+	}
+
+	result, err := repository.DeleteExchangeRate(collection, id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	if result.DeletedCount == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{"not found": "specified params did not match any document"})
+		return
+	}
+
+	response := fmt.Sprintf("deleted exchange rate with id - %s", id)
+	ctx.JSON(http.StatusOK, gin.H{"success": response})
+}
@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/repository"
+	util "github.com/Emmrys-Jay/ecommerce-api/util"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// UploadDigitalFile attaches the file sent in the "file" form field to a file product, replacing any earlier file
+func (a *AdminController) UploadDigitalFile(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "products")
+
+	productID := ctx.Param("id")
+	if productID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - product ID"})
+		return
+	}
+
+	file, err := ctx.FormFile("file")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	fileName := filepath.Base(file.Filename)
+	path := repository.DigitalFilePath(productID, fileName)
+
+	product, err := repository.FindOneProduct(collection, productID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	if !product.UnlimitedStock() {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "product is not a downloadable file"})
+		return
+	}
+
+	if err := ctx.SaveUploadedFile(file, path); err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	previous, err := repository.SetDigitalFile(collection, productID, fileName)
+	if err != nil {
+		_ = os.Remove(path)
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	if previous != "" && previous != fileName {
+		_ = os.Remove(repository.DigitalFilePath(productID, previous))
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"success": "file uploaded", "file_name": fileName})
+}
+
+// AddLicenceKeysRequest models the body of an add licence keys request
+type AddLicenceKeysRequest struct {
+	Keys []string `json:"keys" binding:"required,min=1"`
+}
+
+// AddLicenceKeys adds keys to the pool of a licence key product. Keys already in the pool are skipped.
+func (a *AdminController) AddLicenceKeys(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "licence_keys")
+	var req AddLicenceKeysRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	productID := ctx.Param("id")
+	if productID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - product ID"})
+		return
+	}
+
+	added, err := repository.AddLicenceKeys(collection, productID, req.Keys)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+			return
+		}
+		if errors.Is(err, repository.ErrInvalidDigital) {
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	stock, err := repository.GetLicenceKeyStock(collection, productID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"added": added, "stock": stock})
+}
+
+// GetLicenceKeyStock returns how many keys of a product are free and how many have been sold
+func (a *AdminController) GetLicenceKeyStock(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "licence_keys")
+
+	productID := ctx.Param("id")
+	if productID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - product ID"})
+		return
+	}
+
+	stock, err := repository.GetLicenceKeyStock(collection, productID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, stock)
+}
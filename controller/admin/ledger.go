@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/repository"
+	util "github.com/Emmrys-Jay/ecommerce-api/util"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AdjustStockRequest models the body of a stock adjustment request
+type AdjustStockRequest struct {
+	Delta       int64  `json:"delta" binding:"required"`
+	Reason      string `json:"reason" binding:"required,oneof=restock return adjustment damage"`
+	LocationID  string `json:"location_id"`
+	ReferenceID string `json:"reference_id"`
+	Note        string `json:"note"`
+}
+
+// AdjustStock adds to or removes from the stock of a product, recording why in the stock ledger.
+// Products kept by location need the location_id of the location whose stock changes.
+func (a *AdminController) AdjustStock(ctx *gin.Context) {
+	var req AdjustStockRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	productID := ctx.Param("id")
+	if productID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - product ID"})
+		return
+	}
+
+	adminID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	adjustment := entity.StockMovement{
+		LocationID:  req.LocationID,
+		Delta:       req.Delta,
+		Reason:      req.Reason,
+		Actor:       adminID,
+		ReferenceID: req.ReferenceID,
+		Note:        req.Note,
+	}
+
+	stock, err := repository.AdjustStock(a.UserController.Database, productID, adjustment)
+	if err != nil {
+		switch {
+		case err == mongo.ErrNoDocuments:
+			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+		case errors.Is(err, repository.ErrInvalidAdjustment) || isProductValidationError(err) || repository.IsQuantityError(err):
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		default:
+			ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, stock)
+}
+
+// GetStockMovements returns the stock ledger of a product, newest first
+func (a *AdminController) GetStockMovements(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "stock_ledger")
+
+	productID := ctx.Param("id")
+	if productID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - product ID"})
+		return
+	}
+
+	pageID, pageSize, ok := reportPage(ctx)
+	if !ok {
+		return
+	}
+
+	movements, length, err := repository.GetStockMovements(collection, productID, pageSize*(pageID-1), pageSize)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	response := entity.PaginationResponse{
+		PageID:        pageID,
+		NumberOfPages: int(math.Ceil(float64(length) / float64(pageSize))),
+		ResultsFound:  int(length),
+		Data:          movements,
+	}
+
+	if response.NumberOfPages < 1 {
+		response.PageID = 0
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// GetStockReconciliationReport returns products whose stock differs from the sum of their ledger entries.
+// Every product is returned with "all=true".
+func (a *AdminController) GetStockReconciliationReport(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "products")
+
+	all, err := strconv.ParseBool(ctx.DefaultQuery("all", "false"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid params - all"})
+		return
+	}
+
+	pageID, pageSize, ok := reportPage(ctx)
+	if !ok {
+		return
+	}
+
+	rows, length, err := repository.GetStockReconciliation(collection, all, pageSize*(pageID-1), pageSize)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	response := entity.PaginationResponse{
+		PageID:        pageID,
+		NumberOfPages: int(math.Ceil(float64(length) / float64(pageSize))),
+		ResultsFound:  int(length),
+		Data:          rows,
+	}
+
+	if response.NumberOfPages < 1 {
+		response.PageID = 0
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/repository"
+	util "github.com/Emmrys-Jay/ecommerce-api/util"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CreateLocation adds a warehouse or store that holds stock
+func (a *AdminController) CreateLocation(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "stock_locations")
+	var location entity.StockLocation
+
+	if err := ctx.ShouldBindJSON(&location); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	created, err := repository.CreateLocation(collection, location)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, created)
+}
+
+// GetLocations returns every stock location
+func (a *AdminController) GetLocations(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "stock_locations")
+
+	locations, err := repository.GetLocations(collection)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, locations)
+}
+
+// UpdateLocation replaces the name, address and priority of a stock location
+func (a *AdminController) UpdateLocation(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "stock_locations")
+	var location entity.StockLocation
+
+	if err := ctx.ShouldBindJSON(&location); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	id := ctx.Param("id")
+	if id == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - location ID"})
+		return
+	}
+
+	updated, err := repository.UpdateLocation(collection, id, location)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, updated)
+}
+
+// DeleteLocation removes a stock location. Its stock has to be moved or set to zero first.
+func (a *AdminController) DeleteLocation(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "stock_locations")
+
+	id := ctx.Param("id")
+	if id == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - location ID"})
+		return
+	}
+
+	result, err := repository.DeleteLocation(collection, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrLocationInUse) {
+			ctx.JSON(http.StatusConflict, util.ErrorResponse(err))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	if result.DeletedCount < 1 {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "location not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"success": "location deleted"})
+}
+
+// GetProductStock returns the stock of a product at each location
+func (a *AdminController) GetProductStock(ctx *gin.Context) {
+	productID := ctx.Param("id")
+	if productID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - product ID"})
+		return
+	}
+
+	stock, err := repository.GetProductStock(a.UserController.Database, productID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, stock)
+}
+
+// SetLocationStockRequest models the body of a set location stock request
+type SetLocationStockRequest struct {
+	Quantity int64 `json:"quantity" binding:"min=0"`
+}
+
+// SetLocationStock sets how many units of a product a location holds. The product's total stock
+// becomes the sum over its locations.
+func (a *AdminController) SetLocationStock(ctx *gin.Context) {
+	var req SetLocationStockRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	productID := ctx.Param("id")
+	locationID := ctx.Param("location-id")
+	if productID == "" || locationID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - product or location ID"})
+		return
+	}
+
+	adminID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	stock, err := repository.SetLocationStock(a.UserController.Database, productID, locationID, req.Quantity, adminID)
+	if err != nil {
+		switch {
+		case err == mongo.ErrNoDocuments:
+			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+		case isProductValidationError(err) || repository.IsQuantityError(err):
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		default:
+			ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, stock)
+}
@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"testing"
+	"time"
+
+	auth "github.com/Emmrys-Jay/ecommerce-api/auth/jwt"
+	"github.com/Emmrys-Jay/ecommerce-api/controller"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+type ServerDB struct {
+	Db     *mongo.Database
+	Server *gin.Engine
+}
+
+func NewServerDB() *ServerDB {
+	return &ServerDB{
+		Db:     connectDB(),
+		Server: gin.New(),
+	}
+}
+
+func connectDB() *mongo.Database {
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017"))
+	if err != nil {
+		log.Fatalln("could not connect to server: ", err)
+	}
+
+	if err = client.Ping(ctx, readpref.Primary()); err != nil {
+		log.Fatalln("could not ping server: ", err)
+	}
+
+	database := client.Database("ecommerce_admin_test")
+	fmt.Println("Successfully connected to database")
+	return database
+}
+
+func dropDatabase(d *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := d.Drop(ctx); err != nil {
+		return err
+	}
+	return d.Client().Disconnect(ctx)
+}
+
+// adminToken signs a token for an admin, the way login does
+func adminToken(t *testing.T) string {
+	t.Setenv("SECRET_KEY", "admin-test-secret-key-0123456789")
+
+	maker, err := auth.NewTokenMaker()
+	require.NoError(t, err)
+
+	token, err := maker.CreateToken("admin", primitive.NewObjectID().Hex())
+	require.NoError(t, err)
+
+	return token
+}
+
+func initializeAdminProductRoutes(details *ServerDB) {
+	adminController := NewAdminController(controller.NewUserController(details.Db))
+
+	admin := details.Server.Group("/admin")
+	{
+		admin.PATCH("/products/:id", adminController.UpdateProduct)
+		admin.POST("/products/import", adminController.ImportProducts)
+		admin.GET("/products/import/:job-id", adminController.GetImportJob)
+	}
+
+	userController := controller.NewUserController(details.Db)
+	details.Server.GET("/products/slug/:slug", userController.FindProductBySlug)
+}
@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"net/http"
@@ -78,20 +79,28 @@ func (a *AdminController) GetAllOrders(ctx *gin.Context) {
 
 // DeliverOrder is used by an admin to indicate that an order has been delivered
 func (a *AdminController) DeliverOrder(ctx *gin.Context) {
-	collection := db.GetCollection(a.UserController.Database, "orders")
-
 	orderID := ctx.Param("order-id")
 	if orderID == "" {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid url param"})
 		return
 	}
 
-	_, err := repository.DeliverOrder(collection, orderID)
+	adminID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	_, err = repository.DeliverOrder(a.UserController.Database, orderID, adminID)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
 			return
 		}
+		if err == repository.ErrDigitalOrder || errors.Is(err, repository.ErrIllegalTransition) {
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
 		return
 	}
@@ -99,6 +108,73 @@ func (a *AdminController) DeliverOrder(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{"response": "success!"})
 }
 
+// UpdateOrderStatusRequest models the body of a request to change the status of an order
+type UpdateOrderStatusRequest struct {
+	Status string `json:"status" binding:"required,oneof=pending_payment paid processing shipped delivered completed cancelled refunded"`
+	Note   string `json:"note"`
+}
+
+// UpdateOrderStatus moves an order to another status. Moves the order's current status does not allow are
+// rejected.
+func (a *AdminController) UpdateOrderStatus(ctx *gin.Context) {
+	var req UpdateOrderStatusRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	orderID := ctx.Param("id")
+	if orderID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - no id specified"})
+		return
+	}
+
+	adminID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	order, err := repository.TransitionOrder(a.UserController.Database, orderID, req.Status, adminID, req.Note)
+	if err != nil {
+		switch {
+		case err == mongo.ErrNoDocuments:
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "order specified does not exist"})
+		case errors.Is(err, repository.ErrIllegalTransition):
+			ctx.JSON(http.StatusConflict, util.ErrorResponse(err))
+		default:
+			ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, order)
+}
+
+// GetOrderTimeline returns the status changes of any order
+func (a *AdminController) GetOrderTimeline(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "orders")
+
+	orderID := ctx.Param("id")
+	if orderID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - no id specified"})
+		return
+	}
+
+	timeline, err := repository.GetOrderTimeline(collection, orderID, "")
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "order specified does not exist"})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, timeline)
+}
+
 // DeleteOrder is an admin specific handler to delete a single order
 func (a *AdminController) DeleteOrder(ctx *gin.Context) {
 	collection := db.GetCollection(a.UserController.Database, "orders")
@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/repository"
+	util "github.com/Emmrys-Jay/ecommerce-api/util"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AddProductSale schedules a sale window for a product
+func (a *AdminController) AddProductSale(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "products")
+	var req entity.Sale
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	id := ctx.Param("id")
+	if id == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - no id specified"})
+		return
+	}
+
+	product, err := repository.AddProductSale(collection, id, req)
+	if err != nil {
+		switch err {
+		case mongo.ErrNoDocuments:
+			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+		case repository.ErrInvalidSale, repository.ErrSaleOverlap:
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		default:
+			ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, product)
+}
+
+// RemoveProductSale cancels a scheduled sale of a product
+func (a *AdminController) RemoveProductSale(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "products")
+
+	id, saleID := ctx.Param("id"), ctx.Param("sale-id")
+	if id == "" || saleID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - no id specified"})
+		return
+	}
+
+	result, err := repository.RemoveProductSale(collection, id, saleID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	if result.ModifiedCount == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{"not found": "specified params did not match any sale"})
+		return
+	}
+
+	response := fmt.Sprintf("removed sale with id - %s", saleID)
+	ctx.JSON(http.StatusOK, gin.H{"success": response})
+}
+
+// GetPriceHistory returns the price changes of a product, newest first
+func (a *AdminController) GetPriceHistory(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "price_history")
+	var pageID, pageSize = 1, 10
+	var err error
+
+	id := ctx.Param("id")
+	if id == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - no id specified"})
+		return
+	}
+
+	pageIDString := ctx.Query("page_id")
+	if pageIDString != "" {
+		pageID, err = strconv.Atoi(pageIDString)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Could not parse page_id"})
+			return
+		}
+	}
+
+	if pageID < 1 {
+		pageID = 1
+	}
+
+	changes, length, err := repository.GetPriceHistory(collection, id, pageSize*(pageID-1), pageSize)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	response := entity.PaginationResponse{
+		PageID:        pageID,
+		NumberOfPages: int(math.Ceil(float64(length) / float64(pageSize))),
+		ResultsFound:  int(length),
+		Data:          changes,
+	}
+
+	if response.NumberOfPages < 1 {
+		response.PageID = 0
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
@@ -1,8 +1,12 @@
 package controller
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/Emmrys-Jay/ecommerce-api/db"
@@ -10,6 +14,7 @@ import (
 	"github.com/Emmrys-Jay/ecommerce-api/repository"
 	util "github.com/Emmrys-Jay/ecommerce-api/util"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -27,6 +32,12 @@ import (
 *```
 *	Other Fields:
 *	- Features: Slice of Feature Object
+*   - Type: "bundle" for a bundle of Components, which then needs no Price or Quantity
+*   - Components, BundleDiscount: Bundle contents and percentage off their summed price
+*   - Type "digital" with Digital: A file or licence key product, which needs no Quantity
+*   - Status, PublishAt: draft, scheduled (with PublishAt), published (the default) or archived
+*   - Attributes: Map of attribute key to value, checked against the category schema
+*   - Tags: Slice of String
 *   - SlashedPrice
 *   - Pictures: Slice of String
 *   - Videos: Slice of String
@@ -46,12 +57,42 @@ func (a *AdminController) AddOneProduct(ctx *gin.Context) {
 	req.LastUpdated = time.Now()
 	req.NumOfOrders = 0
 
-	_, err := repository.InsertOneProduct(collection, req)
+	if err := prepareProduct(a.UserController.Database, &req); err != nil {
+		if isProductValidationError(err) {
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	products := []entity.Product{req}
+	if err := repository.AssignProductIdentifiers(collection, products); err != nil {
+		if isIdentifierError(err) {
+			ctx.JSON(http.StatusConflict, util.ErrorResponse(err))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	adminID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	_, err = repository.InsertOneProduct(collection, products[0])
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
 		return
 	}
 
+	if err := repository.RecordInitialStock(a.UserController.Database, products, adminID, ""); err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
 	ctx.JSON(http.StatusOK, bson.M{"result": "added successfully"})
 }
 
@@ -74,6 +115,29 @@ func (a *AdminController) AddProducts(ctx *gin.Context) {
 		req[i].LastUpdated = currentTime
 		req[i].NumOfOrders = 0
 
+		if err := prepareProduct(a.UserController.Database, &req[i]); err != nil {
+			if isProductValidationError(err) {
+				ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+				return
+			}
+			ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+			return
+		}
+	}
+
+	if err := repository.AssignProductIdentifiers(collection, req); err != nil {
+		if isIdentifierError(err) {
+			ctx.JSON(http.StatusConflict, util.ErrorResponse(err))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	adminID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
 	}
 
 	result, err := repository.InsertProducts(collection, req)
@@ -82,6 +146,11 @@ func (a *AdminController) AddProducts(ctx *gin.Context) {
 		return
 	}
 
+	if err := repository.RecordInitialStock(a.UserController.Database, req, adminID, ""); err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
 	response := fmt.Sprintf("added %d products successfully", len(result.InsertedIDs))
 	ctx.JSON(http.StatusOK, gin.H{"result": response})
 }
@@ -124,34 +193,211 @@ func (a *AdminController) DeleteAllProducts(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{"response": response})
 }
 
-// UpdateProductRequest stores update request params
-type UpdateProductRequest struct {
-	Price    float64 `json:"price" form:"price"`
-	Quantity int64   `json:"quantity" form:"quantity"`
+// UpdateProduct edits any subset of a product's fields with a JSON Merge Patch (RFC 7396): members of the body
+// replace the product's fields, objects such as attributes are merged key by key and null clears a field.
+// Stock is set with "quantity" or moved up or down by "quantity_delta". The patched product is validated
+// like a new one and returned.
+func (a *AdminController) UpdateProduct(ctx *gin.Context) {
+	database := a.UserController.Database
+	collection := db.GetCollection(database, "products")
+
+	id := ctx.Param("id")
+	if id == "" {
+		ctx.JSON(http.StatusNotAcceptable, gin.H{"error": "nothing specified to update"})
+		return
+	}
+
+	body, err := ctx.GetRawData()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	var patch map[string]json.RawMessage
+	if err := json.Unmarshal(body, &patch); err != nil || patch == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "body must be a JSON object"})
+		return
+	}
+
+	var quantityDelta *int64
+	if raw, ok := patch["quantity_delta"]; ok {
+		if _, ok := patch["quantity"]; ok {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "set either quantity or quantity_delta"})
+			return
+		}
+
+		var delta int64
+		if err := json.Unmarshal(raw, &delta); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "quantity_delta must be a whole number"})
+			return
+		}
+
+		quantityDelta = &delta
+		delete(patch, "quantity_delta")
+	}
+
+	if len(patch) == 0 && quantityDelta == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid update params"})
+		return
+	}
+
+	fields, err := repository.ProductPatchFields(patch)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	existing, err := repository.GetStoredProduct(collection, id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	updated, err := mergeProductPatch(existing, patch)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	if err := repository.ValidateProductAttributes(database, updated); err != nil {
+		if errors.Is(err, repository.ErrInvalidAttribute) {
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	adminID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	product, err := repository.SaveProductPatch(collection, existing, updated, fields, quantityDelta, adminID)
+	if err != nil {
+		switch {
+		case err == mongo.ErrNoDocuments:
+			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+		case isIdentifierError(err):
+			ctx.JSON(http.StatusConflict, util.ErrorResponse(err))
+		case isProductValidationError(err) || repository.IsQuantityError(err):
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		default:
+			ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, product)
 }
 
-// UpdateProduct updates either the price or quantity of a product specified with its id
-func (a *AdminController) UpdateProduct(ctx *gin.Context) {
+// mergeProductPatch applies a merge patch to a copy of a product and checks the result with the rules for new products
+func mergeProductPatch(existing *entity.Product, patch map[string]json.RawMessage) (*entity.Product, error) {
+	current, err := json.Marshal(existing)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	merged, err := util.MergePatch(current, changes)
+	if err != nil {
+		return nil, err
+	}
+
+	var updated entity.Product
+	if err := json.Unmarshal(merged, &updated); err != nil {
+		return nil, err
+	}
+
+	if updated.Quantity < 0 {
+		return nil, repository.ErrInvalidQuantity
+	}
+
+	// Selling out is a valid edit, so stock is only checked for being negative
+	check := updated
+	if check.Quantity == 0 {
+		check.Quantity = 1
+	}
+
+	if err := binding.Validator.ValidateStruct(&check); err != nil {
+		return nil, err
+	}
+
+	return &updated, nil
+}
+
+// GetProducts lists products in any status for admins, e.g. ?status=draft,scheduled to preview unpublished ones.
+// Without a status every product is listed. Products can be searched with "name" and narrowed with "tags".
+func (a *AdminController) GetProducts(ctx *gin.Context) {
 	collection := db.GetCollection(a.UserController.Database, "products")
-	var req UpdateProductRequest
 
-	if err := ctx.ShouldBind(&req); err != nil {
+	pageID, pageSize, ok := reportPage(ctx)
+	if !ok {
+		return
+	}
+
+	statuses := []string{"all"}
+	if v := ctx.Query("status"); v != "" {
+		statuses = strings.Split(v, ",")
+	}
+
+	filter := repository.ProductFilter{Attributes: ctx.QueryMap("attr")}
+
+	var err error
+	filter.Statuses, err = repository.ParseStatuses(statuses)
+	if err != nil {
 		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
 		return
 	}
 
-	id := ctx.Param("id")
-	if id == "" {
-		ctx.JSON(http.StatusNotAcceptable, gin.H{"error": "nothing specified to update"})
+	if v := ctx.Query("tags"); v != "" {
+		filter.Tags = strings.Split(v, ",")
+	}
+
+	products, length, err := repository.FindProducts(collection, ctx.Query("name"), filter, int64(pageSize*(pageID-1)), int64(pageSize))
+	if err != nil {
+		if errors.Is(err, repository.ErrInvalidAttribute) {
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
 		return
 	}
 
-	if req.Price == 0 && req.Quantity == 0 {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid update params"})
+	response := entity.PaginationResponse{
+		PageID:        pageID,
+		NumberOfPages: int(math.Ceil(float64(length) / float64(pageSize))),
+		ResultsFound:  int(length),
+		Data:          products,
+	}
+
+	if response.NumberOfPages < 1 {
+		response.PageID = 0
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// GetProduct returns a product in any status, so admins can preview drafts and scheduled products
+func (a *AdminController) GetProduct(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "products")
+
+	id := ctx.Param("id")
+	if id == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - product ID"})
 		return
 	}
 
-	result, err := repository.UpdateProduct(collection, id, req.Price, req.Quantity, 0)
+	product, err := repository.FindOneProduct(collection, id)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
@@ -160,7 +406,75 @@ func (a *AdminController) UpdateProduct(ctx *gin.Context) {
 		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
 		return
 	}
-	response := fmt.Sprintf("updated %d document with id: %s", result.ModifiedCount, id)
-	ctx.JSON(http.StatusOK, gin.H{"response": response})
 
+	ctx.JSON(http.StatusOK, product)
+}
+
+// UpdateIdentifiersRequest models the body of a slug/ SKU update. Fields left empty are not changed.
+type UpdateIdentifiersRequest struct {
+	Slug string `json:"slug"`
+	SKU  string `json:"sku"`
+}
+
+// UpdateProductIdentifiers edits the slug and/or SKU of a product. The old slug keeps redirecting to the product.
+func (a *AdminController) UpdateProductIdentifiers(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "products")
+	var req UpdateIdentifiersRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	id := ctx.Param("id")
+	if id == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - product ID"})
+		return
+	}
+
+	if req.Slug == "" && req.SKU == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "nothing specified to update"})
+		return
+	}
+
+	product, err := repository.UpdateProductIdentifiers(collection, id, req.Slug, req.SKU)
+	if err != nil {
+		switch {
+		case err == mongo.ErrNoDocuments:
+			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+		case errors.Is(err, repository.ErrInvalidSlug):
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		case isIdentifierError(err), mongo.IsDuplicateKeyError(err):
+			ctx.JSON(http.StatusConflict, util.ErrorResponse(err))
+		default:
+			ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, product)
+}
+
+func isIdentifierError(err error) bool {
+	return errors.Is(err, repository.ErrSlugTaken) || errors.Is(err, repository.ErrSKUTaken) || errors.Is(err, repository.ErrInvalidSlug)
+}
+
+// prepareProduct checks the attributes, digital delivery, status and bundle components of a new or imported product
+func prepareProduct(database *mongo.Database, product *entity.Product) error {
+	if err := repository.ValidateProductAttributes(database, product); err != nil {
+		return err
+	}
+
+	if err := repository.PrepareDigital(product); err != nil {
+		return err
+	}
+
+	repository.PrepareStatus(product)
+
+	return repository.PrepareBundle(db.GetCollection(database, "products"), product)
+}
+
+func isProductValidationError(err error) bool {
+	return errors.Is(err, repository.ErrInvalidAttribute) || errors.Is(err, repository.ErrInvalidBundle) ||
+		errors.Is(err, repository.ErrInvalidDigital) || errors.Is(err, repository.ErrStockByLocation)
 }
@@ -0,0 +1,557 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/repository"
+	util "github.com/Emmrys-Jay/ecommerce-api/util"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+
+	// listSeparator separates multiple values (pictures, videos, features, tags, attributes) inside one csv cell
+	listSeparator = "|"
+
+	// attributeSeparator separates the key and value of an attribute, e.g. "color=red|screen_size=15.6"
+	attributeSeparator = "="
+)
+
+// productCSVHeader is the column layout used for csv imports and exports
+var productCSVHeader = []string{
+	"name", "sku", "slug", "price", "currency", "quantity", "description", "category",
+	"pictures", "videos", "features", "slashed_price", "minimum_order",
+	"maximum_per_order", "low_stock_threshold", "tags", "attributes", "status", "publish_at",
+}
+
+// importRow is a single parsed row of an import file. Row numbers count data rows from 1.
+type importRow struct {
+	Row     int
+	Product entity.Product
+	Err     error
+
+	// Fields are the csv columns or json keys the row carries. Only these change an existing product.
+	Fields []string
+}
+
+// ImportProducts accepts a csv or ndjson file of products and upserts them by sku or name in a background job.
+// The format is taken from the "format" query param or the request Content-Type.
+func (a *AdminController) ImportProducts(ctx *gin.Context) {
+	database := a.UserController.Database
+
+	format := importFormat(ctx)
+	if format == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "unsupported format - use csv or ndjson"})
+		return
+	}
+
+	data, err := ctx.GetRawData()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	var rows []importRow
+	if format == formatCSV {
+		rows, err = parseProductsCSV(data)
+	} else {
+		rows, err = parseProductsNDJSON(data)
+	}
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	if len(rows) == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "no products found in file"})
+		return
+	}
+
+	adminID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	job := entity.ImportJob{
+		ID:        primitive.NewObjectIDFromTimestamp(time.Now()).Hex(),
+		Format:    format,
+		Status:    entity.ImportStatusPending,
+		TotalRows: len(rows),
+		Errors:    []entity.ImportRowError{},
+		CreatedBy: adminID,
+		CreatedAt: time.Now(),
+	}
+
+	_, err = repository.CreateImportJob(db.GetCollection(database, "import_jobs"), job)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	go runProductImport(database, job, rows)
+
+	ctx.JSON(http.StatusAccepted, job)
+}
+
+// GetImportJob returns the progress and per-row error report of a product import
+func (a *AdminController) GetImportJob(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "import_jobs")
+
+	jobID := ctx.Param("job-id")
+	if jobID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - no job id specified"})
+		return
+	}
+
+	job, err := repository.GetImportJob(collection, jobID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, job)
+}
+
+// ExportProducts streams the whole catalogue as csv or ndjson, selected with the "format" query param
+func (a *AdminController) ExportProducts(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "products")
+
+	format := ctx.DefaultQuery("format", formatCSV)
+	if format != formatCSV && format != formatNDJSON {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "unsupported format - use csv or ndjson"})
+		return
+	}
+
+	filename := fmt.Sprintf("products-%s.%s", time.Now().Format("20060102"), format)
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	var err error
+	if format == formatCSV {
+		ctx.Header("Content-Type", "text/csv")
+		ctx.Status(http.StatusOK)
+
+		w := csv.NewWriter(ctx.Writer)
+		if err = w.Write(productCSVHeader); err != nil {
+			return
+		}
+
+		err = repository.StreamProducts(collection, func(p entity.Product) error {
+			if err := w.Write(productToCSVRecord(p)); err != nil {
+				return err
+			}
+			w.Flush()
+			return w.Error()
+		})
+		w.Flush()
+	} else {
+		ctx.Header("Content-Type", "application/x-ndjson")
+		ctx.Status(http.StatusOK)
+
+		enc := json.NewEncoder(ctx.Writer)
+		err = repository.StreamProducts(collection, func(p entity.Product) error {
+			if err := enc.Encode(p); err != nil {
+				return err
+			}
+			ctx.Writer.Flush()
+			return nil
+		})
+	}
+
+	// Headers are already sent at this point, so the only thing left to do is stop the stream
+	if err != nil {
+		_ = ctx.Error(err)
+	}
+}
+
+// runProductImport validates and upserts every parsed row, recording failures against their row number
+func runProductImport(database *mongo.Database, job entity.ImportJob, rows []importRow) {
+	jobsCollection := db.GetCollection(database, "import_jobs")
+	productsCollection := db.GetCollection(database, "products")
+
+	job.Status = entity.ImportStatusRunning
+	_ = repository.SaveImportJob(jobsCollection, &job)
+
+	for _, row := range rows {
+		err := row.Err
+		if err == nil {
+			err = prepareImportRow(database, &row)
+		}
+
+		if err == nil {
+			var updated bool
+			updated, err = repository.UpsertProduct(productsCollection, row.Product, row.Fields, job.CreatedBy, job.ID)
+			if err == nil {
+				if updated {
+					job.Updated++
+				} else {
+					job.Inserted++
+				}
+				continue
+			}
+
+			if mongo.IsDuplicateKeyError(err) {
+				err = errors.New("a product with this name or sku already exists")
+			}
+		}
+
+		job.Failed++
+		job.Errors = append(job.Errors, entity.ImportRowError{
+			Row:   row.Row,
+			Name:  row.Product.Name,
+			SKU:   row.Product.SKU,
+			Error: err.Error(),
+		})
+	}
+
+	job.Status = entity.ImportStatusCompleted
+	if job.Failed == job.TotalRows {
+		job.Status = entity.ImportStatusFailed
+	}
+	job.FinishedAt = time.Now()
+
+	_ = repository.SaveImportJob(jobsCollection, &job)
+}
+
+// prepareImportRow validates and prepares the product of a row. A row that creates a product must carry every
+// required field; a row that updates one is laid over the stored product first and the result is validated.
+func prepareImportRow(database *mongo.Database, row *importRow) error {
+	existing, err := repository.FindImportedProduct(db.GetCollection(database, "products"), row.Product)
+	if err == mongo.ErrNoDocuments {
+		if err := binding.Validator.ValidateStruct(&row.Product); err != nil {
+			return err
+		}
+		return prepareProduct(database, &row.Product)
+	}
+	if err != nil {
+		return err
+	}
+
+	merged, err := mergeImportRow(existing, *row)
+	if err != nil {
+		return err
+	}
+
+	if err := prepareProduct(database, merged); err != nil {
+		return err
+	}
+
+	// The sku, slug and status are only changed when the row says so, which UpsertProduct reads off the row
+	repository.PrepareStatus(&row.Product)
+	merged.SKU, merged.Slug = row.Product.SKU, row.Product.Slug
+	merged.Status, merged.PublishAt = row.Product.Status, row.Product.PublishAt
+
+	row.Product = *merged
+	return nil
+}
+
+// mergeImportRow lays the fields an import row carries over the stored product. A column holds the whole value
+// of its field, so carried fields are replaced rather than merged into, and the result is checked like a PATCH.
+func mergeImportRow(existing *entity.Product, row importRow) (*entity.Product, error) {
+	current, err := json.Marshal(existing)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := json.Marshal(row.Product)
+	if err != nil {
+		return nil, err
+	}
+
+	var stored, carried map[string]json.RawMessage
+	if err := json.Unmarshal(current, &stored); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(values, &carried); err != nil {
+		return nil, err
+	}
+
+	patch := make(map[string]json.RawMessage)
+	for _, f := range row.Fields {
+		if !repository.IsImportedField(f) && f != "quantity" && f != "status" && f != "publish_at" {
+			continue
+		}
+
+		delete(stored, f)
+		if v, ok := carried[f]; ok {
+			patch[f] = v
+		}
+	}
+
+	cleared, err := json.Marshal(stored)
+	if err != nil {
+		return nil, err
+	}
+
+	var base entity.Product
+	if err := json.Unmarshal(cleared, &base); err != nil {
+		return nil, err
+	}
+
+	return mergeProductPatch(&base, patch)
+}
+
+func importFormat(ctx *gin.Context) string {
+	format := strings.ToLower(ctx.Query("format"))
+	if format == "" {
+		contentType := ctx.ContentType()
+		switch {
+		case strings.Contains(contentType, "csv"):
+			format = formatCSV
+		case strings.Contains(contentType, "ndjson"), strings.Contains(contentType, "jsonlines"):
+			format = formatNDJSON
+		}
+	}
+
+	if format != formatCSV && format != formatNDJSON {
+		return ""
+	}
+
+	return format
+}
+
+func parseProductsCSV(data []byte) ([]importRow, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read csv header: %v", err)
+	}
+
+	columns := make(map[string]int)
+	fields := make([]string, 0, len(header))
+	for i, h := range header {
+		column := strings.ToLower(strings.TrimSpace(h))
+		columns[column] = i
+		fields = append(fields, column)
+	}
+
+	if _, ok := columns["name"]; !ok {
+		return nil, errors.New("csv header must contain a name column")
+	}
+
+	var rows []importRow
+	for n := 1; ; n++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			// A malformed line is reported against its row, the rest of the file is still imported
+			if _, ok := err.(*csv.ParseError); ok {
+				rows = append(rows, importRow{Row: n, Err: err})
+				continue
+			}
+			return nil, err
+		}
+
+		product, err := productFromCSVRecord(columns, record)
+		rows = append(rows, importRow{Row: n, Product: product, Err: err, Fields: fields})
+	}
+
+	return rows, nil
+}
+
+func productFromCSVRecord(columns map[string]int, record []string) (entity.Product, error) {
+	var product entity.Product
+	var err error
+
+	get := func(column string) string {
+		i, ok := columns[column]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	product.Name = get("name")
+	product.SKU = get("sku")
+	product.Slug = get("slug")
+	product.Currency = get("currency")
+	product.Description = get("description")
+	product.Category = get("category")
+	product.Pictures = splitList(get("pictures"))
+	product.Videos = splitList(get("videos"))
+
+	for _, f := range splitList(get("features")) {
+		product.Features = append(product.Features, entity.Feature{F: f})
+	}
+
+	product.Tags = splitList(get("tags"))
+	product.Status = get("status")
+
+	// Values are read as text and converted to the types of the category schema during validation
+	for _, a := range splitList(get("attributes")) {
+		kv := strings.SplitN(a, attributeSeparator, 2)
+		if len(kv) != 2 {
+			return product, fmt.Errorf("invalid attribute %q", a)
+		}
+
+		if product.Attributes == nil {
+			product.Attributes = make(map[string]interface{})
+		}
+		product.Attributes[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	if v := get("price"); v != "" {
+		if product.Price, err = strconv.ParseFloat(v, 64); err != nil {
+			return product, fmt.Errorf("invalid price %q", v)
+		}
+	}
+
+	if v := get("quantity"); v != "" {
+		if product.Quantity, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return product, fmt.Errorf("invalid quantity %q", v)
+		}
+	}
+
+	if v := get("slashed_price"); v != "" {
+		if product.SlashedPrice, err = strconv.ParseFloat(v, 64); err != nil {
+			return product, fmt.Errorf("invalid slashed_price %q", v)
+		}
+	}
+
+	if v := get("minimum_order"); v != "" {
+		if product.MinimumOrder, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return product, fmt.Errorf("invalid minimum_order %q", v)
+		}
+	}
+
+	if v := get("maximum_per_order"); v != "" {
+		if product.MaximumPerOrder, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return product, fmt.Errorf("invalid maximum_per_order %q", v)
+		}
+	}
+
+	if v := get("low_stock_threshold"); v != "" {
+		if product.LowStockThreshold, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return product, fmt.Errorf("invalid low_stock_threshold %q", v)
+		}
+	}
+
+	if v := get("publish_at"); v != "" {
+		if product.PublishAt, err = time.Parse(time.RFC3339, v); err != nil {
+			return product, fmt.Errorf("invalid publish_at %q, use RFC 3339", v)
+		}
+	}
+
+	return product, nil
+}
+
+func productToCSVRecord(p entity.Product) []string {
+	features := make([]string, 0, len(p.Features))
+	for _, f := range p.Features {
+		features = append(features, f.F)
+	}
+
+	keys := make([]string, 0, len(p.Attributes))
+	for k := range p.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	attributes := make([]string, 0, len(keys))
+	for _, k := range keys {
+		attributes = append(attributes, fmt.Sprintf("%s%s%v", k, attributeSeparator, p.Attributes[k]))
+	}
+
+	var publishAt string
+	if !p.PublishAt.IsZero() {
+		publishAt = p.PublishAt.Format(time.RFC3339)
+	}
+
+	return []string{
+		p.Name,
+		p.SKU,
+		p.Slug,
+		strconv.FormatFloat(p.Price, 'f', -1, 64),
+		p.Currency,
+		strconv.FormatInt(p.Quantity, 10),
+		p.Description,
+		p.Category,
+		strings.Join(p.Pictures, listSeparator),
+		strings.Join(p.Videos, listSeparator),
+		strings.Join(features, listSeparator),
+		strconv.FormatFloat(p.SlashedPrice, 'f', -1, 64),
+		strconv.FormatInt(p.MinimumOrder, 10),
+		strconv.FormatInt(p.MaximumPerOrder, 10),
+		strconv.FormatInt(p.LowStockThreshold, 10),
+		strings.Join(p.Tags, listSeparator),
+		strings.Join(attributes, listSeparator),
+		p.Status,
+		publishAt,
+	}
+}
+
+func parseProductsNDJSON(data []byte) ([]importRow, error) {
+	var rows []importRow
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	n := 0
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		n++
+
+		var product entity.Product
+		var keys map[string]json.RawMessage
+
+		err := json.Unmarshal(line, &product)
+		if err == nil {
+			err = json.Unmarshal(line, &keys)
+		}
+
+		fields := make([]string, 0, len(keys))
+		for k := range keys {
+			fields = append(fields, k)
+		}
+
+		rows = append(rows, importRow{Row: n, Product: product, Err: err, Fields: fields})
+	}
+
+	return rows, scanner.Err()
+}
+
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+
+	var list []string
+	for _, v := range strings.Split(value, listSeparator) {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+
+	return list
+}
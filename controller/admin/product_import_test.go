@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const importCSV = `name,sku,price,currency,quantity,description,category
+Desk Lamp,LAMP-1,25,CAD,10,A lamp for desks,lighting
+Floor Lamp,LAMP-2,not a price,CAD,5,A lamp for floors,lighting
+Wall Lamp,LAMP-3,30,CAD,5,,lighting
+`
+
+// importProductsTest imports a csv file and waits for the job to finish
+func importProductsTest(t *testing.T, details *ServerDB, token, file string) entity.ImportJob {
+	req, err := http.NewRequest("POST", "/admin/products/import?format=csv", bytes.NewBufferString(file))
+	require.NoError(t, err)
+	req.Header.Add("Authorization", "Bearer "+token)
+
+	recorder := httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusAccepted, recorder.Code)
+
+	var job entity.ImportJob
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &job))
+
+	for i := 0; i < 50; i++ {
+		req, err := http.NewRequest("GET", "/admin/products/import/"+job.ID, nil)
+		require.NoError(t, err)
+		req.Header.Add("Authorization", "Bearer "+token)
+
+		recorder := httptest.NewRecorder()
+		details.Server.ServeHTTP(recorder, req)
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &job))
+
+		if job.Status == entity.ImportStatusCompleted || job.Status == entity.ImportStatusFailed {
+			return job
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	t.Fatalf("import %s did not finish", job.ID)
+	return job
+}
+
+func TestImportProductsTwice(t *testing.T) {
+	details := NewServerDB()
+	initializeAdminProductRoutes(details)
+	token := adminToken(t)
+
+	ctx := context.Background()
+	products := details.Db.Collection("products")
+
+	job := importProductsTest(t, details, token, importCSV)
+	require.Equal(t, entity.ImportStatusCompleted, job.Status)
+	require.Equal(t, 3, job.TotalRows)
+	require.Equal(t, 1, job.Inserted)
+	require.Equal(t, 0, job.Updated)
+	require.Equal(t, 2, job.Failed)
+
+	// Rejected rows are reported by row number
+	require.Len(t, job.Errors, 2)
+	require.Equal(t, 2, job.Errors[0].Row)
+	require.Contains(t, job.Errors[0].Error, "invalid price")
+	require.Equal(t, 3, job.Errors[1].Row)
+	require.Equal(t, "LAMP-3", job.Errors[1].SKU)
+
+	var created entity.Product
+	require.NoError(t, products.FindOne(ctx, bson.M{"sku": "LAMP-1"}).Decode(&created))
+	require.Equal(t, "Desk Lamp", created.Name)
+	require.Equal(t, int64(10), created.Quantity)
+	require.NotEmpty(t, created.Slug)
+
+	// Fields the file does not carry belong to the store, and orders take stock between imports
+	_, err := products.UpdateOne(ctx, bson.M{"_id": created.ID}, bson.M{
+		"$set": bson.M{"no_of_reviews": 4, "average_rating": 4.5, "tags": []string{"kept"}, "prices": bson.M{"EUR": 17.0}},
+		"$inc": bson.M{"quantity": -3},
+	})
+	require.NoError(t, err)
+
+	job = importProductsTest(t, details, token, importCSV)
+	require.Equal(t, 1, job.Updated)
+	require.Equal(t, 0, job.Inserted)
+	require.Equal(t, 2, job.Failed)
+
+	var updated entity.Product
+	require.NoError(t, products.FindOne(ctx, bson.M{"_id": created.ID}).Decode(&updated))
+	require.Equal(t, int64(10), updated.Quantity)
+	require.Equal(t, created.Slug, updated.Slug)
+	require.Equal(t, int64(4), updated.NoOfReviews)
+	require.Equal(t, 4.5, updated.AverageRating)
+	require.Equal(t, []string{"kept"}, updated.Tags)
+	require.Equal(t, 17.0, updated.Prices["EUR"])
+	require.Equal(t, created.CreatedAt.Unix(), updated.CreatedAt.Unix())
+
+	count, err := products.CountDocuments(ctx, bson.M{"sku": "LAMP-1"})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), count)
+
+	// The ledger records the stock the import put back, measured from what was left after the orders
+	var movement entity.StockMovement
+	err = details.Db.Collection("stock_ledger").FindOne(ctx, bson.M{
+		"product_id": created.ID,
+		"reason":     entity.StockAdjustment,
+	}).Decode(&movement)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), movement.Delta)
+	require.Equal(t, job.ID, movement.ReferenceID)
+
+	dropDatabase(details.Db)
+}
+
+func TestImportProductsPartialUpdate(t *testing.T) {
+	details := NewServerDB()
+	initializeAdminProductRoutes(details)
+	token := adminToken(t)
+
+	ctx := context.Background()
+	products := details.Db.Collection("products")
+
+	job := importProductsTest(t, details, token, importCSV)
+	require.Equal(t, 1, job.Inserted)
+
+	// Updates only carry the columns they change, while new products still need every required one
+	job = importProductsTest(t, details, token, "name,sku,price\nDesk Lamp,LAMP-1,20\nTable Lamp,LAMP-9,15\n")
+	require.Equal(t, 1, job.Updated)
+	require.Equal(t, 0, job.Inserted)
+	require.Equal(t, 1, job.Failed)
+	require.Len(t, job.Errors, 1)
+	require.Equal(t, 2, job.Errors[0].Row)
+
+	var updated entity.Product
+	require.NoError(t, products.FindOne(ctx, bson.M{"sku": "LAMP-1"}).Decode(&updated))
+	require.Equal(t, 20.0, updated.Price)
+	require.Equal(t, "A lamp for desks", updated.Description)
+	require.Equal(t, "lighting", updated.Category)
+	require.Equal(t, int64(10), updated.Quantity)
+
+	// A carried column replaces the stored value, so an empty required one is rejected
+	job = importProductsTest(t, details, token, "name,sku,description\nDesk Lamp,LAMP-1,\n")
+	require.Equal(t, entity.ImportStatusFailed, job.Status)
+	require.Equal(t, 1, job.Failed)
+
+	require.NoError(t, products.FindOne(ctx, bson.M{"sku": "LAMP-1"}).Decode(&updated))
+	require.Equal(t, "A lamp for desks", updated.Description)
+
+	dropDatabase(details.Db)
+}
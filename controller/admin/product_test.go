@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func patchProductTest(t *testing.T, details *ServerDB, token, productID, patch string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("PATCH", "/admin/products/"+productID, bytes.NewBufferString(patch))
+	require.NoError(t, err)
+	req.Header.Add("Authorization", "Bearer "+token)
+
+	recorder := httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestUpdateProductPatch(t *testing.T) {
+	details := NewServerDB()
+	initializeAdminProductRoutes(details)
+	token := adminToken(t)
+
+	product := entity.Product{
+		ID:          primitive.NewObjectID().Hex(),
+		Name:        "Desk Lamp",
+		SKU:         "LAMP-1",
+		Slug:        "desk-lamp",
+		Price:       25,
+		Currency:    "CAD",
+		Quantity:    10,
+		Description: "A lamp for desks",
+		Category:    "lighting",
+		Tags:        []string{"desk"},
+		NoOfReviews: 3,
+	}
+	_, err := details.Db.Collection("products").InsertOne(context.Background(), product)
+	require.NoError(t, err)
+
+	var updated entity.Product
+	decode := func(recorder *httptest.ResponseRecorder) {
+		require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+		updated = entity.Product{}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &updated))
+	}
+
+	// quantity sets the stock, quantity_delta adds to whatever it is when the write happens
+	decode(patchProductTest(t, details, token, product.ID, `{"quantity": 15}`))
+	require.Equal(t, int64(15), updated.Quantity)
+
+	decode(patchProductTest(t, details, token, product.ID, `{"quantity_delta": -5}`))
+	require.Equal(t, int64(10), updated.Quantity)
+
+	// A delta may not take the stock below zero, and the two cannot be combined
+	recorder := patchProductTest(t, details, token, product.ID, `{"quantity_delta": -50}`)
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	recorder = patchProductTest(t, details, token, product.ID, `{"quantity": 5, "quantity_delta": 1}`)
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	recorder = patchProductTest(t, details, token, product.ID, `{"quantity": -1}`)
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	// Fields owned by the store cannot be patched
+	recorder = patchProductTest(t, details, token, product.ID, `{"no_of_reviews": 9}`)
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	// A rename gets a new slug, null removes a field and everything else is left alone
+	decode(patchProductTest(t, details, token, product.ID, `{"name": "Reading Lamp", "tags": null}`))
+	require.Equal(t, "Reading Lamp", updated.Name)
+	require.Equal(t, "reading-lamp", updated.Slug)
+	require.Contains(t, updated.PreviousSlugs, "desk-lamp")
+	require.Empty(t, updated.Tags)
+	require.Equal(t, int64(10), updated.Quantity)
+	require.Equal(t, product.SKU, updated.SKU)
+	require.Equal(t, product.Price, updated.Price)
+	require.Equal(t, product.Description, updated.Description)
+	require.Equal(t, product.Category, updated.Category)
+	require.Equal(t, product.NoOfReviews, updated.NoOfReviews)
+
+	// The old slug still leads to the product
+	req, err := http.NewRequest("GET", "/products/slug/desk-lamp", nil)
+	require.NoError(t, err)
+
+	recorder = httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusMovedPermanently, recorder.Code)
+	require.Equal(t, "/products/slug/reading-lamp", recorder.Header().Get("Location"))
+
+	dropDatabase(details.Db)
+}
@@ -0,0 +1,181 @@
+package controller
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/repository"
+	util "github.com/Emmrys-Jay/ecommerce-api/util"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AnswerQuestion answers a product question on behalf of the store
+func (a *AdminController) AnswerQuestion(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "answers")
+	var req entity.QuestionRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	questionID := ctx.Param("question-id")
+	if questionID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - no id specified"})
+		return
+	}
+
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	username, err := util.UsernameFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	answer, err := repository.CreateAnswer(collection, questionID, userID, username, req.Body, true)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, answer)
+}
+
+// GetQAModerationQueue returns questions waiting for moderation, oldest first.
+// Answers are listed with "type=answers" and other queues with the "status" query param.
+func (a *AdminController) GetQAModerationQueue(ctx *gin.Context) {
+	database := a.UserController.Database
+	var pageID, pageSize = 1, 10
+	var err error
+
+	status := ctx.DefaultQuery("status", entity.ModerationPending)
+	if !isModerationStatus(status) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid params - status"})
+		return
+	}
+
+	contentType := ctx.DefaultQuery("type", "questions")
+	if contentType != "questions" && contentType != "answers" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid params - type"})
+		return
+	}
+
+	pageIDString := ctx.Query("page_id")
+	if pageIDString != "" {
+		pageID, err = strconv.Atoi(pageIDString)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Could not parse page_id"})
+			return
+		}
+	}
+
+	if pageID < 1 {
+		pageID = 1
+	}
+
+	var data any
+	var length int64
+	if contentType == "questions" {
+		data, length, err = repository.GetQuestionsByStatus(db.GetCollection(database, "questions"), status, pageSize*(pageID-1), pageSize)
+	} else {
+		data, length, err = repository.GetAnswersByStatus(db.GetCollection(database, "answers"), status, pageSize*(pageID-1), pageSize)
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	response := entity.PaginationResponse{
+		PageID:        pageID,
+		NumberOfPages: int(math.Ceil(float64(length) / float64(pageSize))),
+		ResultsFound:  int(length),
+		Data:          data,
+	}
+
+	if response.NumberOfPages < 1 {
+		response.PageID = 0
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// ModerateQuestion approves or rejects a question
+func (a *AdminController) ModerateQuestion(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "questions")
+	var req ModerateRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	if req.Status != entity.ModerationApproved && req.Status != entity.ModerationRejected {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "status must be approved or rejected"})
+		return
+	}
+
+	questionID := ctx.Param("question-id")
+	if questionID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - no id specified"})
+		return
+	}
+
+	question, err := repository.ModerateQuestion(collection, questionID, req.Status, req.Note)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, question)
+}
+
+// ModerateAnswer approves or rejects an answer
+func (a *AdminController) ModerateAnswer(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "answers")
+	var req ModerateRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	if req.Status != entity.ModerationApproved && req.Status != entity.ModerationRejected {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "status must be approved or rejected"})
+		return
+	}
+
+	answerID := ctx.Param("answer-id")
+	if answerID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - no id specified"})
+		return
+	}
+
+	answer, err := repository.ModerateAnswer(collection, answerID, req.Status, req.Note)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, answer)
+}
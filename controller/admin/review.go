@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/repository"
+	util "github.com/Emmrys-Jay/ecommerce-api/util"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// GetReviewModerationQueue returns reviews waiting for moderation, most reported first.
+// A different queue can be viewed with the "status" query param.
+func (a *AdminController) GetReviewModerationQueue(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "reviews")
+	var pageID, pageSize = 1, 10
+	var err error
+
+	status := ctx.DefaultQuery("status", entity.ModerationPending)
+	if !isModerationStatus(status) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid params - status"})
+		return
+	}
+
+	pageIDString := ctx.Query("page_id")
+	if pageIDString != "" {
+		pageID, err = strconv.Atoi(pageIDString)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Could not parse page_id"})
+			return
+		}
+	}
+
+	if pageID < 1 {
+		pageID = 1
+	}
+
+	reviews, length, err := repository.GetReviewsByStatus(collection, status, pageSize*(pageID-1), pageSize)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	response := entity.PaginationResponse{
+		PageID:        pageID,
+		NumberOfPages: int(math.Ceil(float64(length) / float64(pageSize))),
+		ResultsFound:  int(length),
+		Data:          reviews,
+	}
+
+	if response.NumberOfPages < 1 {
+		response.PageID = 0
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// ModerateRequest models an admin's moderation decision
+type ModerateRequest struct {
+	Status string `json:"status" binding:"required"`
+	Note   string `json:"note"`
+}
+
+// ModerateReview approves or rejects a review
+func (a *AdminController) ModerateReview(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "reviews")
+	var req ModerateRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	if req.Status != entity.ModerationApproved && req.Status != entity.ModerationRejected {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "status must be approved or rejected"})
+		return
+	}
+
+	reviewID := ctx.Param("review-id")
+	if reviewID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - no id specified"})
+		return
+	}
+
+	review, err := repository.ModerateReview(collection, reviewID, req.Status, req.Note)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, review)
+}
+
+func isModerationStatus(status string) bool {
+	switch status {
+	case entity.ModerationPending, entity.ModerationApproved, entity.ModerationRejected:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/repository"
+	util "github.com/Emmrys-Jay/ecommerce-api/util"
+	"github.com/gin-gonic/gin"
+)
+
+// GetLowStockReport returns products at or below their low stock threshold, lowest stock first
+func (a *AdminController) GetLowStockReport(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "products")
+
+	pageID, pageSize, ok := reportPage(ctx)
+	if !ok {
+		return
+	}
+
+	products, length, err := repository.GetLowStockProducts(collection, pageSize*(pageID-1), pageSize)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	response := entity.PaginationResponse{
+		PageID:        pageID,
+		NumberOfPages: int(math.Ceil(float64(length) / float64(pageSize))),
+		ResultsFound:  int(length),
+		Data:          products,
+	}
+
+	if response.NumberOfPages < 1 {
+		response.PageID = 0
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// GetStockAlerts returns open low stock alerts, newest first. Resolved alerts are returned with "resolved=true".
+func (a *AdminController) GetStockAlerts(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "stock_alerts")
+
+	resolved, err := strconv.ParseBool(ctx.DefaultQuery("resolved", "false"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid params - resolved"})
+		return
+	}
+
+	pageID, pageSize, ok := reportPage(ctx)
+	if !ok {
+		return
+	}
+
+	alerts, length, err := repository.GetStockAlerts(collection, resolved, pageSize*(pageID-1), pageSize)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	response := entity.PaginationResponse{
+		PageID:        pageID,
+		NumberOfPages: int(math.Ceil(float64(length) / float64(pageSize))),
+		ResultsFound:  int(length),
+		Data:          alerts,
+	}
+
+	if response.NumberOfPages < 1 {
+		response.PageID = 0
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// reportPage reads the page_id and page_size query params of an admin report, writing a 400 response when they are invalid
+func reportPage(ctx *gin.Context) (int, int, bool) {
+	var pageID, pageSize = 1, 20
+	var err error
+
+	if v := ctx.Query("page_id"); v != "" {
+		pageID, err = strconv.Atoi(v)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Could not parse page_id"})
+			return 0, 0, false
+		}
+	}
+
+	if v := ctx.Query("page_size"); v != "" {
+		pageSize, err = strconv.Atoi(v)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Could not parse page_size"})
+			return 0, 0, false
+		}
+	}
+
+	if pageID < 1 {
+		pageID = 1
+	}
+
+	if pageSize < 5 {
+		pageSize = 5
+	}
+
+	return pageID, pageSize, true
+}
@@ -0,0 +1,35 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/repository"
+	util "github.com/Emmrys-Jay/ecommerce-api/util"
+	"github.com/gin-gonic/gin"
+)
+
+// GetWishlistReport returns the most wishlisted products. The number of products is set with the "limit" query param.
+func (a *AdminController) GetWishlistReport(ctx *gin.Context) {
+	collection := db.GetCollection(a.UserController.Database, "users")
+	var limit = 20
+	var err error
+
+	limitString := ctx.Query("limit")
+	if limitString != "" {
+		limit, err = strconv.Atoi(limitString)
+		if err != nil || limit < 1 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid params - limit"})
+			return
+		}
+	}
+
+	entries, err := repository.GetMostWishlistedProducts(collection, limit)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"data": entries})
+}
@@ -1,11 +1,13 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"net/http"
 	"strconv"
 
+	"github.com/Emmrys-Jay/ecommerce-api/currency"
 	"github.com/Emmrys-Jay/ecommerce-api/db"
 	"github.com/Emmrys-Jay/ecommerce-api/entity"
 	"github.com/Emmrys-Jay/ecommerce-api/repository"
@@ -16,11 +18,23 @@ import (
 
 type AddToCartRequest struct {
 	ProductID string `json:"product_id" form:"product_id"`
-	Quantity  int64  `json:"quantity" form:"quantity,min=1"`
+	Quantity  int64  `json:"quantity" form:"quantity" binding:"omitempty,min=1"`
 }
 
-// AddToCart response to add to cart requests from a user
+// AddToCart response to add to cart requests from a user. Adding a product that is already
+// in the cart increases its quantity.
 func (u *UserController) AddToCart(ctx *gin.Context) {
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	u.addToCart(ctx, userID)
+}
+
+// addToCart adds the product in the request to the cart owned by ownerID
+func (u *UserController) addToCart(ctx *gin.Context, ownerID string) {
 	collection := db.GetCollection(u.Database, "cart")
 	var req AddToCartRequest
 
@@ -38,28 +52,33 @@ func (u *UserController) AddToCart(ctx *gin.Context) {
 		req.Quantity = 1
 	}
 
-	userID, err := util.UserIDFromToken(ctx)
+	item, err := repository.AddToCart(collection, req.Quantity, req.ProductID, ownerID)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "could not get logged in user from token"})
-		return
-	}
-
-	result, err := repository.AddToCart(collection, req.Quantity, req.ProductID, userID)
-	if err != nil {
-		if mongo.IsDuplicateKeyError(err) {
-			ctx.JSON(http.StatusBadRequest, gin.H{"error": "user already exists"})
+		if err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "product does not exist"})
 			return
 		}
 		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
 		return
 	}
 
-	response := fmt.Sprintf("added %d products successfully to cart with id: %s", req.Quantity, result.InsertedID)
-	ctx.JSON(http.StatusOK, gin.H{"result": response})
+	response := fmt.Sprintf("added %d products successfully to cart with id: %s", req.Quantity, item.ID)
+	ctx.JSON(http.StatusOK, gin.H{"result": response, "quantity": item.Quantity})
 }
 
 // RemoveFromCart totally removes a product associated with a particular user from cart
 func (u *UserController) RemoveFromCart(ctx *gin.Context) {
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	u.removeFromCart(ctx, userID)
+}
+
+// removeFromCart removes a line from the cart owned by ownerID
+func (u *UserController) removeFromCart(ctx *gin.Context, ownerID string) {
 	collection := db.GetCollection(u.Database, "cart")
 
 	cartItemID := ctx.Param("cart-id")
@@ -69,13 +88,7 @@ func (u *UserController) RemoveFromCart(ctx *gin.Context) {
 		return
 	}
 
-	username, err := util.UserIDFromToken(ctx)
-	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "could not get logged in user from token"})
-		return
-	}
-
-	result, err := repository.RemoveFromCart(collection, cartItemID, username)
+	result, err := repository.RemoveFromCart(collection, cartItemID, ownerID)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
 		return
@@ -91,11 +104,22 @@ func (u *UserController) RemoveFromCart(ctx *gin.Context) {
 
 type UpdateCartRequest struct {
 	CartID   string `json:"cart_id" form:"cart_id"`
-	Quantity int    `json:"quantity" form:"quantity,min=1"`
+	Quantity int    `json:"quantity" form:"quantity" binding:"required,min=1"`
 }
 
 // UpdateCartQuantity changes the quantity of products stored in cart
 func (u *UserController) UpdateCartQuantity(ctx *gin.Context) {
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	u.updateCartQuantity(ctx, userID)
+}
+
+// updateCartQuantity sets the quantity of a line in the cart owned by ownerID
+func (u *UserController) updateCartQuantity(ctx *gin.Context, ownerID string) {
 	collection := db.GetCollection(u.Database, "cart")
 	var req UpdateCartRequest
 
@@ -114,14 +138,12 @@ func (u *UserController) UpdateCartQuantity(ctx *gin.Context) {
 		return
 	}
 
-	username, err := util.UsernameFromToken(ctx)
-	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "could not get logged in user from token"})
-		return
-	}
-
-	_, err = repository.UpdateCartQuantity(collection, req.Quantity, req.CartID, username)
+	_, err := repository.UpdateCartQuantity(collection, req.Quantity, req.CartID, ownerID)
 	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, gin.H{"not found": "specified params did not match any document"})
+			return
+		}
 		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
 		return
 	}
@@ -135,10 +157,23 @@ type GetUserCartItemsResult struct {
 	ResultsFound  int               `json:"results_found"`
 	NumberOfPages int               `json:"no_of_pages"`
 	Data          []entity.CartItem `json:"data"`
+	// SavedForLater is not paged and does not count toward checkout
+	SavedForLater []entity.SavedItem `json:"saved_for_later"`
 }
 
 // GetUserCartItems gets all products stored in a users cart
 func (u *UserController) GetUserCartItems(ctx *gin.Context) {
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	u.getCartItems(ctx, userID)
+}
+
+// getCartItems responds with a page of the cart owned by ownerID
+func (u *UserController) getCartItems(ctx *gin.Context, ownerID string) {
 	collection := db.GetCollection(u.Database, "cart")
 	var pageID, pageSize = 1, 5
 	var err error
@@ -152,9 +187,8 @@ func (u *UserController) GetUserCartItems(ctx *gin.Context) {
 		}
 	}
 
-	userID, err := util.UserIDFromToken(ctx)
-	if err != nil {
-		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+	displayCode, ok := displayCurrency(ctx, ctx.Query("currency"))
+	if !ok {
 		return
 	}
 
@@ -166,7 +200,7 @@ func (u *UserController) GetUserCartItems(ctx *gin.Context) {
 		Limit:  pageSize,
 	}
 
-	cartItems, length, err := repository.GetUserCartItems(collection, userID, param.Offset, param.Limit)
+	cartItems, length, err := repository.GetUserCartItems(collection, ownerID, param.Offset, param.Limit)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			ctx.JSON(http.StatusNotFound, gin.H{"not found": "No items in cart currently"})
@@ -176,11 +210,49 @@ func (u *UserController) GetUserCartItems(ctx *gin.Context) {
 		return
 	}
 
+	products := make([]entity.Product, len(cartItems))
+	for i := range cartItems {
+		products[i] = cartItems[i].Product
+	}
+
+	if !applyDisplayCurrency(ctx, u.Database, products, displayCode) {
+		return
+	}
+
+	for i := range cartItems {
+		cartItems[i].Product.Display = products[i].Display
+	}
+
+	saved, err := repository.GetSavedItems(db.GetCollection(u.Database, "saved_items"), ownerID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	savedProducts := make([]entity.Product, 0, len(saved))
+	for _, item := range saved {
+		if item.Product != nil {
+			savedProducts = append(savedProducts, *item.Product)
+		}
+	}
+
+	if !applyDisplayCurrency(ctx, u.Database, savedProducts, displayCode) {
+		return
+	}
+
+	for i, j := 0, 0; i < len(saved); i++ {
+		if saved[i].Product != nil {
+			saved[i].Product.Display = savedProducts[j].Display
+			j++
+		}
+	}
+
 	response := GetUserCartItemsResult{
 		PageID:        pageID,
 		NumberOfPages: int(math.Ceil(float64(length) / float64(pageSize))),
 		ResultsFound:  int(length),
 		Data:          cartItems,
+		SavedForLater: saved,
 	}
 
 	if response.NumberOfPages < 1 {
@@ -191,3 +263,90 @@ func (u *UserController) GetUserCartItems(ctx *gin.Context) {
 }
 
 // Make ProductName and Username unique
+
+// GetCartSummary prices the logged in user's cart with current product data and returns its totals.
+// Lines whose price changed, or that can no longer be ordered as they are, are flagged.
+func (u *UserController) GetCartSummary(ctx *gin.Context) {
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	u.cartSummary(ctx, userID)
+}
+
+// cartSummary responds with the priced cart owned by ownerID, in the currency query param if one is given
+func (u *UserController) cartSummary(ctx *gin.Context, ownerID string) {
+	code, ok := displayCurrency(ctx, ctx.Query("currency"))
+	if !ok {
+		return
+	}
+
+	summary, err := repository.PriceCart(u.Database, ownerID, code)
+	if err != nil {
+		if errors.Is(err, currency.ErrNoRate) {
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, summary)
+}
+
+// StartCheckout holds the stock of every line in the logged in user's cart for CHECKOUT_HOLD while they
+// check out. Nothing is held when a line cannot be ordered.
+func (u *UserController) StartCheckout(ctx *gin.Context) {
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	reservations, err := repository.ReserveCheckout(u.Database, userID)
+	if err != nil {
+		if repository.IsQuantityError(err) || err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"reservations": reservations})
+}
+
+// GetReservations returns the stock the logged in user currently holds
+func (u *UserController) GetReservations(ctx *gin.Context) {
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	reservations, err := repository.GetReservations(db.GetCollection(u.Database, "reservations"), userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"reservations": reservations})
+}
+
+// LeaveCheckout releases all the stock the logged in user holds
+func (u *UserController) LeaveCheckout(ctx *gin.Context) {
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	if err := repository.ReleaseReservations(u.Database, userID); err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"success": "released held stock"})
+}
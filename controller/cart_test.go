@@ -8,10 +8,12 @@ import (
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/repository"
 	"github.com/stretchr/testify/require"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -22,7 +24,7 @@ func getCartItem(t *testing.T, details *ServerDB, quantity int64, productID, use
 
 	var cart entity.CartItem
 
-	filter := bson.M{"product_id": productID}
+	filter := bson.M{"product_id": productID, "user_id": userID}
 	err := collection.FindOne(context.Background(), filter).Decode(&cart)
 	if len(trigger) > 0 {
 		if trigger[0] == "deleted" {
@@ -84,11 +86,21 @@ func TestAddToCart(t *testing.T) {
 	var quantity int64 = 90
 	addProductToCart(t, details, user, product.ID, quantity)
 
-	_, err := getCartItem(t, details, quantity, product.Name, user.Username)
+	_, err := getCartItem(t, details, quantity, product.ID, user.ID)
 	require.NoError(t, err)
 
-	// Test Unique product name field in cart
-	addProductToCart(t, details, user, product.ID, quantity, "unique")
+	// Adding the product again adds to the same line
+	addProductToCart(t, details, user, product.ID, quantity)
+
+	_, err = getCartItem(t, details, quantity*2, product.ID, user.ID)
+	require.NoError(t, err)
+
+	// Other shoppers can cart the same product
+	other := createUserTest(t, details, "Hermione")
+	addProductToCart(t, details, other, product.ID, quantity)
+
+	_, err = getCartItem(t, details, quantity, product.ID, other.ID)
+	require.NoError(t, err)
 
 	deleteRecords(details.Db, "cart")
 	dropDatabase(details.Db)
@@ -106,7 +118,7 @@ func TestRemoveFromCart(t *testing.T) {
 
 	var quantity int64 = 90
 	addProductToCart(t, details, user, product.ID, quantity)
-	cartItem, err := getCartItem(t, details, quantity, product.Name, user.Username)
+	cartItem, err := getCartItem(t, details, quantity, product.ID, user.ID)
 	require.NotNil(t, cartItem)
 	require.NoError(t, err)
 
@@ -120,7 +132,7 @@ func TestRemoveFromCart(t *testing.T) {
 	require.Equal(t, 200, recorder.Code)
 	require.NotEqual(t, "404 page not found", recorder.Body.String())
 
-	cartItem, err = getCartItem(t, details, quantity, product.Name, user.Username, "deleted")
+	cartItem, err = getCartItem(t, details, quantity, product.ID, user.ID, "deleted")
 	require.Nil(t, cartItem)
 	require.Error(t, err)
 
@@ -141,7 +153,7 @@ func TestUpdateCartQuantity(t *testing.T) {
 	var quantity int64 = 90
 	addProductToCart(t, details, user, product.ID, quantity)
 
-	cartItem, err := getCartItem(t, details, quantity, product.Name, user.Username)
+	cartItem, err := getCartItem(t, details, quantity, product.ID, user.ID)
 	require.NotNil(t, cartItem)
 	require.NoError(t, err)
 
@@ -160,6 +172,9 @@ func TestUpdateCartQuantity(t *testing.T) {
 	require.Equal(t, 200, recorder.Code)
 	require.NotEqual(t, "404 page not found", recorder.Body.String())
 
+	_, err = getCartItem(t, details, quantity*3, product.ID, user.ID)
+	require.NoError(t, err)
+
 	deleteRecords(details.Db, "cart")
 	dropDatabase(details.Db)
 }
@@ -212,3 +227,257 @@ func TestGetAllUserCartItems(t *testing.T) {
 	deleteRecords(details.Db, "cart")
 	dropDatabase(details.Db)
 }
+
+func TestMergeGuestCartOnLogin(t *testing.T) {
+	details := NewServerDB()
+
+	initializeCartRoutes(details)
+	initializeUserRoutes(details)
+
+	product := createProduct(t, details, "Chandler Bags")
+
+	addToGuestCart := func(token string, quantity int64) string {
+		aReqJson, _ := json.Marshal(AddToCartRequest{ProductID: product.ID, Quantity: quantity})
+		req, err := http.NewRequest("POST", "/guest/cart/add", bytes.NewBuffer(aReqJson))
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Add(CartTokenHeader, token)
+		}
+
+		recorder := httptest.NewRecorder()
+		details.Server.ServeHTTP(recorder, req)
+		require.Equal(t, 200, recorder.Code)
+
+		return recorder.Header().Get(CartTokenHeader)
+	}
+
+	// The first add starts a cart and later adds go to it
+	token := addToGuestCart("", 2)
+	require.NotZero(t, token)
+	require.Equal(t, token, addToGuestCart(token, 3))
+
+	user := createUserTest(t, details, "Harry")
+	addProductToCart(t, details, user, product.ID, 4)
+
+	lreqJson, _ := json.Marshal(map[string]string{"username": "Harry", "password": "101Harry"})
+	req, err := http.NewRequest("POST", "/user/login", bytes.NewBuffer(lreqJson))
+	require.NoError(t, err)
+	req.Header.Add(CartTokenHeader, token)
+
+	recorder := httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+
+	var response entity.UserResponse
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	require.NotNil(t, response.CartMerge)
+	require.Equal(t, 1, response.CartMerge.Merged)
+
+	// Quantities are summed by default and the guest cart is gone
+	_, err = getCartItem(t, details, 9, product.ID, user.ID)
+	require.NoError(t, err)
+
+	req, err = http.NewRequest("GET", "/guest/cart/getall", nil)
+	require.NoError(t, err)
+	req.Header.Add(CartTokenHeader, token)
+
+	recorder = httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, 404, recorder.Code)
+
+	deleteRecords(details.Db, "cart")
+	dropDatabase(details.Db)
+}
+
+func TestGetCartSummary(t *testing.T) {
+	details := NewServerDB()
+
+	initializeCartRoutes(details)
+	initializeUserRoutes(details)
+
+	user := createUserTest(t, details, "Harry")
+
+	bag := createProduct(t, details, "Chandler Bags")
+	shoe := createProduct(t, details, "Nike Shoes")
+	addProductToCart(t, details, user, bag.ID, 2)
+	addProductToCart(t, details, user, shoe.ID, 1)
+
+	// The shoes go up in price and sell out after being carted
+	products := details.Db.Collection("products")
+	_, err := products.UpdateOne(context.Background(), bson.M{"_id": shoe.ID}, bson.M{"$set": bson.M{"price": shoe.Price + 100, "quantity": 0}})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("GET", "/user/cart/summary?currency="+bag.Currency, nil)
+	req.Header.Add("Authorization", "Bearer "+user.Token)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+
+	var summary entity.CartSummary
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &summary))
+	require.Len(t, summary.Lines, 2)
+	require.False(t, summary.CanCheckout)
+
+	for _, line := range summary.Lines {
+		switch line.ProductID {
+		case bag.ID:
+			require.Empty(t, line.Issues)
+		case shoe.ID:
+			require.Contains(t, line.Issues, entity.CartIssuePriceChanged)
+			require.Contains(t, line.Issues, entity.CartIssueOutOfStock)
+		}
+	}
+
+	// Only the bags can be ordered, so only they are totalled
+	require.Equal(t, bag.Price*2, summary.Subtotal)
+
+	deleteRecords(details.Db, "cart")
+	dropDatabase(details.Db)
+}
+
+func TestAddToCartConcurrently(t *testing.T) {
+	details := NewServerDB()
+
+	initializeCartRoutes(details)
+	initializeUserRoutes(details)
+
+	user := createUserTest(t, details, "Harry")
+	product := createProduct(t, details, "Chandler Bags")
+
+	_, err := details.Db.Collection("products").UpdateOne(context.Background(), bson.M{"_id": product.ID}, bson.M{"$set": bson.M{"maximum_per_order": 5}})
+	require.NoError(t, err)
+
+	// Every add checks the line it read, so together they cannot take it over the maximum
+	var wg sync.WaitGroup
+	added := make(chan bool, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := repository.AddToCart(details.Db.Collection("cart"), 1, product.ID, user.ID)
+			added <- err == nil
+		}()
+	}
+	wg.Wait()
+	close(added)
+
+	var succeeded int64
+	for ok := range added {
+		if ok {
+			succeeded++
+		}
+	}
+	require.LessOrEqual(t, succeeded, int64(5))
+	require.Positive(t, succeeded)
+
+	getCartItem(t, details, succeeded, product.ID, user.ID)
+
+	deleteRecords(details.Db, "cart")
+	dropDatabase(details.Db)
+}
+
+func TestFlashSaleHoldsStock(t *testing.T) {
+	details := NewServerDB()
+
+	initializeCartRoutes(details)
+	initializeUserRoutes(details)
+
+	first := createUserTest(t, details, "Ginny")
+	second := createUserTest(t, details, "Ronald")
+
+	product := createProduct(t, details, "Chandler Bags")
+
+	products := details.Db.Collection("products")
+	_, err := products.UpdateOne(context.Background(), bson.M{"_id": product.ID}, bson.M{"$set": bson.M{"flash_sale": true, "quantity": 5}})
+	require.NoError(t, err)
+
+	// Four of five are held for the first user, so the second can only cart one
+	addProductToCart(t, details, first, product.ID, 4)
+	addProductToCart(t, details, second, product.ID, 2, "unique")
+	addProductToCart(t, details, second, product.ID, 1)
+
+	var reservation entity.Reservation
+	err = details.Db.Collection("reservations").FindOne(context.Background(), bson.M{"user_id": first.ID, "product_id": product.ID}).Decode(&reservation)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), reservation.Quantity)
+	require.Equal(t, entity.ReservationFlashSale, reservation.Reason)
+	require.True(t, reservation.ExpiresAt.After(time.Now()))
+
+	// Leaving checkout lets the held stock go
+	req, err := http.NewRequest("DELETE", "/user/cart/checkout", nil)
+	req.Header.Add("Authorization", "Bearer "+first.Token)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+
+	addProductToCart(t, details, second, product.ID, 2)
+	getCartItem(t, details, 3, product.ID, second.ID)
+
+	deleteRecords(details.Db, "cart")
+	deleteRecords(details.Db, "reservations")
+	dropDatabase(details.Db)
+}
+
+func TestSaveForLater(t *testing.T) {
+	details := NewServerDB()
+
+	initializeCartRoutes(details)
+	initializeUserRoutes(details)
+
+	user := createUserTest(t, details, "Harry")
+	product := createProduct(t, details, "Chandler Bags")
+	addProductToCart(t, details, user, product.ID, 3)
+	item, _ := getCartItem(t, details, 3, product.ID, user.ID)
+
+	body, _ := json.Marshal(SaveForLaterRequest{CartID: item.ID})
+	req, err := http.NewRequest("POST", "/user/cart/saved", bytes.NewBuffer(body))
+	req.Header.Add("Authorization", "Bearer "+user.Token)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+
+	getCartItem(t, details, 0, product.ID, user.ID, "deleted")
+
+	// The saved product is listed apart from the cart, with its current data
+	req, err = http.NewRequest("GET", "/user/cart/getall", nil)
+	req.Header.Add("Authorization", "Bearer "+user.Token)
+	require.NoError(t, err)
+
+	recorder = httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+
+	var result GetUserCartItemsResult
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+	require.Empty(t, result.Data)
+	require.Len(t, result.SavedForLater, 1)
+
+	saved := result.SavedForLater[0]
+	require.Equal(t, int64(3), saved.Quantity)
+	require.NotNil(t, saved.Product)
+	require.Equal(t, product.Price, saved.Product.Price)
+	require.Equal(t, product.Quantity, saved.Product.Quantity)
+
+	req, err = http.NewRequest("POST", "/user/cart/saved/"+saved.ID+"/move_to_cart", nil)
+	req.Header.Add("Authorization", "Bearer "+user.Token)
+	require.NoError(t, err)
+
+	recorder = httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+
+	getCartItem(t, details, 3, product.ID, user.ID)
+
+	count, err := details.Db.Collection("saved_items").CountDocuments(context.Background(), bson.M{"user_id": user.ID})
+	require.NoError(t, err)
+	require.Zero(t, count)
+
+	deleteRecords(details.Db, "cart")
+	dropDatabase(details.Db)
+}
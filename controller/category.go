@@ -0,0 +1,34 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/repository"
+	"github.com/Emmrys-Jay/ecommerce-api/util"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// GetCategorySchema returns the attributes products in a category can have and be filtered by
+func (u *UserController) GetCategorySchema(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "category_schemas")
+
+	category := ctx.Param("category")
+	if category == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid url param"})
+		return
+	}
+
+	schema, err := repository.GetCategorySchema(collection, category)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "category has no attribute schema"})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, schema)
+}
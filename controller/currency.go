@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Emmrys-Jay/ecommerce-api/currency"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/repository"
+	"github.com/Emmrys-Jay/ecommerce-api/util"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// displayCurrency validates a requested display currency. An empty code means prices are shown as stored.
+// It writes a bad request response and returns false when the code is invalid.
+func displayCurrency(ctx *gin.Context, code string) (string, bool) {
+	if code == "" {
+		return "", true
+	}
+
+	code, err := currency.Normalize(code)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return "", false
+	}
+
+	return code, true
+}
+
+// applyDisplayCurrency converts product prices to the display currency, if one was requested.
+// It writes an error response and returns false when the prices could not be converted.
+func applyDisplayCurrency(ctx *gin.Context, database *mongo.Database, products []entity.Product, code string) bool {
+	if code == "" {
+		return true
+	}
+
+	err := repository.ApplyDisplayCurrency(database, products, code)
+	if err != nil {
+		if errors.Is(err, currency.ErrNoRate) {
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+			return false
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return false
+	}
+
+	return true
+}
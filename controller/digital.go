@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"math"
+	"net/http"
+	"os"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/repository"
+	"github.com/Emmrys-Jay/ecommerce-api/util"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// GetDownloadsRequest models the query params of a downloads listing
+type GetDownloadsRequest struct {
+	PageID   int `form:"page_id"`
+	PageSize int `form:"page_size"`
+}
+
+// GetDownloads returns the logged in user's downloads with signed links, newest first
+func (u *UserController) GetDownloads(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "downloads")
+	var req GetDownloadsRequest
+
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	if req.PageID < 1 {
+		req.PageID = 1
+	}
+
+	if req.PageSize < 5 {
+		req.PageSize = 5
+	}
+
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	downloads, length, err := repository.GetUserDownloads(collection, userID, req.PageSize*(req.PageID-1), req.PageSize)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	response := entity.PaginationResponse{
+		PageID:        req.PageID,
+		ResultsFound:  int(length),
+		NumberOfPages: int(math.Ceil(float64(length) / float64(req.PageSize))),
+		Data:          downloads,
+	}
+
+	if response.NumberOfPages < 1 {
+		response.PageID = 0
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// Download serves the file of a signed download link. The link itself is the credential, so no login is needed,
+// and every request counts against the download limit.
+func (u *UserController) Download(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "downloads")
+
+	downloadID := ctx.Param("download-id")
+	if downloadID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - download ID"})
+		return
+	}
+
+	download, err := repository.UseDownload(collection, downloadID, ctx.Query("expires"), ctx.Query("signature"))
+	if err != nil {
+		switch err {
+		case repository.ErrInvalidSignature:
+			ctx.JSON(http.StatusForbidden, util.ErrorResponse(err))
+		case repository.ErrDownloadExpired, repository.ErrDownloadLimitReached:
+			ctx.JSON(http.StatusGone, util.ErrorResponse(err))
+		case mongo.ErrNoDocuments:
+			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+		default:
+			ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		}
+		return
+	}
+
+	// Serve the product's current file, which may have been replaced with a newer version since the order
+	product, err := repository.FindOneProduct(db.GetCollection(u.Database, "products"), download.ProductID)
+	if err != nil || product.Digital == nil || product.Digital.FileName == "" {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "file is no longer available"})
+		return
+	}
+
+	path := repository.DigitalFilePath(product.ID, product.Digital.FileName)
+	if _, err := os.Stat(path); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "file is no longer available"})
+		return
+	}
+
+	ctx.FileAttachment(path, product.Digital.FileName)
+}
@@ -0,0 +1,167 @@
+// digital_test
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/repository"
+	"github.com/Emmrys-Jay/ecommerce-api/util"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func createDigitalProduct(t *testing.T, details *ServerDB, name string, digital entity.DigitalContent) *entity.Product {
+	product := entity.Product{
+		ID:          primitive.NewObjectIDFromTimestamp(time.Now()).Hex(),
+		Type:        entity.ProductTypeDigital,
+		Name:        name,
+		Price:       2500,
+		Currency:    "CAD",
+		Description: util.RandomString(),
+		Category:    "software",
+		Digital:     &digital,
+		CreatedAt:   time.Now(),
+	}
+
+	_, err := details.Db.Collection("products").InsertOne(context.Background(), product)
+	require.NoError(t, err)
+
+	return &product
+}
+
+// orderDigitalTest orders a digital product without a delivery location and returns the saved order
+func orderDigitalTest(t *testing.T, details *ServerDB, user entity.UserResponse, productID string, quantity, expectedCode int) *entity.Order {
+	oReq := OrderProductRequest{
+		Fullname:      user.Username,
+		Quantity:      quantity,
+		PaymentMethod: "nil",
+	}
+
+	oReqJson, _ := json.Marshal(oReq)
+	req, err := http.NewRequest("POST", fmt.Sprintf("/products/order/%s", productID), bytes.NewBuffer(oReqJson))
+	req.Header.Add("Authorization", "Bearer "+user.Token)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, expectedCode, recorder.Code)
+
+	if expectedCode != 200 {
+		return nil
+	}
+
+	var result OrderProductResult
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+
+	req, err = http.NewRequest("GET", fmt.Sprintf("/products/order/get/%s", result.OrderID), nil)
+	req.Header.Add("Authorization", "Bearer "+user.Token)
+	require.NoError(t, err)
+
+	recorder = httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+
+	var order entity.Order
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &order))
+	require.Equal(t, entity.OrderDelivered, order.Status)
+	require.Zero(t, order.DeliveryLocation)
+
+	return &order
+}
+
+func TestOrderLicenceKeyProduct(t *testing.T) {
+	details := NewServerDB()
+
+	initializeOrdersRoutes(details)
+	initializeUserRoutes(details)
+
+	product := createDigitalProduct(t, details, "Photo Editor Licence", entity.DigitalContent{Delivery: entity.DeliveryLicenceKey})
+
+	added, err := repository.AddLicenceKeys(details.Db.Collection("licence_keys"), product.ID, []string{"AAAA-1111", "BBBB-2222", "AAAA-1111"})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), added)
+
+	user := createUserTest(t, details, "Harry")
+
+	orderDigitalTest(t, details, user, product.ID, 3, 400) // Only two keys in the pool
+
+	order := orderDigitalTest(t, details, user, product.ID, 2, 200)
+	require.ElementsMatch(t, []string{"AAAA-1111", "BBBB-2222"}, order.Lines[0].LicenceKeys)
+
+	orderDigitalTest(t, details, user, product.ID, 1, 400) // Pool is empty
+
+	// Other users cannot read the order and its keys
+	other := createUserTest(t, details, "Sally")
+	req, err := http.NewRequest("GET", fmt.Sprintf("/products/order/get/%s", order.ID), nil)
+	req.Header.Add("Authorization", "Bearer "+other.Token)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, 400, recorder.Code)
+	require.NotContains(t, recorder.Body.String(), "AAAA-1111")
+
+	deleteRecords(details.Db, "licence_keys")
+	deleteRecords(details.Db, "orders")
+	dropDatabase(details.Db)
+}
+
+func TestDownloadDigitalProduct(t *testing.T) {
+	t.Setenv("DIGITAL_FILES_DIR", t.TempDir())
+
+	details := NewServerDB()
+
+	initializeOrdersRoutes(details)
+	initializeUserRoutes(details)
+
+	product := createDigitalProduct(t, details, "Go Cookbook", entity.DigitalContent{
+		Delivery:      entity.DeliveryFile,
+		FileName:      "cookbook.pdf",
+		DownloadLimit: 1,
+	})
+
+	path := repository.DigitalFilePath(product.ID, "cookbook.pdf")
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte("recipes"), 0o644))
+
+	user := createUserTest(t, details, "Harry")
+
+	order := orderDigitalTest(t, details, user, product.ID, 1, 200)
+	require.Len(t, order.Lines[0].Downloads, 1)
+	require.NotZero(t, order.Lines[0].Downloads[0].URL)
+
+	download := func(url string, expectedCode int) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", url, nil)
+		require.NoError(t, err)
+
+		recorder := httptest.NewRecorder()
+		details.Server.ServeHTTP(recorder, req)
+		require.Equal(t, expectedCode, recorder.Code)
+
+		return recorder
+	}
+
+	url := order.Lines[0].Downloads[0].URL
+	download(strings.Replace(url, "signature=", "signature=0", 1), 403)
+
+	recorder := download(url, 200)
+	require.Equal(t, "recipes", recorder.Body.String())
+
+	download(url, 410) // Download limit of one reached
+
+	deleteRecords(details.Db, "downloads")
+	deleteRecords(details.Db, "orders")
+	dropDatabase(details.Db)
+}
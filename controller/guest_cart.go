@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/repository"
+	util "github.com/Emmrys-Jay/ecommerce-api/util"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CartTokenHeader carries the token of a guest cart. It is sent back on every guest cart response
+// and is merged into the user's cart when sent with a login or signup.
+const CartTokenHeader = "X-Cart-Token"
+
+// GuestAddToCart adds a product to a guest cart. A request without a cart token starts a new cart,
+// whose token is returned in the X-Cart-Token header.
+func (u *UserController) GuestAddToCart(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "guest_carts")
+
+	var cart *entity.GuestCart
+	var err error
+
+	if ctx.GetHeader(CartTokenHeader) == "" {
+		cart, err = repository.CreateGuestCart(collection)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+			return
+		}
+		ctx.Header(CartTokenHeader, cart.Token)
+	} else if cart = u.guestCart(ctx); cart == nil {
+		return
+	}
+
+	u.addToCart(ctx, cart.OwnerID())
+}
+
+// GuestRemoveFromCart removes a line from a guest cart
+func (u *UserController) GuestRemoveFromCart(ctx *gin.Context) {
+	if cart := u.guestCart(ctx); cart != nil {
+		u.removeFromCart(ctx, cart.OwnerID())
+	}
+}
+
+// GuestUpdateCartQuantity changes the quantity of a line in a guest cart
+func (u *UserController) GuestUpdateCartQuantity(ctx *gin.Context) {
+	if cart := u.guestCart(ctx); cart != nil {
+		u.updateCartQuantity(ctx, cart.OwnerID())
+	}
+}
+
+// GetGuestCartItems gets the products in a guest cart
+func (u *UserController) GetGuestCartItems(ctx *gin.Context) {
+	if cart := u.guestCart(ctx); cart != nil {
+		u.getCartItems(ctx, cart.OwnerID())
+	}
+}
+
+// guestCart returns the guest cart of the request's cart token, writing a 404 response when there is none
+func (u *UserController) guestCart(ctx *gin.Context) *entity.GuestCart {
+	collection := db.GetCollection(u.Database, "guest_carts")
+
+	token := ctx.GetHeader(CartTokenHeader)
+	if token == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "missing " + CartTokenHeader + " header"})
+		return nil
+	}
+
+	cart, err := repository.FindGuestCart(collection, token)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "cart not found or expired"})
+			return nil
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return nil
+	}
+
+	ctx.Header(CartTokenHeader, cart.Token)
+	return cart
+}
+
+// mergeGuestCart merges the guest cart of the request's cart token, if any, into a user's cart.
+// Signing in should not fail because of the cart, so errors are only logged.
+func (u *UserController) mergeGuestCart(ctx *gin.Context, userID string) *entity.CartMerge {
+	token := ctx.GetHeader(CartTokenHeader)
+	if token == "" {
+		return nil
+	}
+
+	merge, err := repository.MergeGuestCart(u.Database, token, userID)
+	if err != nil {
+		log.Printf("merging guest cart into the cart of %s: %v", userID, err)
+		return nil
+	}
+
+	return merge
+}
+
+// GetGuestCartSummary prices a guest cart and returns its totals
+func (u *UserController) GetGuestCartSummary(ctx *gin.Context) {
+	if cart := u.guestCart(ctx); cart != nil {
+		u.cartSummary(ctx, cart.OwnerID())
+	}
+}
@@ -63,9 +63,20 @@ func initializeProductRoutes(details *ServerDB) {
 		products.GET("/get/:category", userController.GetProductsByCategory)
 		products.GET("/find", userController.FindProducts)
 		products.GET("/findone/:productID", userController.FindOneProduct)
+		products.GET("/slug/:slug", userController.FindProductBySlug)
+		products.GET("/sku/:sku", userController.FindProductBySKU)
 		// products.GET("/find/recent", userController.FindProductsWithTime)
 		//products.GET("/find/reviews", userController.FindProductsBasedOnReviews)
 		products.PUT("/:productID/addreview", userController.AddReview)
+		products.GET("/reviews/:productID", userController.GetProductReviews)
+		products.PATCH("/reviews/:productID", userController.UpdateReview)
+		products.DELETE("/reviews/:productID", userController.DeleteReview)
+		products.POST("/reviews/report/:review-id", userController.ReportReview)
+		products.POST("/reviews/vote/:review-id", userController.VoteReview)
+		products.GET("/questions/:productID", userController.GetProductQuestions)
+		products.POST("/questions/:productID", userController.AskQuestion)
+		products.POST("/answers/:question-id", userController.AnswerQuestion)
+		products.POST("/answers/vote/:answer-id", userController.VoteAnswer)
 		// products.GET("/categories", getAllCategories)
 	}
 }
@@ -77,18 +88,21 @@ func initializeOrdersRoutes(details *ServerDB) {
 	{
 		orders.POST("/:productID", userController.OrderProduct)
 		orders.GET("/get/:order-ID", userController.GetOrder)
+		orders.GET("/get/:order-ID/timeline", userController.GetOrderTimeline)
 		orders.GET("/get", userController.GetOrdersWithUsername)
 		orders.PUT("/receive/:order-id", userController.ReceiveOrder)
 		orders.POST("/cart", userController.OrderAllCartItems)
 	}
+
+	details.Server.GET("/downloads/:download-id", userController.Download)
 }
 
 func configureCartCollection(details *ServerDB) error {
 	collection := details.Db.Collection("cart")
 
 	_, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
-		Keys:    bson.D{{Key: "product_name", Value: 1}},
-		Options: options.Index().SetName("product_name_index").SetUnique(true),
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "product_id", Value: 1}},
+		Options: options.Index().SetName("user_product_index").SetUnique(true),
 	})
 	return err
 }
@@ -103,5 +117,48 @@ func initializeCartRoutes(details *ServerDB) {
 		cart.DELETE("/remove/:cart-id", userController.RemoveFromCart)
 		cart.PUT("/update", userController.UpdateCartQuantity)
 		cart.GET("/getall", userController.GetUserCartItems)
+		cart.GET("/summary", userController.GetCartSummary)
+		cart.POST("/checkout", userController.StartCheckout)
+		cart.DELETE("/checkout", userController.LeaveCheckout)
+		cart.POST("/saved", userController.SaveForLater)
+		cart.POST("/saved/:saved-id/move_to_cart", userController.MoveSavedItemToCart)
+	}
+
+	guest := details.Server.Group("/guest/cart")
+	{
+		guest.POST("/add", userController.GuestAddToCart)
+		guest.PUT("/update", userController.GuestUpdateCartQuantity)
+		guest.GET("/getall", userController.GetGuestCartItems)
+	}
+}
+
+func initializeNotificationRoutes(details *ServerDB) {
+	userController := NewUserController(details.Db)
+
+	subscriptions := details.Server.Group("/user/subscriptions")
+	{
+		subscriptions.GET("", userController.GetSubscriptions)
+		subscriptions.POST("", userController.CreateSubscription)
+		subscriptions.DELETE("/:id", userController.DeleteSubscription)
 	}
+
+	notifications := details.Server.Group("/user/notifications")
+	{
+		notifications.GET("", userController.GetNotifications)
+		notifications.PUT("/channels", userController.UpdateNotificationChannels)
+	}
+}
+
+func initializeWishlistRoutes(details *ServerDB) {
+	userController := NewUserController(details.Db)
+
+	wishlist := details.Server.Group("/user/wishlist")
+	{
+		wishlist.GET("/getall", userController.GetWishlist)
+		wishlist.POST("/add/:productID", userController.AddToWishlist)
+		wishlist.DELETE("/remove/:productID", userController.RemoveFromWishlist)
+		wishlist.PUT("/share", userController.ShareWishlist)
+	}
+
+	details.Server.GET("/wishlist/shared/:token", userController.GetSharedWishlist)
 }
@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"errors"
+	"math"
+	"net/http"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/notification"
+	"github.com/Emmrys-Jay/ecommerce-api/repository"
+	"github.com/Emmrys-Jay/ecommerce-api/util"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// GetNotificationsRequest models the query params of a notifications listing
+type GetNotificationsRequest struct {
+	PageID   int `form:"page_id"`
+	PageSize int `form:"page_size"`
+}
+
+// GetNotifications returns the logged in user's in-app notifications, newest first
+func (u *UserController) GetNotifications(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "notifications")
+	var req GetNotificationsRequest
+
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	if req.PageID < 1 {
+		req.PageID = 1
+	}
+
+	if req.PageSize < 5 {
+		req.PageSize = 5
+	}
+
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	notifications, length, err := repository.GetUserNotifications(collection, userID, req.PageSize*(req.PageID-1), req.PageSize)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	response := entity.PaginationResponse{
+		PageID:        req.PageID,
+		ResultsFound:  int(length),
+		NumberOfPages: int(math.Ceil(float64(length) / float64(req.PageSize))),
+		Data:          notifications,
+	}
+
+	if response.NumberOfPages < 1 {
+		response.PageID = 0
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// MarkNotificationRead marks one of the logged in user's notifications as read
+func (u *UserController) MarkNotificationRead(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "notifications")
+
+	id := ctx.Param("id")
+	if id == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - notification ID"})
+		return
+	}
+
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	result, err := repository.MarkNotificationRead(collection, id, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	if result.MatchedCount == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "notification not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"success": "marked notification as read"})
+}
+
+// NotificationChannelsRequest models the channels a user wants to be notified on
+type NotificationChannelsRequest struct {
+	Channels []string `json:"channels" binding:"required"`
+}
+
+// UpdateNotificationChannels sets the channels (email, sms, in_app) the logged in user is notified on.
+// An empty list turns notifications back to the default channels.
+func (u *UserController) UpdateNotificationChannels(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "users")
+	var req NotificationChannelsRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	err = repository.SetNotificationChannels(collection, userID, req.Channels)
+	if err != nil {
+		switch {
+		case errors.Is(err, notification.ErrUnknownChannel):
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		case err == mongo.ErrNoDocuments:
+			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+		default:
+			ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"success": "updated notification channels"})
+}
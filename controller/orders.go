@@ -1,31 +1,33 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"net/http"
 	"strconv"
-	"time"
 
+	"github.com/Emmrys-Jay/ecommerce-api/currency"
 	"github.com/Emmrys-Jay/ecommerce-api/db"
 	"github.com/Emmrys-Jay/ecommerce-api/entity"
 	"github.com/Emmrys-Jay/ecommerce-api/repository"
 	"github.com/Emmrys-Jay/ecommerce-api/util"
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type OrderProductRequest struct {
 	Fullname      string          `json:"fullname" binding:"required"`
-	Quantity      int             `json:"quantity" binding:"required"`
-	Location      entity.Location `json:"location" binding:"required"`
+	Quantity      int             `json:"quantity" binding:"required,min=1"`
+	Location      entity.Location `json:"location" description:"not needed when only digital products are ordered"`
 	PaymentMethod string          `json:"payment_method" binding:"required"`
+	Currency      string          `json:"currency"`
 }
 
 type OrderProductResult struct {
-	Response string `json:"response"`
-	OrderID  string `json:"order-id"`
+	Response string        `json:"response"`
+	OrderID  string        `json:"order-id"`
+	Order    *entity.Order `json:"order,omitempty"`
 }
 
 // OrderProduct serves an order-a-product request from a user directly
@@ -44,14 +46,18 @@ func (u *UserController) OrderProduct(ctx *gin.Context) {
 		return
 	}
 
+	orderCurrency, ok := displayCurrency(ctx, req.Currency)
+	if !ok {
+		return
+	}
+
 	userID, err := util.UserIDFromToken(ctx)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
 		return
 	}
 
-	orderID := primitive.NewObjectIDFromTimestamp(time.Now()).Hex()
-	_, productName, err := repository.OrderProductDirectly(
+	order, err := repository.OrderProductDirectly(
 		collection,
 		&req.Location,
 		req.Quantity,
@@ -59,26 +65,32 @@ func (u *UserController) OrderProduct(ctx *gin.Context) {
 		req.Fullname,
 		productID,
 		req.PaymentMethod,
+		orderCurrency,
 	)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			ctx.JSON(http.StatusBadRequest, productID)
 			return
 		}
+		if errors.Is(err, currency.ErrNoRate) || repository.IsQuantityError(err) || errors.Is(err, repository.ErrLocationRequired) {
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
 		return
 	}
 
-	response := fmt.Sprintf("you just bought %d product(s) with name - %s and id - %s", req.Quantity, productName, productID)
+	response := fmt.Sprintf("you just bought %d product(s) with name - %s and id - %s", req.Quantity, order.Lines[0].Product.Name, productID)
 	fResponse := OrderProductResult{
 		Response: response,
-		OrderID:  orderID,
+		OrderID:  order.ID,
+		Order:    order,
 	}
 
 	ctx.JSON(http.StatusOK, fResponse)
 }
 
-// GetOrder returns an order db entry
+// GetOrder returns an order of the logged in user
 func (u *UserController) GetOrder(ctx *gin.Context) {
 	collection := db.GetCollection(u.Database, "orders")
 
@@ -88,7 +100,13 @@ func (u *UserController) GetOrder(ctx *gin.Context) {
 		return
 	}
 
-	order, err := repository.GetSingleOrder(collection, orderID)
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	order, err := repository.GetSingleOrder(collection, orderID, userID)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			ctx.JSON(http.StatusBadRequest, gin.H{"error": "order specified does not exist"})
@@ -156,24 +174,23 @@ func (u *UserController) GetOrdersWithUsername(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, response)
 }
 
+// ReceiveOrder lets a user confirm that a delivered order of theirs was received
 func (u *UserController) ReceiveOrder(ctx *gin.Context) {
-	collection := db.GetCollection(u.Database, "orders")
-
 	orderID := ctx.Param("order-id")
 	if orderID == "" {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid url param"})
 		return
 	}
 
-	username, err := util.UsernameFromToken(ctx)
+	userID, err := util.UserIDFromToken(ctx)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
 		return
 	}
 
-	_, err = repository.ReceiveOrder(collection, username, orderID)
+	_, err = repository.ReceiveOrder(u.Database, userID, orderID)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if err == mongo.ErrNoDocuments || errors.Is(err, repository.ErrIllegalTransition) {
 			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
 			return
 		}
@@ -184,10 +201,41 @@ func (u *UserController) ReceiveOrder(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{"response": "success!"})
 }
 
+// GetOrderTimeline returns the status changes of an order of the logged in user
+func (u *UserController) GetOrderTimeline(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "orders")
+
+	orderID := ctx.Param("order-ID")
+	if orderID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid url param"})
+		return
+	}
+
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	timeline, err := repository.GetOrderTimeline(collection, orderID, userID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "order specified does not exist"})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, timeline)
+}
+
 type OrderAllCartItemsRequest struct {
 	Fullname      string          `json:"fullname" binding:"required"`
-	Location      entity.Location `json:"location" binding:"required"`
+	Location      entity.Location `json:"location" description:"not needed when only digital products are ordered"`
 	PaymentMethod string          `json:"payment_method" binding:"required"`
+	Currency      string          `json:"currency"`
+	Coupon        string          `json:"coupon" description:"code of a coupon issued to the user"`
 }
 
 // OrderAllCartItems orders all items currently stored in a users cart
@@ -200,17 +248,24 @@ func (u *UserController) OrderAllCartItems(ctx *gin.Context) {
 		return
 	}
 
+	orderCurrency, ok := displayCurrency(ctx, req.Currency)
+	if !ok {
+		return
+	}
+
 	userID, err := util.UserIDFromToken(ctx)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
 		return
 	}
 
-	numProductsOrdered, err := repository.OrderAllCartItems(
+	order, err := repository.OrderAllCartItems(
 		collection,
 		userID,
 		req.Fullname,
 		req.PaymentMethod,
+		orderCurrency,
+		req.Coupon,
 		req.Location,
 	)
 
@@ -219,6 +274,11 @@ func (u *UserController) OrderAllCartItems(ctx *gin.Context) {
 		return
 	}
 
-	response := fmt.Sprintf("successfully ordered %d products", numProductsOrdered)
-	ctx.JSON(http.StatusOK, gin.H{"response": response})
+	response := OrderProductResult{
+		Response: fmt.Sprintf("successfully ordered %d products", len(order.Lines)),
+		OrderID:  order.ID,
+		Order:    order,
+	}
+
+	ctx.JSON(http.StatusOK, response)
 }
@@ -4,6 +4,7 @@ package controller
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -12,7 +13,11 @@ import (
 	"time"
 
 	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/repository"
 	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func orderProductTest(t *testing.T, details *ServerDB, user entity.UserResponse, productID string) string {
@@ -20,6 +25,7 @@ func orderProductTest(t *testing.T, details *ServerDB, user entity.UserResponse,
 		Fullname:      user.Username,
 		Quantity:      9,
 		PaymentMethod: "nil",
+		Currency:      "CAD",
 		Location: entity.Location{
 			HouseNumber: "77",
 			CityOrTown:  "My Town",
@@ -59,6 +65,7 @@ func getOrderTest(t *testing.T, details *ServerDB, user entity.UserResponse, exp
 		if triggers[0] == "id" {
 			path := fmt.Sprintf("/products/order/get/%s", triggers[1])
 			req, err = http.NewRequest("GET", path, nil)
+			req.Header.Add("Authorization", "Bearer "+user.Token)
 			require.NoError(t, err)
 		} else if triggers[0] == "username" {
 			req, err = http.NewRequest("GET", "/products/order/get", nil)
@@ -82,8 +89,10 @@ func getOrderTest(t *testing.T, details *ServerDB, user entity.UserResponse, exp
 		require.NotZero(t, order.UserID)
 		require.NotZero(t, order.FullName)
 		require.NotZero(t, order.DeliveryLocation)
-		require.NotZero(t, order.ProductQuantity)
-		require.NotZero(t, order.Product.Price)
+		require.NotEmpty(t, order.Lines)
+		require.NotZero(t, order.Lines[0].Quantity)
+		require.NotZero(t, order.Lines[0].Product.Price)
+		require.NotZero(t, order.GrandTotal)
 		require.True(t, order.CreatedAt.Before(time.Now()))
 
 		return &order, nil
@@ -202,8 +211,34 @@ func TestReceiveOrder(t *testing.T) {
 	orderID := orderProductTest(t, details, user, product.ID)
 	require.NotZero(t, orderID)
 
-	path := fmt.Sprintf("/products/order/receive/%s", orderID)
-	req, err := http.NewRequest("PUT", path, nil)
+	receive := func() int {
+		path := fmt.Sprintf("/products/order/receive/%s", orderID)
+		req, err := http.NewRequest("PUT", path, nil)
+		req.Header.Add("Authorization", "Bearer "+user.Token)
+		require.NoError(t, err)
+
+		recorder := httptest.NewRecorder()
+		details.Server.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	// An order cannot be received before it is delivered, nor delivered twice
+	require.Equal(t, 400, receive())
+
+	_, err := repository.DeliverOrder(details.Db, orderID, "admin")
+	require.NoError(t, err)
+
+	_, err = repository.DeliverOrder(details.Db, orderID, "admin")
+	require.ErrorIs(t, err, repository.ErrIllegalTransition)
+
+	require.Equal(t, 200, receive())
+	require.Equal(t, 400, receive())
+
+	order, err := getOrderTest(t, details, user, 1, "id", orderID)
+	require.NoError(t, err)
+	require.Equal(t, entity.OrderCompleted, order.Status)
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/products/order/get/%s/timeline", orderID), nil)
 	req.Header.Add("Authorization", "Bearer "+user.Token)
 	require.NoError(t, err)
 
@@ -211,10 +246,665 @@ func TestReceiveOrder(t *testing.T) {
 	details.Server.ServeHTTP(recorder, req)
 	require.Equal(t, 200, recorder.Code)
 
-	order, err := getOrderTest(t, details, user, 1, "id", orderID)
+	var timeline []entity.OrderTransition
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &timeline))
+	require.Len(t, timeline, 4)
+
+	statuses := []string{entity.OrderPendingPayment, entity.OrderPaid, entity.OrderDelivered, entity.OrderCompleted}
+	for i, transition := range timeline {
+		require.Equal(t, statuses[i], transition.To)
+		require.NotZero(t, transition.At)
+	}
+	require.Equal(t, "admin", timeline[2].Actor)
+	require.Equal(t, user.ID, timeline[3].Actor)
+
+	deleteRecords(details.Db, "orders")
+	dropDatabase(details.Db)
+}
+
+func TestOrderProductInOtherCurrency(t *testing.T) {
+	details := NewServerDB()
+
+	initializeOrdersRoutes(details)
+	initializeUserRoutes(details)
+
+	// Rates are relative to the base currency, so CAD -> EUR is 0.9 / 1.35
+	for code, rate := range map[string]float64{"CAD": 1.35, "EUR": 0.9} {
+		_, err := details.Db.Collection("exchange_rates").InsertOne(context.Background(), entity.ExchangeRate{
+			ID:            primitive.NewObjectID().Hex(),
+			Currency:      code,
+			Rate:          rate,
+			EffectiveFrom: time.Now().Add(-time.Hour),
+		})
+		require.NoError(t, err)
+	}
+
+	product := createProduct(t, details, "Chandlers Bags")
+	user := createUserTest(t, details, "Harry")
+
+	oReq := OrderProductRequest{
+		Fullname:      user.Username,
+		Quantity:      1,
+		PaymentMethod: "nil",
+		Currency:      "eur",
+		Location:      entity.Location{CityOrTown: "My Town", Country: "Nigeria"},
+	}
+
+	oReqJson, _ := json.Marshal(oReq)
+	req, err := http.NewRequest("POST", fmt.Sprintf("/products/order/%s", product.ID), bytes.NewBuffer(oReqJson))
+	req.Header.Add("Authorization", "Bearer "+user.Token)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+
+	var orders []entity.Order
+	cursor, err := details.Db.Collection("orders").Find(context.Background(), bson.M{"user_id": user.ID})
+	require.NoError(t, err)
+	require.NoError(t, cursor.All(context.Background(), &orders))
+	require.Len(t, orders, 1)
+
+	require.Equal(t, "EUR", orders[0].Currency)
+	require.InDelta(t, 0.9/1.35, orders[0].Lines[0].ExchangeRate, 1e-9)
+	require.Equal(t, 257680.0, orders[0].Lines[0].UnitPrice)
+
+	// Ordering in a currency without a rate is rejected
+	oReq.Currency = "JPY"
+	oReqJson, _ = json.Marshal(oReq)
+	req, err = http.NewRequest("POST", fmt.Sprintf("/products/order/%s", product.ID), bytes.NewBuffer(oReqJson))
+	req.Header.Add("Authorization", "Bearer "+user.Token)
+	require.NoError(t, err)
+
+	recorder = httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, 400, recorder.Code)
+
+	deleteRecords(details.Db, "exchange_rates")
+	deleteRecords(details.Db, "orders")
+	dropDatabase(details.Db)
+}
+
+func TestOrderProductQuantityLimits(t *testing.T) {
+	details := NewServerDB()
+
+	initializeOrdersRoutes(details)
+	initializeUserRoutes(details)
+
+	product := createProduct(t, details, "Chandlers Bags")
+	_, err := details.Db.Collection("products").UpdateOne(context.Background(), bson.M{"_id": product.ID},
+		bson.M{"$set": bson.M{"quantity": 12, "minimum_order": 2, "maximum_per_order": 10, "low_stock_threshold": 5}})
+	require.NoError(t, err)
+
+	user := createUserTest(t, details, "Harry")
+
+	order := func(quantity, expectedCode int) {
+		oReq := OrderProductRequest{
+			Fullname:      user.Username,
+			Quantity:      quantity,
+			PaymentMethod: "nil",
+			Location:      entity.Location{CityOrTown: "My Town", Country: "Nigeria"},
+		}
+
+		oReqJson, _ := json.Marshal(oReq)
+		req, err := http.NewRequest("POST", fmt.Sprintf("/products/order/%s", product.ID), bytes.NewBuffer(oReqJson))
+		req.Header.Add("Authorization", "Bearer "+user.Token)
+		require.NoError(t, err)
+
+		recorder := httptest.NewRecorder()
+		details.Server.ServeHTTP(recorder, req)
+		require.Equal(t, expectedCode, recorder.Code)
+	}
+
+	order(-1, 400) // Below one
+	order(1, 400)  // Below minimum order
+	order(11, 400) // Above maximum per order
+	order(8, 200)
+	order(5, 400) // Only 4 left in stock
+
+	// Stock fell from 12 to 4, past the threshold of 5
+	count, err := details.Db.Collection("stock_alerts").CountDocuments(context.Background(), bson.M{"product_id": product.ID, "resolved": false})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), count)
+
+	deleteRecords(details.Db, "stock_alerts")
+	deleteRecords(details.Db, "orders")
+	dropDatabase(details.Db)
+}
+
+func TestOrderBundle(t *testing.T) {
+	details := NewServerDB()
+
+	initializeOrdersRoutes(details)
+	initializeUserRoutes(details)
+
+	bag := createProduct(t, details, "Chandlers Bags")
+	shoe := createProduct(t, details, "Nike Shoes")
+
+	products := details.Db.Collection("products")
+	_, err := products.UpdateOne(context.Background(), bson.M{"_id": bag.ID}, bson.M{"$set": bson.M{"quantity": 10}})
+	require.NoError(t, err)
+	_, err = products.UpdateOne(context.Background(), bson.M{"_id": shoe.ID}, bson.M{"$set": bson.M{"quantity": 3}})
+	require.NoError(t, err)
+
+	bundle := entity.Product{
+		ID:       primitive.NewObjectIDFromTimestamp(time.Now()).Hex(),
+		Name:     "Travel Kit",
+		Type:     entity.ProductTypeBundle,
+		Currency: bag.Currency,
+		Components: []entity.BundleComponent{
+			{ProductID: bag.ID, Quantity: 2},
+			{ProductID: shoe.ID, Quantity: 1},
+		},
+		BundleDiscount: 10,
+		CreatedAt:      time.Now(),
+	}
+	_, err = products.InsertOne(context.Background(), bundle)
+	require.NoError(t, err)
+
+	user := createUserTest(t, details, "Harry")
+
+	order := func(quantity, expectedCode int) {
+		oReq := OrderProductRequest{
+			Fullname:      user.Username,
+			Quantity:      quantity,
+			PaymentMethod: "nil",
+			Location:      entity.Location{CityOrTown: "My Town", Country: "Nigeria"},
+		}
+
+		oReqJson, _ := json.Marshal(oReq)
+		req, err := http.NewRequest("POST", fmt.Sprintf("/products/order/%s", bundle.ID), bytes.NewBuffer(oReqJson))
+		req.Header.Add("Authorization", "Bearer "+user.Token)
+		require.NoError(t, err)
+
+		recorder := httptest.NewRecorder()
+		details.Server.ServeHTTP(recorder, req)
+		require.Equal(t, expectedCode, recorder.Code)
+	}
+
+	order(4, 400) // Only enough shoes for three kits
+	order(3, 200)
+
+	var result entity.Product
+	require.NoError(t, products.FindOne(context.Background(), bson.M{"_id": bag.ID}).Decode(&result))
+	require.Equal(t, int64(4), result.Quantity)
+	require.NoError(t, products.FindOne(context.Background(), bson.M{"_id": shoe.ID}).Decode(&result))
+	require.Equal(t, int64(0), result.Quantity)
+
+	order(1, 400) // Out of shoes, so out of kits
+
+	deleteRecords(details.Db, "orders")
+	dropDatabase(details.Db)
+}
+
+func TestOrderAllocatesNearestLocation(t *testing.T) {
+	details := NewServerDB()
+
+	initializeOrdersRoutes(details)
+	initializeUserRoutes(details)
+
+	product := createProduct(t, details, "Chandlers Bags")
+
+	locations := details.Db.Collection("stock_locations")
+	lagos := entity.StockLocation{ID: primitive.NewObjectID().Hex(), Name: "Lagos", Country: "Nigeria", State: "Lagos"}
+	accra := entity.StockLocation{ID: primitive.NewObjectID().Hex(), Name: "Accra", Country: "Ghana", State: "Greater Accra"}
+	_, err := locations.InsertMany(context.Background(), []interface{}{lagos, accra})
+	require.NoError(t, err)
+
+	_, err = repository.SetLocationStock(details.Db, product.ID, accra.ID, 10, "")
+	require.NoError(t, err)
+	_, err = repository.SetLocationStock(details.Db, product.ID, lagos.ID, 3, "")
+	require.NoError(t, err)
+
+	user := createUserTest(t, details, "Harry")
+
+	oReq := OrderProductRequest{
+		Fullname:      user.Username,
+		Quantity:      5,
+		PaymentMethod: "nil",
+		Location:      entity.Location{CityOrTown: "Ikeja", State: "Lagos", Country: "Nigeria"},
+	}
+
+	oReqJson, _ := json.Marshal(oReq)
+	req, err := http.NewRequest("POST", fmt.Sprintf("/products/order/%s", product.ID), bytes.NewBuffer(oReqJson))
+	req.Header.Add("Authorization", "Bearer "+user.Token)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+
+	// Lagos ships all it has and Accra the rest
+	stock, err := repository.GetProductStock(details.Db, product.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(8), stock.Total)
+
+	for _, s := range stock.Locations {
+		switch s.LocationID {
+		case lagos.ID:
+			require.Equal(t, int64(0), s.Quantity)
+		case accra.ID:
+			require.Equal(t, int64(8), s.Quantity)
+		}
+	}
+
+	deleteRecords(details.Db, "orders")
+	dropDatabase(details.Db)
+}
+
+func TestOrderRecordsStockLedger(t *testing.T) {
+	details := NewServerDB()
+
+	initializeOrdersRoutes(details)
+	initializeUserRoutes(details)
+
+	product := createProduct(t, details, "Chandlers Bags")
+	require.NoError(t, repository.RecordInitialStock(details.Db, []entity.Product{*product}, "", ""))
+
+	user := createUserTest(t, details, "Harry")
+
+	oReq := OrderProductRequest{
+		Fullname:      user.Username,
+		Quantity:      2,
+		PaymentMethod: "nil",
+		Location:      entity.Location{CityOrTown: "My Town", Country: "Nigeria"},
+	}
+
+	oReqJson, _ := json.Marshal(oReq)
+	req, err := http.NewRequest("POST", fmt.Sprintf("/products/order/%s", product.ID), bytes.NewBuffer(oReqJson))
+	req.Header.Add("Authorization", "Bearer "+user.Token)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+
+	_, err = repository.AdjustStock(details.Db, product.ID, entity.StockMovement{Delta: 1, Reason: entity.StockDamage})
+	require.ErrorIs(t, err, repository.ErrInvalidAdjustment)
+
+	_, err = repository.AdjustStock(details.Db, product.ID, entity.StockMovement{Delta: -1, Reason: entity.StockDamage})
+	require.NoError(t, err)
+
+	ledger := details.Db.Collection("stock_ledger")
+	movements, length, err := repository.GetStockMovements(ledger, product.ID, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), length)
+	require.Equal(t, entity.StockDamage, movements[0].Reason)
+	require.Equal(t, entity.StockSale, movements[1].Reason)
+	require.Equal(t, int64(-2), movements[1].Delta)
+	require.Equal(t, user.ID, movements[1].Actor)
+
+	// Stock and ledger agree, so nothing needs reconciling
+	rows, _, err := repository.GetStockReconciliation(details.Db.Collection("products"), false, 0, 10)
+	require.NoError(t, err)
+	require.Empty(t, rows)
+
+	deleteRecords(details.Db, "orders")
+	dropDatabase(details.Db)
+}
+
+func TestAbandonedCartReminderAndRecovery(t *testing.T) {
+	details := NewServerDB()
+
+	initializeOrdersRoutes(details)
+	initializeCartRoutes(details)
+	initializeUserRoutes(details)
+
+	// Keep the whole test in the product currency so no exchange rates are needed
+	t.Setenv("BASE_CURRENCY", "CAD")
+	t.Setenv("ABANDONED_CART_COUPON_PERCENT", "10")
+
+	user := createUserTest(t, details, "Harry")
+	product := createProduct(t, details, "Chandlers Bags")
+	addProductToCart(t, details, user, product.ID, 2)
+
+	// Nothing is sent for a cart that was just touched
+	sent, err := repository.RemindAbandonedCarts(details.Db)
+	require.NoError(t, err)
+	require.Zero(t, sent)
+
+	stale := time.Now().Add(-48 * time.Hour)
+	_, err = details.Db.Collection("cart").UpdateMany(context.Background(), bson.M{"user_id": user.ID},
+		bson.M{"$set": bson.M{"date_added": stale, "last_updated": stale}})
+	require.NoError(t, err)
+
+	sent, err = repository.RemindAbandonedCarts(details.Db)
+	require.NoError(t, err)
+	require.Equal(t, 1, sent)
+
+	// The next reminder is not due yet
+	sent, err = repository.RemindAbandonedCarts(details.Db)
+	require.NoError(t, err)
+	require.Zero(t, sent)
+
+	var abandoned entity.AbandonedCart
+	err = details.Db.Collection("abandoned_carts").FindOne(context.Background(), bson.M{"user_id": user.ID}).Decode(&abandoned)
+	require.NoError(t, err)
+	require.Equal(t, entity.AbandonedCartOpen, abandoned.Status)
+	require.Equal(t, 1, abandoned.Reminders)
+	require.NotEmpty(t, abandoned.CouponCode)
+
+	oReq := OrderAllCartItemsRequest{
+		Fullname:      user.Username,
+		PaymentMethod: "nil",
+		Coupon:        abandoned.CouponCode,
+		Location:      entity.Location{CityOrTown: "My Town", Country: "Nigeria"},
+	}
+
+	oReqJson, _ := json.Marshal(oReq)
+	req, err := http.NewRequest("POST", "/products/order/cart", bytes.NewBuffer(oReqJson))
+	req.Header.Add("Authorization", "Bearer "+user.Token)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+
+	var order entity.Order
+	err = details.Db.Collection("orders").FindOne(context.Background(), bson.M{"user_id": user.ID}).Decode(&order)
+	require.NoError(t, err)
+	require.Equal(t, abandoned.CouponCode, order.CouponCode)
+	require.NotZero(t, order.Discount)
+
+	err = details.Db.Collection("abandoned_carts").FindOne(context.Background(), bson.M{"_id": abandoned.ID}).Decode(&abandoned)
+	require.NoError(t, err)
+	require.Equal(t, entity.AbandonedCartRecovered, abandoned.Status)
+	require.Equal(t, product.Price*2-order.Discount, abandoned.RecoveredRevenue)
+
+	report, err := repository.GetAbandonmentReport(details.Db, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, int64(1), report.Abandoned)
+	require.Equal(t, int64(1), report.Recovered)
+	require.Zero(t, report.AbandonmentRate)
+
+	deleteRecords(details.Db, "orders")
+	deleteRecords(details.Db, "cart")
+	dropDatabase(details.Db)
+}
+
+func TestOrderAllCartItemsInOneOrder(t *testing.T) {
+	details := NewServerDB()
+
+	initializeOrdersRoutes(details)
+	initializeCartRoutes(details)
+	initializeUserRoutes(details)
+
+	t.Setenv("BASE_CURRENCY", "CAD")
+	t.Setenv("SHIPPING_FEE", "500")
+	t.Setenv("TAX_RATE", "10")
+
+	user := createUserTest(t, details, "Harry")
+	bag := createProduct(t, details, "Chandlers Bags")
+	shoe := createProduct(t, details, "Nike Shoes")
+	addProductToCart(t, details, user, bag.ID, 2)
+	addProductToCart(t, details, user, shoe.ID, 1)
+
+	oReq := OrderAllCartItemsRequest{
+		Fullname:      user.Username,
+		PaymentMethod: "card",
+		Location:      entity.Location{CityOrTown: "My Town", Country: "Nigeria"},
+	}
+
+	oReqJson, _ := json.Marshal(oReq)
+	req, err := http.NewRequest("POST", "/products/order/cart", bytes.NewBuffer(oReqJson))
+	req.Header.Add("Authorization", "Bearer "+user.Token)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+
+	count, err := details.Db.Collection("orders").CountDocuments(context.Background(), bson.M{"user_id": user.ID})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), count)
+
+	var result OrderProductResult
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+
+	order := result.Order
+	require.NotNil(t, order)
+	require.Len(t, order.Lines, 2)
+	require.Equal(t, "card", order.PaymentMethod)
+
+	subtotal := bag.Price * 3
+	require.Equal(t, subtotal, order.Subtotal)
+	require.Equal(t, 500.0, order.Shipping)
+	require.Equal(t, subtotal/10, order.Tax)
+	require.Equal(t, subtotal+500+subtotal/10, order.GrandTotal)
+
+	for _, line := range order.Lines {
+		require.Equal(t, line.UnitPrice*float64(line.Quantity), line.LineTotal)
+	}
+
+	deleteRecords(details.Db, "orders")
+	deleteRecords(details.Db, "cart")
+	dropDatabase(details.Db)
+}
+
+func TestMigrateSingleProductOrders(t *testing.T) {
+	details := NewServerDB()
+
+	initializeUserRoutes(details)
+
+	product := createProduct(t, details, "Chandlers Bags")
+	id := primitive.NewObjectID().Hex()
+
+	_, err := details.Db.Collection("orders").InsertOne(context.Background(), bson.M{
+		"_id":              id,
+		"user_id":          "someone",
+		"product":          *product,
+		"product_quantity": 2,
+		"unit_price":       100.0,
+		"currency":         "EUR",
+		"exchange_rate":    0.5,
+		"delivery_fee":     7.0,
+		"is_received":      true,
+	})
+	require.NoError(t, err)
+
+	migrated, err := repository.MigrateSingleProductOrders(details.Db)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), migrated)
+
+	var order entity.Order
+	require.NoError(t, details.Db.Collection("orders").FindOne(context.Background(), bson.M{"_id": id}).Decode(&order))
+	require.Len(t, order.Lines, 1)
+	require.Equal(t, product.ID, order.Lines[0].ProductID)
+	require.Equal(t, int64(2), order.Lines[0].Quantity)
+	require.Equal(t, 200.0, order.Subtotal)
+	require.Equal(t, 7.0, order.Shipping)
+	require.Equal(t, 207.0, order.GrandTotal)
+
+	_, err = repository.MigrateOrderStatuses(details.Db)
+	require.NoError(t, err)
+
+	// Purchases are found through the order lines once migrated
+	received, err := repository.HasReceivedProduct(details.Db.Collection("orders"), "someone", product.ID)
+	require.NoError(t, err)
+	require.True(t, received)
+
+	migrated, err = repository.MigrateSingleProductOrders(details.Db)
+	require.NoError(t, err)
+	require.Zero(t, migrated)
+
+	deleteRecords(details.Db, "orders")
+	dropDatabase(details.Db)
+}
+
+func TestCancelOrderReturnsStock(t *testing.T) {
+	details := NewServerDB()
+
+	initializeOrdersRoutes(details)
+	initializeUserRoutes(details)
+
+	product := createProduct(t, details, "Chandlers Bags")
+
+	user := createUserTest(t, details, "Harry")
+	orderID := orderProductTest(t, details, user, product.ID)
+	require.NotZero(t, orderID)
+
+	order, err := repository.TransitionOrder(details.Db, orderID, entity.OrderCancelled, "admin", "out of reach")
+	require.NoError(t, err)
+	require.Equal(t, entity.OrderCancelled, order.Status)
+	require.Equal(t, "out of reach", order.History[len(order.History)-1].Note)
+
+	restocked, err := repository.FindOneProduct(details.Db.Collection("products"), product.ID)
+	require.NoError(t, err)
+	require.Equal(t, product.Quantity, restocked.Quantity)
+
+	returned, err := details.Db.Collection("stock_ledger").CountDocuments(context.Background(), bson.M{
+		"product_id":   product.ID,
+		"reason":       entity.StockReturn,
+		"reference_id": orderID,
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), returned)
+
+	// Cancelled orders are final
+	_, err = repository.TransitionOrder(details.Db, orderID, entity.OrderRefunded, "admin", "")
+	require.ErrorIs(t, err, repository.ErrIllegalTransition)
+
+	deleteRecords(details.Db, "orders")
+	deleteRecords(details.Db, "stock_ledger")
+	dropDatabase(details.Db)
+}
+
+func TestOrderKeptWhenBookkeepingFails(t *testing.T) {
+	details := NewServerDB()
+
+	initializeOrdersRoutes(details)
+	initializeCartRoutes(details)
+	initializeUserRoutes(details)
+
+	ctx := context.Background()
+
+	user := createUserTest(t, details, "Harry")
+	product := createProduct(t, details, "Chandlers Bags")
+	addProductToCart(t, details, user, product.ID, 2)
+
+	coupon, err := repository.CreateCoupon(details.Db.Collection("coupons"), user.ID, 10, "test", time.Hour)
+	require.NoError(t, err)
+
+	// The ledger refuses sales, so recording the stock the order took fails once the order is saved
+	err = details.Db.CreateCollection(ctx, "stock_ledger",
+		options.CreateCollection().SetValidator(bson.M{"reason": bson.M{"$ne": entity.StockSale}}))
+	require.NoError(t, err)
+
+	oReq := OrderAllCartItemsRequest{
+		Fullname:      user.Username,
+		PaymentMethod: "card",
+		Location:      entity.Location{CityOrTown: "My Town", Country: "Nigeria"},
+		Coupon:        coupon.Code,
+	}
+
+	oReqJson, _ := json.Marshal(oReq)
+	req, err := http.NewRequest("POST", "/products/order/cart", bytes.NewBuffer(oReqJson))
+	req.Header.Add("Authorization", "Bearer "+user.Token)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+
+	var result OrderProductResult
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+	require.NotNil(t, result.Order)
+	require.Equal(t, coupon.Code, result.Order.CouponCode)
+
+	count, err := details.Db.Collection("orders").CountDocuments(ctx, bson.M{"_id": result.OrderID})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), count)
+
+	// The coupon paid for the saved order, so it stays used
+	var used entity.Coupon
+	require.NoError(t, details.Db.Collection("coupons").FindOne(ctx, bson.M{"_id": coupon.Code}).Decode(&used))
+	require.False(t, used.UsedAt.IsZero())
+
+	deleteRecords(details.Db, "orders")
+	deleteRecords(details.Db, "cart")
+	dropDatabase(details.Db)
+}
+
+func TestOrderPlacedWhenLowStockAlertFails(t *testing.T) {
+	details := NewServerDB()
+
+	initializeOrdersRoutes(details)
+	initializeUserRoutes(details)
+
+	ctx := context.Background()
+
+	// Any order takes the product to its threshold, and the alert cannot be saved
+	product := createProduct(t, details, "Chandlers Bags")
+	_, err := details.Db.Collection("products").UpdateByID(ctx, product.ID, bson.M{"$set": bson.M{"low_stock_threshold": product.Quantity}})
+	require.NoError(t, err)
+
+	err = details.Db.CreateCollection(ctx, "stock_alerts",
+		options.CreateCollection().SetValidator(bson.M{"_id": bson.M{"$exists": false}}))
+	require.NoError(t, err)
+
+	user := createUserTest(t, details, "Harry")
+	orderID := orderProductTest(t, details, user, product.ID)
+	require.NotZero(t, orderID)
+
+	after, err := repository.FindOneProduct(details.Db.Collection("products"), product.ID)
+	require.NoError(t, err)
+	require.Equal(t, product.Quantity-9, after.Quantity)
+
+	deleteRecords(details.Db, "orders")
+	dropDatabase(details.Db)
+}
+
+func TestCancelOrderFinishesRestock(t *testing.T) {
+	details := NewServerDB()
+
+	initializeOrdersRoutes(details)
+	initializeCartRoutes(details)
+	initializeUserRoutes(details)
+
+	ctx := context.Background()
+
+	user := createUserTest(t, details, "Harry")
+	product := createProduct(t, details, "Chandlers Bags")
+	addProductToCart(t, details, user, product.ID, 2)
+
+	coupon, err := repository.CreateCoupon(details.Db.Collection("coupons"), user.ID, 10, "test", time.Hour)
+	require.NoError(t, err)
+
+	order, err := repository.OrderAllCartItems(details.Db.Collection("orders"), user.ID, user.Username, "card", "",
+		coupon.Code, entity.Location{CityOrTown: "My Town", Country: "Nigeria"})
+	require.NoError(t, err)
+
+	// A cancel whose restock failed after the status was written
+	_, err = details.Db.Collection("orders").UpdateOne(ctx, bson.M{"_id": order.ID}, bson.M{"$set": bson.M{
+		"status":          entity.OrderCancelled,
+		"restock_pending": true,
+		"restocked":       0,
+	}})
+	require.NoError(t, err)
+
+	// Cancelling again finishes the restock rather than being refused
+	cancelled, err := repository.TransitionOrder(details.Db, order.ID, entity.OrderCancelled, "admin", "")
+	require.NoError(t, err)
+	require.False(t, cancelled.RestockPending)
+
+	restocked, err := repository.FindOneProduct(details.Db.Collection("products"), product.ID)
+	require.NoError(t, err)
+	require.Equal(t, product.Quantity, restocked.Quantity)
+
+	// The coupon was not spent on anything
+	var restored entity.Coupon
+	require.NoError(t, details.Db.Collection("coupons").FindOne(ctx, bson.M{"_id": coupon.Code}).Decode(&restored))
+	require.True(t, restored.UsedAt.IsZero())
+
+	// Once the stock is back the order is final
+	_, err = repository.TransitionOrder(details.Db, order.ID, entity.OrderCancelled, "admin", "")
+	require.ErrorIs(t, err, repository.ErrIllegalTransition)
+
+	restocked, err = repository.FindOneProduct(details.Db.Collection("products"), product.ID)
 	require.NoError(t, err)
-	require.True(t, order.IsReceived)
+	require.Equal(t, product.Quantity, restocked.Quantity)
 
 	deleteRecords(details.Db, "orders")
+	deleteRecords(details.Db, "cart")
 	dropDatabase(details.Db)
 }
@@ -1,10 +1,13 @@
 package controller
 
 import (
-	"fmt"
+	"errors"
 	"math"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Emmrys-Jay/ecommerce-api/db"
 	"github.com/Emmrys-Jay/ecommerce-api/entity"
@@ -19,6 +22,8 @@ type FindProductsRequest struct {
 	Name     string `json:"name" form:"name" bson:"name"`
 	PageID   int64  `json:"page_id" form:"page_id" bson:"page_id"`
 	PageSize int64  `json:"page_size" form:"page_size" bson:"page_size"`
+	Currency string `json:"currency" form:"currency" bson:"currency"`
+	Tags     string `json:"tags" form:"tags" bson:"tags" description:"comma separated tags a product must all have"`
 }
 
 // FindProductsResult models the find products request result
@@ -47,6 +52,11 @@ func (u *UserController) FindProducts(ctx *gin.Context) {
 		req.PageSize = 5
 	}
 
+	displayCode, ok := displayCurrency(ctx, req.Currency)
+	if !ok {
+		return
+	}
+
 	// initialize and define new struct with names easy to understand while querying the database
 	var param = struct {
 		Name   string
@@ -58,7 +68,7 @@ func (u *UserController) FindProducts(ctx *gin.Context) {
 		Limit:  req.PageSize,
 	}
 
-	products, length, err := repository.FindProducts(collection, param.Name, param.Offset, param.Limit)
+	products, length, err := repository.FindProducts(collection, param.Name, productFilter(ctx, req.Tags), param.Offset, param.Limit)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
@@ -68,6 +78,10 @@ func (u *UserController) FindProducts(ctx *gin.Context) {
 		return
 	}
 
+	if !applyDisplayCurrency(ctx, u.Database, products, displayCode) {
+		return
+	}
+
 	NoOfPages := math.Ceil(float64(length) / float64(int(req.PageSize)))
 
 	response := FindProductsResult{
@@ -84,7 +98,22 @@ func (u *UserController) FindProducts(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, response)
 }
 
-// FindOneProduct returns a single product with the specified ID
+// productFilter reads the tag and attribute filters of a product listing.
+// Attributes are filtered with "attr[key]=value" query params, e.g. attr[color]=red,blue or attr[screen_size]=13..15.
+func productFilter(ctx *gin.Context, tags string) repository.ProductFilter {
+	var filter = repository.ProductFilter{
+		Attributes: ctx.QueryMap("attr"),
+	}
+
+	if tags != "" {
+		filter.Tags = strings.Split(tags, ",")
+	}
+
+	return filter
+}
+
+// FindOneProduct returns a single product with the specified ID.
+// The "questions" query param includes up to that many of the product's most answered questions.
 func (u *UserController) FindOneProduct(ctx *gin.Context) {
 	collection := db.GetCollection(u.Database, "products")
 
@@ -94,6 +123,26 @@ func (u *UserController) FindOneProduct(ctx *gin.Context) {
 		return
 	}
 
+	displayCode, ok := displayCurrency(ctx, ctx.Query("currency"))
+	if !ok {
+		return
+	}
+
+	// Optionally include the product's most answered questions, e.g. ?questions=3
+	var questions int
+	if v := ctx.Query("questions"); v != "" {
+		var err error
+		questions, err = strconv.Atoi(v)
+		if err != nil || questions < 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid params - questions"})
+			return
+		}
+
+		if questions > maxTopQuestions {
+			questions = maxTopQuestions
+		}
+	}
+
 	product, err := repository.FindOneProduct(collection, productID)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
@@ -104,47 +153,88 @@ func (u *UserController) FindOneProduct(ctx *gin.Context) {
 		return
 	}
 
-	ctx.JSON(http.StatusOK, product)
-}
+	// Drafts, archived and not yet published products look the same as missing ones to shoppers
+	if !product.IsLive(time.Now()) {
+		ctx.JSON(http.StatusNotFound, util.ErrorResponse(mongo.ErrNoDocuments))
+		return
+	}
 
-/*
-*	AddReview adds a review to a single product
-*	Params:
-*		- Name of Product - URL param
-*		- Product Review - json Body
- */
-
-//  type Review struct {
-// 	User      string    `json:"user"`
-// 	Stars     int64     `json:"stars" binding:"required"`
-// 	Comment   string    `json:"comment,omitempty"`
-// 	CreatedAt time.Time `json:"created_at"`
-// }
+	if questions > 0 {
+		product.TopQuestions, err = repository.GetTopQuestions(u.Database, productID, questions)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+			return
+		}
+	}
 
-func (u *UserController) AddReview(ctx *gin.Context) {
+	products := []entity.Product{*product}
+	if !applyDisplayCurrency(ctx, u.Database, products, displayCode) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, products[0])
+}
+
+// FindProductBySlug returns the product with a slug. Old slugs of renamed products redirect to the current one.
+func (u *UserController) FindProductBySlug(ctx *gin.Context) {
 	collection := db.GetCollection(u.Database, "products")
-	var review entity.Review
 
-	if err := ctx.ShouldBindJSON(&review); err != nil {
-		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+	slug := ctx.Param("slug")
+	if slug == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "no url param specified"})
+		return
+	}
+
+	displayCode, ok := displayCurrency(ctx, ctx.Query("currency"))
+	if !ok {
 		return
 	}
 
-	username, err := util.UsernameFromToken(ctx)
+	product, redirected, err := repository.FindProductBySlug(collection, slug)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		if err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
 		return
 	}
 
-	review.User = username
+	if !product.IsLive(time.Now()) {
+		ctx.JSON(http.StatusNotFound, util.ErrorResponse(mongo.ErrNoDocuments))
+		return
+	}
 
-	productID := ctx.Param("productID")
-	if productID == "" {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - product ID"})
+	if redirected {
+		location := url.URL{Path: "/products/slug/" + product.Slug, RawQuery: ctx.Request.URL.RawQuery}
+		ctx.Redirect(http.StatusMovedPermanently, location.String())
+		return
+	}
+
+	products := []entity.Product{*product}
+	if !applyDisplayCurrency(ctx, u.Database, products, displayCode) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, products[0])
+}
+
+// FindProductBySKU returns the product with a SKU
+func (u *UserController) FindProductBySKU(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "products")
+
+	sku := ctx.Param("sku")
+	if sku == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "no url param specified"})
 		return
 	}
 
-	result, err := repository.AddProductReview(collection, productID, review)
+	displayCode, ok := displayCurrency(ctx, ctx.Query("currency"))
+	if !ok {
+		return
+	}
+
+	product, err := repository.FindProductBySKU(collection, sku)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
@@ -154,8 +244,17 @@ func (u *UserController) AddReview(ctx *gin.Context) {
 		return
 	}
 
-	response := fmt.Sprintf("updated %d document with id: %s", result.ModifiedCount, productID)
-	ctx.JSON(http.StatusOK, gin.H{"response": response})
+	if !product.IsLive(time.Now()) {
+		ctx.JSON(http.StatusNotFound, util.ErrorResponse(mongo.ErrNoDocuments))
+		return
+	}
+
+	products := []entity.Product{*product}
+	if !applyDisplayCurrency(ctx, u.Database, products, displayCode) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, products[0])
 }
 
 // GetProductCategories returns the unique categories of products currently stored in the database
@@ -227,6 +326,11 @@ func (u *UserController) GetProductsByCategory(ctx *gin.Context) {
 		return
 	}
 
+	displayCode, ok := displayCurrency(ctx, ctx.Query("currency"))
+	if !ok {
+		return
+	}
+
 	if pageIDString != "" {
 		pageID, err = strconv.Atoi(pageIDString)
 		if err != nil {
@@ -247,12 +351,20 @@ func (u *UserController) GetProductsByCategory(ctx *gin.Context) {
 		Limit:  pageSize,
 	}
 
-	products, length, err := repository.GetProductsByCategory(collection, ctgy, param.Offset, param.Limit)
+	products, length, err := repository.GetProductsByCategory(collection, ctgy, productFilter(ctx, ctx.Query("tags")), param.Offset, param.Limit)
 	if err != nil {
+		if errors.Is(err, repository.ErrInvalidAttribute) {
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
 		return
 	}
 
+	if !applyDisplayCurrency(ctx, u.Database, products, displayCode) {
+		return
+	}
+
 	NoOfPages := math.Ceil(float64(length) / float64(int(pageSize)))
 
 	response := FindProductsResult{
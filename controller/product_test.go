@@ -15,6 +15,7 @@ import (
 	"github.com/Emmrys-Jay/ecommerce-api/entity"
 	"github.com/Emmrys-Jay/ecommerce-api/util"
 	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -227,3 +228,261 @@ func TestGetProductsByCategory(t *testing.T) {
 	deleteRecords(details.Db, "products")
 	dropDatabase(details.Db)
 }
+
+func TestGetProductsByCategoryAfterBundle(t *testing.T) {
+	details := NewServerDB()
+
+	initializeProductRoutes(details)
+
+	bag := createProduct(t, details, "Chandlers Bags")
+
+	// The bundle is listed first, so its bundle fields must not carry over to the product after it
+	bundle := entity.Product{
+		ID:             primitive.NewObjectIDFromTimestamp(time.Now()).Hex(),
+		Name:           "Travel Kit",
+		Type:           entity.ProductTypeBundle,
+		Currency:       bag.Currency,
+		Category:       "kits",
+		Components:     []entity.BundleComponent{{ProductID: bag.ID, Quantity: 2}},
+		BundleDiscount: 10,
+		Tags:           []string{"travel"},
+		Attributes:     map[string]interface{}{"colour": "red"},
+		CreatedAt:      time.Now(),
+	}
+	_, err := details.Db.Collection("products").InsertOne(context.Background(), bundle)
+	require.NoError(t, err)
+
+	plain := createProduct(t, details, "Nike Shoes", "kits")
+
+	req, err := http.NewRequest("GET", "/products/get/kits", nil)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+
+	var result FindProductsResult
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+	require.Len(t, result.Data, 2)
+
+	for _, v := range result.Data {
+		if v.ID != plain.ID {
+			continue
+		}
+
+		require.Empty(t, v.Type)
+		require.Empty(t, v.Components)
+		require.Empty(t, v.Tags)
+		require.Empty(t, v.Attributes)
+		require.Equal(t, plain.Price, v.Price)
+		require.Equal(t, plain.Quantity, v.Quantity)
+	}
+
+	deleteRecords(details.Db, "products")
+	dropDatabase(details.Db)
+}
+
+func TestFindOneProductOnSale(t *testing.T) {
+	details := NewServerDB()
+
+	initializeProductRoutes(details)
+
+	product := entity.Product{
+		ID:          primitive.NewObjectID().Hex(),
+		Name:        "Chandlers Rags",
+		Price:       1000,
+		Currency:    "CAD",
+		Quantity:    10,
+		Description: util.RandomString(),
+		Category:    util.RandomString(),
+		Sales: []entity.Sale{
+			{
+				ID:       primitive.NewObjectID().Hex(),
+				Price:    750,
+				StartsAt: time.Now().Add(-time.Hour),
+				EndsAt:   time.Now().Add(time.Hour),
+			},
+			{
+				ID:       primitive.NewObjectID().Hex(),
+				Price:    500,
+				StartsAt: time.Now().Add(24 * time.Hour),
+				EndsAt:   time.Now().Add(48 * time.Hour),
+			},
+		},
+	}
+
+	_, err := details.Db.Collection("products").InsertOne(context.Background(), product)
+	require.NoError(t, err)
+
+	path := fmt.Sprintf("/products/findone/%s", product.ID)
+	req, err := http.NewRequest("GET", path, nil)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+
+	var result = entity.Product{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	require.NoError(t, err)
+
+	// Only the running sale applies, the scheduled one does not count yet
+	require.Equal(t, product.Price, result.Price)
+	require.Equal(t, 750.0, result.EffectivePrice)
+	require.True(t, result.OnSale)
+	require.Equal(t, 750.0, result.LowestPrice30Days)
+
+	deleteRecords(details.Db, "products")
+	dropDatabase(details.Db)
+}
+
+func TestFindProductBySlugAndSKU(t *testing.T) {
+	details := NewServerDB()
+
+	initializeProductRoutes(details)
+
+	product := entity.Product{
+		ID:            primitive.NewObjectID().Hex(),
+		Name:          "Chandlers Rags",
+		SKU:           "CHA-0A1B2C",
+		Slug:          "chandlers-rags",
+		PreviousSlugs: []string{"chandler-rags"},
+		Price:         1000,
+		Currency:      "CAD",
+		Quantity:      10,
+		Description:   util.RandomString(),
+		Category:      util.RandomString(),
+	}
+
+	_, err := details.Db.Collection("products").InsertOne(context.Background(), product)
+	require.NoError(t, err)
+
+	for _, path := range []string{"/products/slug/chandlers-rags", "/products/sku/CHA-0A1B2C"} {
+		req, err := http.NewRequest("GET", path, nil)
+		require.NoError(t, err)
+
+		recorder := httptest.NewRecorder()
+		details.Server.ServeHTTP(recorder, req)
+		require.Equal(t, 200, recorder.Code)
+
+		var result = entity.Product{}
+		err = json.Unmarshal(recorder.Body.Bytes(), &result)
+		require.NoError(t, err)
+		require.Equal(t, product.ID, result.ID)
+	}
+
+	// The slug used before the product was renamed redirects to the current one
+	req, err := http.NewRequest("GET", "/products/slug/chandler-rags?currency=CAD", nil)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, 301, recorder.Code)
+	require.Equal(t, "/products/slug/chandlers-rags?currency=CAD", recorder.Header().Get("Location"))
+
+	req, err = http.NewRequest("GET", "/products/slug/unknown-rags", nil)
+	require.NoError(t, err)
+
+	recorder = httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, 404, recorder.Code)
+
+	deleteRecords(details.Db, "products")
+	dropDatabase(details.Db)
+}
+
+func TestGetProductsByCategoryWithAttributes(t *testing.T) {
+	details := NewServerDB()
+
+	initializeProductRoutes(details)
+
+	productsCategory := "laptops"
+	for i, v := range productNames {
+		product := createProduct(t, details, v, productsCategory)
+
+		update := bson.M{"$set": bson.M{
+			"attributes": bson.M{"ram": float64(8 * (i + 1)), "color": []string{"black", "silver"}[i%2]},
+			"tags":       []string{"laptop", []string{"gaming", "office"}[i%2]},
+		}}
+		_, err := details.Db.Collection("products").UpdateByID(context.Background(), product.ID, update)
+		require.NoError(t, err)
+	}
+
+	// ram is 8, 16, 24, 32 and 40; black laptops are tagged gaming
+	for query, expected := range map[string]int{
+		"attr[ram]=16..32":                     3,
+		"attr[ram]=..16&attr[color]=black":     1,
+		"attr[color]=black,silver&tags=office": 2,
+		"tags=laptop,gaming":                   3,
+	} {
+		path := fmt.Sprintf("/products/get/%s?%s", productsCategory, query)
+		req, err := http.NewRequest("GET", path, nil)
+		require.NoError(t, err)
+
+		recorder := httptest.NewRecorder()
+		details.Server.ServeHTTP(recorder, req)
+		require.Equal(t, 200, recorder.Code)
+
+		var result FindProductsResult
+		err = json.Unmarshal(recorder.Body.Bytes(), &result)
+		require.NoError(t, err)
+		require.Equal(t, int64(expected), result.ResultsFound, query)
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/products/get/%s?attr[ram]=..", productsCategory), nil)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, 400, recorder.Code)
+
+	deleteRecords(details.Db, "products")
+	dropDatabase(details.Db)
+}
+
+func TestProductLifecycle(t *testing.T) {
+	details := NewServerDB()
+
+	initializeProductRoutes(details)
+
+	productsCategory := "lifecycle"
+	statuses := []bson.M{
+		{"status": entity.ProductDraft},
+		{"status": entity.ProductArchived},
+		{"status": entity.ProductScheduled, "publish_at": time.Now().Add(time.Hour)},
+		{"status": entity.ProductScheduled, "publish_at": time.Now().Add(-time.Hour)},
+		{"status": entity.ProductPublished},
+	}
+
+	var ids []string
+	for i, v := range productNames {
+		product := createProduct(t, details, v, productsCategory)
+		_, err := details.Db.Collection("products").UpdateByID(context.Background(), product.ID, bson.M{"$set": statuses[i]})
+		require.NoError(t, err)
+		ids = append(ids, product.ID)
+	}
+
+	// Only the published product and the one scheduled an hour ago are live
+	req, err := http.NewRequest("GET", fmt.Sprintf("/products/get/%s", productsCategory), nil)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+
+	var result FindProductsResult
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+	require.Equal(t, int64(2), result.ResultsFound)
+
+	for i, expectedCode := range []int{404, 404, 404, 200, 200} {
+		req, err := http.NewRequest("GET", fmt.Sprintf("/products/findone/%s", ids[i]), nil)
+		require.NoError(t, err)
+
+		recorder := httptest.NewRecorder()
+		details.Server.ServeHTTP(recorder, req)
+		require.Equal(t, expectedCode, recorder.Code, statuses[i])
+	}
+
+	deleteRecords(details.Db, "products")
+	dropDatabase(details.Db)
+}
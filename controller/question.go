@@ -0,0 +1,242 @@
+package controller
+
+import (
+	"math"
+	"net/http"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/repository"
+	"github.com/Emmrys-Jay/ecommerce-api/util"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxTopQuestions caps the number of questions FindOneProduct includes
+const maxTopQuestions = 10
+
+// GetQuestionsRequest models the query params of a questions or answers listing
+type GetQuestionsRequest struct {
+	PageID   int `form:"page_id"`
+	PageSize int `form:"page_size"`
+}
+
+func (r *GetQuestionsRequest) normalize() {
+	if r.PageID < 1 {
+		r.PageID = 1
+	}
+
+	if r.PageSize < 5 {
+		r.PageSize = 5
+	}
+}
+
+// AskQuestion adds the logged in user's question to a product
+func (u *UserController) AskQuestion(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "questions")
+	var req entity.QuestionRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	productID := ctx.Param("productID")
+	if productID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - product ID"})
+		return
+	}
+
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	username, err := util.UsernameFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	question, err := repository.CreateQuestion(collection, productID, userID, username, req.Body)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	// Flagged questions are accepted but only go live once an admin approves them
+	if question.Status == entity.ModerationPending {
+		ctx.JSON(http.StatusAccepted, question)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, question)
+}
+
+// GetProductQuestions returns a paginated list of a product's questions, most answered first, with their most helpful answers
+func (u *UserController) GetProductQuestions(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "questions")
+	var req GetQuestionsRequest
+
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	productID := ctx.Param("productID")
+	if productID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - product ID"})
+		return
+	}
+
+	req.normalize()
+
+	questions, length, err := repository.GetProductQuestions(collection, productID, req.PageSize*(req.PageID-1), req.PageSize)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	response := entity.PaginationResponse{
+		PageID:        req.PageID,
+		ResultsFound:  int(length),
+		NumberOfPages: int(math.Ceil(float64(length) / float64(req.PageSize))),
+		Data:          questions,
+	}
+
+	if response.NumberOfPages < 1 {
+		response.PageID = 0
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// AnswerQuestion answers a product question as the logged in user, who must have received the product
+func (u *UserController) AnswerQuestion(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "answers")
+	var req entity.QuestionRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	questionID := ctx.Param("question-id")
+	if questionID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - question ID"})
+		return
+	}
+
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	username, err := util.UsernameFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	answer, err := repository.CreateAnswer(collection, questionID, userID, username, req.Body, false)
+	if err != nil {
+		switch err {
+		case mongo.ErrNoDocuments:
+			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+		case repository.ErrNotVerifiedBuyer:
+			ctx.JSON(http.StatusForbidden, util.ErrorResponse(err))
+		default:
+			ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		}
+		return
+	}
+
+	if answer.Status == entity.ModerationPending {
+		ctx.JSON(http.StatusAccepted, answer)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, answer)
+}
+
+// GetQuestionAnswers returns a paginated list of a question's answers, most helpful first
+func (u *UserController) GetQuestionAnswers(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "answers")
+	var req GetQuestionsRequest
+
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	questionID := ctx.Param("question-id")
+	if questionID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - question ID"})
+		return
+	}
+
+	req.normalize()
+
+	answers, length, err := repository.GetQuestionAnswers(collection, questionID, req.PageSize*(req.PageID-1), req.PageSize)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	response := entity.PaginationResponse{
+		PageID:        req.PageID,
+		ResultsFound:  int(length),
+		NumberOfPages: int(math.Ceil(float64(length) / float64(req.PageSize))),
+		Data:          answers,
+	}
+
+	if response.NumberOfPages < 1 {
+		response.PageID = 0
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// VoteAnswer records whether a shopper found an answer helpful or unhelpful
+func (u *UserController) VoteAnswer(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "answers")
+	var req VoteReviewRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	answerID := ctx.Param("answer-id")
+	if answerID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - answer ID"})
+		return
+	}
+
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	answer, err := repository.VoteAnswer(collection, answerID, userID, *req.Helpful)
+	if err != nil {
+		switch err {
+		case mongo.ErrNoDocuments:
+			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+		case repository.ErrOwnAnswer:
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		default:
+			ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, answer)
+}
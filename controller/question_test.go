@@ -0,0 +1,78 @@
+// question_test
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestProductQuestions(t *testing.T) {
+	details := NewServerDB()
+
+	initializeProductRoutes(details)
+	initializeUserRoutes(details)
+
+	product := createProduct(t, details, "Chandlers Bags")
+	asker := createUserTest(t, details, "Harry")
+	buyer := createUserTest(t, details, "Ronald")
+	voter := createUserTest(t, details, "Hermione")
+
+	body, _ := json.Marshal(entity.QuestionRequest{Body: "Does it fit a 15 inch laptop?"})
+	recorder := wishlistRequestTest(t, details, asker, "POST", "/products/questions/"+product.ID, body)
+	require.Equal(t, 200, recorder.Code)
+
+	var question entity.Question
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &question))
+
+	// Only shoppers who have received the product can answer
+	body, _ = json.Marshal(entity.QuestionRequest{Body: "Yes, with room to spare."})
+	recorder = wishlistRequestTest(t, details, buyer, "POST", "/products/answers/"+question.ID, body)
+	require.Equal(t, 403, recorder.Code)
+
+	_, err := details.Db.Collection("orders").InsertOne(context.Background(), entity.Order{
+		ID:     primitive.NewObjectID().Hex(),
+		UserID: buyer.ID,
+		Lines:  []entity.OrderLine{{ProductID: product.ID, Product: *product, Quantity: 1}},
+		Status: entity.OrderCompleted,
+	})
+	require.NoError(t, err)
+
+	recorder = wishlistRequestTest(t, details, buyer, "POST", "/products/answers/"+question.ID, body)
+	require.Equal(t, 200, recorder.Code)
+
+	var answer entity.Answer
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &answer))
+	require.True(t, answer.VerifiedBuyer)
+
+	body, _ = json.Marshal(VoteReviewRequest{Helpful: new(bool)})
+	recorder = wishlistRequestTest(t, details, buyer, "POST", "/products/answers/vote/"+answer.ID, body)
+	require.Equal(t, 400, recorder.Code)
+
+	helpful := true
+	body, _ = json.Marshal(VoteReviewRequest{Helpful: &helpful})
+	recorder = wishlistRequestTest(t, details, voter, "POST", "/products/answers/vote/"+answer.ID, body)
+	require.Equal(t, 200, recorder.Code)
+
+	recorder = wishlistRequestTest(t, details, voter, "GET", fmt.Sprintf("/products/findone/%s?questions=3", product.ID), nil)
+	require.Equal(t, 200, recorder.Code)
+
+	var result entity.Product
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+	require.Len(t, result.TopQuestions, 1)
+	require.Equal(t, int64(1), result.TopQuestions[0].AnswerCount)
+	require.Len(t, result.TopQuestions[0].Answers, 1)
+	require.Equal(t, int64(1), result.TopQuestions[0].Answers[0].HelpfulVotes)
+
+	deleteRecords(details.Db, "questions")
+	deleteRecords(details.Db, "answers")
+	deleteRecords(details.Db, "answer_votes")
+	deleteRecords(details.Db, "orders")
+	dropDatabase(details.Db)
+}
@@ -0,0 +1,301 @@
+package controller
+
+import (
+	"math"
+	"net/http"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/repository"
+	"github.com/Emmrys-Jay/ecommerce-api/util"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// GetProductReviewsRequest models the query params of a product reviews listing
+type GetProductReviewsRequest struct {
+	PageID   int    `form:"page_id"`
+	PageSize int    `form:"page_size"`
+	Sort     string `form:"sort"`
+}
+
+// GetProductReviewsResult models a page of product reviews along with the product's rating summary
+type GetProductReviewsResult struct {
+	PageID        int                  `json:"page_id"`
+	ResultsFound  int                  `json:"results_found"`
+	NumberOfPages int                  `json:"no_of_pages"`
+	Summary       entity.RatingSummary `json:"summary"`
+	Data          []entity.Review      `json:"data"`
+}
+
+// AddReview adds the logged in user's review to a product. A user can only review a product once.
+func (u *UserController) AddReview(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "reviews")
+	var req entity.ReviewRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	productID := ctx.Param("productID")
+	if productID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - product ID"})
+		return
+	}
+
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	username, err := util.UsernameFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	review, err := repository.CreateReview(collection, productID, userID, username, req)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+			return
+		}
+		if err == repository.ErrAlreadyReviewed {
+			ctx.JSON(http.StatusConflict, util.ErrorResponse(err))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	// Flagged reviews are accepted but only go live once an admin approves them
+	if review.Status == entity.ModerationPending {
+		ctx.JSON(http.StatusAccepted, review)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, review)
+}
+
+// UpdateReview edits the logged in user's review of a product
+func (u *UserController) UpdateReview(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "reviews")
+	var req entity.ReviewRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	productID := ctx.Param("productID")
+	if productID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - product ID"})
+		return
+	}
+
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	review, err := repository.UpdateReview(collection, productID, userID, req)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "you have not reviewed this product"})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, review)
+}
+
+// DeleteReview removes the logged in user's review of a product
+func (u *UserController) DeleteReview(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "reviews")
+
+	productID := ctx.Param("productID")
+	if productID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - product ID"})
+		return
+	}
+
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	result, err := repository.DeleteReview(collection, productID, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	if result.DeletedCount == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "you have not reviewed this product"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"success": "deleted review"})
+}
+
+// GetProductReviews returns a paginated list of a product's approved reviews, sorted by newest, oldest, highest, lowest or helpful
+func (u *UserController) GetProductReviews(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "reviews")
+	var req GetProductReviewsRequest
+
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	productID := ctx.Param("productID")
+	if productID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - product ID"})
+		return
+	}
+
+	if req.Sort == "" {
+		req.Sort = "newest"
+	}
+
+	if !repository.IsValidReviewSort(req.Sort) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - sort"})
+		return
+	}
+
+	if req.PageID < 1 {
+		req.PageID = 1
+	}
+
+	if req.PageSize < 5 {
+		req.PageSize = 5
+	}
+
+	var param = struct {
+		Offset int
+		Limit  int
+	}{
+		Offset: req.PageSize * (req.PageID - 1),
+		Limit:  req.PageSize,
+	}
+
+	reviews, length, err := repository.GetProductReviews(collection, productID, req.Sort, param.Offset, param.Limit)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	summary, err := repository.GetRatingSummary(collection, productID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	response := GetProductReviewsResult{
+		PageID:        req.PageID,
+		ResultsFound:  int(length),
+		NumberOfPages: int(math.Ceil(float64(length) / float64(req.PageSize))),
+		Summary:       *summary,
+		Data:          reviews,
+	}
+
+	if response.NumberOfPages < 1 {
+		response.PageID = 0
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// ReportReviewRequest models the body of a report review request
+type ReportReviewRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+// ReportReview lets a shopper report an abusive review
+func (u *UserController) ReportReview(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "reviews")
+	var req ReportReviewRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	reviewID := ctx.Param("review-id")
+	if reviewID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - review ID"})
+		return
+	}
+
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	_, err = repository.ReportReview(collection, reviewID, userID, req.Reason)
+	if err != nil {
+		switch err {
+		case mongo.ErrNoDocuments:
+			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+		case repository.ErrAlreadyReported:
+			ctx.JSON(http.StatusConflict, util.ErrorResponse(err))
+		case repository.ErrOwnReview:
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		default:
+			ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"success": "review reported"})
+}
+
+// VoteReviewRequest models the body of a helpfulness vote
+type VoteReviewRequest struct {
+	Helpful *bool `json:"helpful" binding:"required"`
+}
+
+// VoteReview records whether a shopper found a review helpful or unhelpful
+func (u *UserController) VoteReview(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "reviews")
+	var req VoteReviewRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	reviewID := ctx.Param("review-id")
+	if reviewID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - review ID"})
+		return
+	}
+
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	review, err := repository.VoteReview(collection, reviewID, userID, *req.Helpful)
+	if err != nil {
+		switch err {
+		case mongo.ErrNoDocuments:
+			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+		case repository.ErrOwnReview:
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		default:
+			ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, review)
+}
@@ -0,0 +1,220 @@
+// review_test
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func sendReviewTest(t *testing.T, details *ServerDB, user entity.UserResponse, method, path string, stars int64) *httptest.ResponseRecorder {
+	rReq := entity.ReviewRequest{
+		Stars:   stars,
+		Comment: "Great product",
+	}
+
+	rJson, _ := json.Marshal(rReq)
+	req, err := http.NewRequest(method, path, bytes.NewBuffer(rJson))
+	req.Header.Add("Authorization", "Bearer "+user.Token)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+
+	return recorder
+}
+
+func getProductReviewsTest(t *testing.T, details *ServerDB, productID string) GetProductReviewsResult {
+	path := fmt.Sprintf("/products/reviews/%s?sort=highest", productID)
+	req, err := http.NewRequest("GET", path, nil)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+
+	var result GetProductReviewsResult
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	require.NoError(t, err)
+
+	return result
+}
+
+func TestGetProductReviews(t *testing.T) {
+	details := NewServerDB()
+
+	initializeProductRoutes(details)
+	initializeUserRoutes(details)
+
+	product := createProduct(t, details, "Chandlers Rags")
+	addPath := fmt.Sprintf("/products/%s/addreview", product.ID)
+
+	for i, username := range []string{"Harry", "Ron", "Hermione"} {
+		user := createUserTest(t, details, username)
+		recorder := sendReviewTest(t, details, user, "PUT", addPath, int64(i+3))
+		require.Equal(t, 200, recorder.Code)
+	}
+
+	result := getProductReviewsTest(t, details, product.ID)
+	require.Equal(t, 3, result.ResultsFound)
+	require.Equal(t, 1, result.PageID)
+	require.Equal(t, int64(3), result.Summary.NoOfReviews)
+	require.Equal(t, 4.0, result.Summary.AverageRating)
+	require.Equal(t, int64(1), result.Summary.Histogram.Five)
+	require.Equal(t, int64(5), result.Data[0].Stars)
+
+	for _, v := range result.Data {
+		require.Equal(t, product.ID, v.ProductID)
+		require.NotZero(t, v.UserID)
+		require.False(t, v.VerifiedPurchase)
+	}
+
+	deleteRecords(details.Db, "reviews")
+	deleteRecords(details.Db, "products")
+	dropDatabase(details.Db)
+}
+
+func TestUpdateAndDeleteReview(t *testing.T) {
+	details := NewServerDB()
+
+	initializeProductRoutes(details)
+	initializeUserRoutes(details)
+
+	product := createProduct(t, details, "Chandlers Rags")
+	user := createUserTest(t, details, "Harry")
+
+	addPath := fmt.Sprintf("/products/%s/addreview", product.ID)
+	recorder := sendReviewTest(t, details, user, "PUT", addPath, 2)
+	require.Equal(t, 200, recorder.Code)
+
+	reviewPath := fmt.Sprintf("/products/reviews/%s", product.ID)
+	recorder = sendReviewTest(t, details, user, "PATCH", reviewPath, 4)
+	require.Equal(t, 200, recorder.Code)
+
+	result := getProductReviewsTest(t, details, product.ID)
+	require.Equal(t, 1, result.ResultsFound)
+	require.Equal(t, int64(4), result.Data[0].Stars)
+	require.Equal(t, user.ID, result.Data[0].UserID)
+	require.Equal(t, user.Username, result.Data[0].User)
+
+	recorder = sendReviewTest(t, details, user, "DELETE", reviewPath, 4)
+	require.Equal(t, 200, recorder.Code)
+
+	result = getProductReviewsTest(t, details, product.ID)
+	require.Equal(t, 0, result.ResultsFound)
+	require.Equal(t, int64(0), result.Summary.NoOfReviews)
+
+	// Deleting a review that no longer exists
+	recorder = sendReviewTest(t, details, user, "DELETE", reviewPath, 4)
+	require.Equal(t, 404, recorder.Code)
+
+	deleteRecords(details.Db, "reviews")
+	deleteRecords(details.Db, "products")
+	dropDatabase(details.Db)
+}
+
+func voteReviewTest(t *testing.T, details *ServerDB, user entity.UserResponse, reviewID string, helpful bool) entity.Review {
+	vJson, _ := json.Marshal(VoteReviewRequest{Helpful: &helpful})
+	path := fmt.Sprintf("/products/reviews/vote/%s", reviewID)
+	req, err := http.NewRequest("POST", path, bytes.NewBuffer(vJson))
+	req.Header.Add("Authorization", "Bearer "+user.Token)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+
+	var review entity.Review
+	err = json.Unmarshal(recorder.Body.Bytes(), &review)
+	require.NoError(t, err)
+
+	return review
+}
+
+func TestVoteReview(t *testing.T) {
+	details := NewServerDB()
+
+	initializeProductRoutes(details)
+	initializeUserRoutes(details)
+
+	product := createProduct(t, details, "Chandlers Rags")
+	author := createUserTest(t, details, "Harry")
+	voter := createUserTest(t, details, "Ron")
+
+	addPath := fmt.Sprintf("/products/%s/addreview", product.ID)
+	recorder := sendReviewTest(t, details, author, "PUT", addPath, 5)
+	require.Equal(t, 200, recorder.Code)
+
+	var review entity.Review
+	err := json.Unmarshal(recorder.Body.Bytes(), &review)
+	require.NoError(t, err)
+	require.Equal(t, entity.ModerationApproved, review.Status)
+
+	review = voteReviewTest(t, details, voter, review.ID, true)
+	require.Equal(t, int64(1), review.HelpfulVotes)
+	require.Equal(t, int64(0), review.UnhelpfulVotes)
+
+	// Voting again with the same value does not count twice
+	review = voteReviewTest(t, details, voter, review.ID, true)
+	require.Equal(t, int64(1), review.HelpfulVotes)
+
+	// Changing the vote moves it to the other counter
+	review = voteReviewTest(t, details, voter, review.ID, false)
+	require.Equal(t, int64(0), review.HelpfulVotes)
+	require.Equal(t, int64(1), review.UnhelpfulVotes)
+
+	deleteRecords(details.Db, "review_votes")
+	deleteRecords(details.Db, "reviews")
+	deleteRecords(details.Db, "products")
+	dropDatabase(details.Db)
+}
+
+func TestEditReviewKeepsModeration(t *testing.T) {
+	details := NewServerDB()
+
+	initializeProductRoutes(details)
+	initializeUserRoutes(details)
+
+	product := createProduct(t, details, "Chandlers Rags")
+	author := createUserTest(t, details, "Harry")
+	voter := createUserTest(t, details, "Ron")
+
+	addPath := fmt.Sprintf("/products/%s/addreview", product.ID)
+	recorder := sendReviewTest(t, details, author, "PUT", addPath, 2)
+	require.Equal(t, 200, recorder.Code)
+
+	var review entity.Review
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &review))
+
+	voteReviewTest(t, details, voter, review.ID, true)
+
+	reviews := details.Db.Collection("reviews")
+	_, err := reviews.UpdateOne(context.Background(), bson.M{"_id": review.ID},
+		bson.M{"$set": bson.M{"status": entity.ModerationRejected}})
+	require.NoError(t, err)
+
+	// Editing a rejected review does not put it back online, nor lose its votes
+	reviewPath := fmt.Sprintf("/products/reviews/%s", product.ID)
+	recorder = sendReviewTest(t, details, author, "PATCH", reviewPath, 4)
+	require.Equal(t, 200, recorder.Code)
+
+	var edited entity.Review
+	require.NoError(t, reviews.FindOne(context.Background(), bson.M{"_id": review.ID}).Decode(&edited))
+	require.Equal(t, entity.ModerationRejected, edited.Status)
+	require.Equal(t, int64(4), edited.Stars)
+	require.Equal(t, int64(1), edited.HelpfulVotes)
+
+	deleteRecords(details.Db, "review_votes")
+	deleteRecords(details.Db, "reviews")
+	deleteRecords(details.Db, "products")
+	dropDatabase(details.Db)
+}
@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/repository"
+	util "github.com/Emmrys-Jay/ecommerce-api/util"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type SaveForLaterRequest struct {
+	CartID string `json:"cart_id" binding:"required"`
+}
+
+// SaveForLater moves a line of the logged in user's cart to their saved for later list
+func (u *UserController) SaveForLater(ctx *gin.Context) {
+	var req SaveForLaterRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	item, err := repository.SaveForLater(u.Database, req.CartID, userID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, gin.H{"not found": "specified params did not match any document"})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"success": fmt.Sprintf("saved product for later with id: %s", item.ID)})
+}
+
+// MoveSavedItemToCart puts a product saved for later back in the logged in user's cart
+func (u *UserController) MoveSavedItemToCart(ctx *gin.Context) {
+	savedItemID := ctx.Param("saved-id")
+	if savedItemID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid params"})
+		return
+	}
+
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	item, err := repository.MoveSavedItemToCart(u.Database, savedItemID, userID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, gin.H{"not found": "product is not saved or no longer exists"})
+			return
+		}
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"success": fmt.Sprintf("moved product to cart with id: %s", item.ID)})
+}
+
+// RemoveSavedItem deletes a product from the logged in user's saved for later list
+func (u *UserController) RemoveSavedItem(ctx *gin.Context) {
+	savedItemID := ctx.Param("saved-id")
+	if savedItemID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid params"})
+		return
+	}
+
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	result, err := repository.RemoveSavedItem(db.GetCollection(u.Database, "saved_items"), savedItemID, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	if result.DeletedCount == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{"not found": "specified params did not match any document"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"success": "removed product from saved for later"})
+}
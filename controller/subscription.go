@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/repository"
+	"github.com/Emmrys-Jay/ecommerce-api/util"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CreateSubscriptionRequest models the body of a back-in-stock or price-drop subscription.
+// TargetPrice is only used by price_drop subscriptions and is in the product's currency.
+type CreateSubscriptionRequest struct {
+	ProductID   string  `json:"product_id" binding:"required"`
+	Type        string  `json:"type" binding:"required,oneof=back_in_stock price_drop"`
+	TargetPrice float64 `json:"target_price"`
+}
+
+// CreateSubscription subscribes the logged in user to a product coming back in stock or dropping in price.
+// They are notified once on their enabled notification channels, then the subscription is removed.
+func (u *UserController) CreateSubscription(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "subscriptions")
+	var req CreateSubscriptionRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	subscription, err := repository.CreateSubscription(collection, userID, req.ProductID, req.Type, req.TargetPrice)
+	if err != nil {
+		switch {
+		case err == mongo.ErrNoDocuments:
+			ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+		case err == repository.ErrAlreadySubscribed:
+			ctx.JSON(http.StatusConflict, util.ErrorResponse(err))
+		case err == repository.ErrSubscriptionMet, errors.Is(err, repository.ErrInvalidSubscription):
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		default:
+			ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, subscription)
+}
+
+// GetSubscriptions returns the logged in user's pending subscriptions
+func (u *UserController) GetSubscriptions(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "subscriptions")
+
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	subscriptions, err := repository.GetUserSubscriptions(collection, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"data": subscriptions})
+}
+
+// DeleteSubscription cancels one of the logged in user's subscriptions
+func (u *UserController) DeleteSubscription(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "subscriptions")
+
+	id := ctx.Param("id")
+	if id == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - subscription ID"})
+		return
+	}
+
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	result, err := repository.DeleteSubscription(collection, id, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	if result.DeletedCount == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "subscription not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"success": "deleted subscription"})
+}
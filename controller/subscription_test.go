@@ -0,0 +1,67 @@
+// subscription_test
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSubscriptions(t *testing.T) {
+	details := NewServerDB()
+
+	initializeNotificationRoutes(details)
+	initializeUserRoutes(details)
+
+	user := createUserTest(t, details, "Harry")
+	inStock := createProduct(t, details, "Chandlers Bags")
+	soldOut := createProduct(t, details, "Chris Rugs")
+
+	_, err := details.Db.Collection("products").UpdateByID(context.Background(), soldOut.ID, bson.M{"$set": bson.M{"quantity": 0}})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		req          CreateSubscriptionRequest
+		expectedCode int
+	}{
+		{CreateSubscriptionRequest{ProductID: soldOut.ID, Type: entity.SubscriptionBackInStock}, 200},
+		{CreateSubscriptionRequest{ProductID: soldOut.ID, Type: entity.SubscriptionBackInStock}, 409},
+		{CreateSubscriptionRequest{ProductID: inStock.ID, Type: entity.SubscriptionBackInStock}, 400},
+		{CreateSubscriptionRequest{ProductID: inStock.ID, Type: entity.SubscriptionPriceDrop, TargetPrice: 400000}, 400},
+		{CreateSubscriptionRequest{ProductID: inStock.ID, Type: entity.SubscriptionPriceDrop, TargetPrice: 300000}, 200},
+		{CreateSubscriptionRequest{ProductID: inStock.ID, Type: "discount"}, 400},
+	} {
+		body, _ := json.Marshal(tc.req)
+		recorder := wishlistRequestTest(t, details, user, "POST", "/user/subscriptions", body)
+		require.Equal(t, tc.expectedCode, recorder.Code, recorder.Body.String())
+	}
+
+	recorder := wishlistRequestTest(t, details, user, "GET", "/user/subscriptions", nil)
+	require.Equal(t, 200, recorder.Code)
+
+	var result struct {
+		Data []entity.Subscription `json:"data"`
+	}
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	require.NoError(t, err)
+	require.Len(t, result.Data, 2)
+
+	recorder = wishlistRequestTest(t, details, user, "DELETE", "/user/subscriptions/"+result.Data[0].ID, nil)
+	require.Equal(t, 200, recorder.Code)
+
+	body, _ := json.Marshal(NotificationChannelsRequest{Channels: []string{"pigeon"}})
+	recorder = wishlistRequestTest(t, details, user, "PUT", "/user/notifications/channels", body)
+	require.Equal(t, 400, recorder.Code)
+
+	body, _ = json.Marshal(NotificationChannelsRequest{Channels: []string{"in_app"}})
+	recorder = wishlistRequestTest(t, details, user, "PUT", "/user/notifications/channels", body)
+	require.Equal(t, 200, recorder.Code)
+
+	deleteRecords(details.Db, "subscriptions")
+	dropDatabase(details.Db)
+}
@@ -77,6 +77,7 @@ func (u *UserController) CreateUser(ctx *gin.Context) {
 		CreatedAt:      user.CreatedAt,
 		EmailIsVerfied: user.EmailIsVerfied,
 		MobileNumber:   user.MobileNumber,
+		CartMerge:      u.mergeGuestCart(ctx, user.ID),
 	}
 
 	ctx.JSON(http.StatusOK, response)
@@ -128,6 +129,7 @@ func (u *UserController) LoginUser(ctx *gin.Context) {
 		CreatedAt:      storedUser.CreatedAt,
 		EmailIsVerfied: storedUser.EmailIsVerfied,
 		MobileNumber:   storedUser.MobileNumber,
+		CartMerge:      u.mergeGuestCart(ctx, storedUser.ID),
 	}
 
 	ctx.JSON(http.StatusOK, response)
@@ -0,0 +1,252 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/repository"
+	"github.com/Emmrys-Jay/ecommerce-api/util"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AddToWishlist adds a product to the logged in user's wishlist
+func (u *UserController) AddToWishlist(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "users")
+
+	productID := ctx.Param("productID")
+	if productID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - product ID"})
+		return
+	}
+
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	_, err = repository.AddToWishlist(collection, userID, productID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "product does not exist"})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"success": "added product to wishlist"})
+}
+
+// RemoveFromWishlist removes a product from the logged in user's wishlist
+func (u *UserController) RemoveFromWishlist(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "users")
+
+	productID := ctx.Param("productID")
+	if productID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - product ID"})
+		return
+	}
+
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	result, err := repository.RemoveFromWishlist(collection, userID, productID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	if result.ModifiedCount == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{"not found": "product is not in your wishlist"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"success": "removed product from wishlist"})
+}
+
+// GetWishlist returns the products in the logged in user's wishlist with their current price and stock
+func (u *UserController) GetWishlist(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "users")
+
+	displayCode, ok := displayCurrency(ctx, ctx.Query("currency"))
+	if !ok {
+		return
+	}
+
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	products, err := repository.GetWishlistProducts(collection, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	if !applyDisplayCurrency(ctx, u.Database, products, displayCode) {
+		return
+	}
+
+	response := entity.PaginationResponse{
+		PageID:        1,
+		ResultsFound:  len(products),
+		NumberOfPages: 1,
+		Data:          products,
+	}
+
+	if len(products) == 0 {
+		response.PageID = 0
+		response.NumberOfPages = 0
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// MoveWishlistItemRequest models the optional body of a move-to-cart request
+type MoveWishlistItemRequest struct {
+	Quantity int64 `json:"quantity"`
+}
+
+// MoveWishlistItemToCart adds a wishlisted product to the cart and removes it from the wishlist
+func (u *UserController) MoveWishlistItemToCart(ctx *gin.Context) {
+	usersCollection := db.GetCollection(u.Database, "users")
+	cartCollection := db.GetCollection(u.Database, "cart")
+	var req MoveWishlistItemRequest
+
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+			return
+		}
+	}
+
+	if req.Quantity == 0 {
+		req.Quantity = 1
+	}
+
+	productID := ctx.Param("productID")
+	if productID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - product ID"})
+		return
+	}
+
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	user, err := repository.GetUser(usersCollection, userID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	if !containsString(user.FavouriteProducts, productID) {
+		ctx.JSON(http.StatusNotFound, gin.H{"not found": "product is not in your wishlist"})
+		return
+	}
+
+	item, err := repository.AddToCart(cartCollection, req.Quantity, productID, userID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "product does not exist"})
+			return
+		}
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	_, err = repository.RemoveFromWishlist(usersCollection, userID, productID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	response := fmt.Sprintf("moved product to cart with id: %s", item.ID)
+	ctx.JSON(http.StatusOK, gin.H{"success": response})
+}
+
+// ShareWishlistRequest models a request to enable or disable the public wishlist link
+type ShareWishlistRequest struct {
+	Public *bool `json:"public" binding:"required"`
+}
+
+// ShareWishlist enables or disables the read-only public link to the logged in user's wishlist
+func (u *UserController) ShareWishlist(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "users")
+	var req ShareWishlistRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	userID, err := util.UserIDFromToken(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "could not get logged in user from token"})
+		return
+	}
+
+	token, err := repository.ShareWishlist(collection, userID, *req.Public)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	if token == "" {
+		ctx.JSON(http.StatusOK, gin.H{"success": "wishlist is no longer shared"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"share_token": token,
+		"path":        fmt.Sprintf("/wishlist/shared/%s", token),
+	})
+}
+
+// GetSharedWishlist returns a publicly shared wishlist. It does not require a logged in user.
+func (u *UserController) GetSharedWishlist(ctx *gin.Context) {
+	collection := db.GetCollection(u.Database, "users")
+
+	token := ctx.Param("token")
+	if token == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid param - token"})
+		return
+	}
+
+	wishlist, err := repository.GetSharedWishlist(collection, token)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.JSON(http.StatusNotFound, gin.H{"not found": "wishlist does not exist or is no longer shared"})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(err))
+		return
+	}
+
+	displayCode, ok := displayCurrency(ctx, ctx.Query("currency"))
+	if !ok || !applyDisplayCurrency(ctx, u.Database, wishlist.Products, displayCode) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, wishlist)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
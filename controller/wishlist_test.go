@@ -0,0 +1,99 @@
+// wishlist_test
+
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/stretchr/testify/require"
+)
+
+func wishlistRequestTest(t *testing.T, details *ServerDB, user entity.UserResponse, method, path string, body []byte) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, path, bytes.NewBuffer(body))
+	req.Header.Add("Authorization", "Bearer "+user.Token)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+
+	return recorder
+}
+
+func TestWishlist(t *testing.T) {
+	details := NewServerDB()
+
+	initializeWishlistRoutes(details)
+	initializeUserRoutes(details)
+
+	user := createUserTest(t, details, "Harry")
+
+	productIDs := []string{}
+	for _, v := range productNames {
+		product := createProduct(t, details, v)
+		productIDs = append(productIDs, product.ID)
+
+		recorder := wishlistRequestTest(t, details, user, "POST", "/user/wishlist/add/"+product.ID, nil)
+		require.Equal(t, 200, recorder.Code)
+	}
+
+	// Adding a product twice keeps a single entry
+	recorder := wishlistRequestTest(t, details, user, "POST", "/user/wishlist/add/"+productIDs[0], nil)
+	require.Equal(t, 200, recorder.Code)
+
+	recorder = wishlistRequestTest(t, details, user, "DELETE", "/user/wishlist/remove/"+productIDs[1], nil)
+	require.Equal(t, 200, recorder.Code)
+
+	recorder = wishlistRequestTest(t, details, user, "GET", "/user/wishlist/getall", nil)
+	require.Equal(t, 200, recorder.Code)
+
+	var result struct {
+		ResultsFound int              `json:"results_found"`
+		Data         []entity.Product `json:"data"`
+	}
+	err := json.Unmarshal(recorder.Body.Bytes(), &result)
+	require.NoError(t, err)
+
+	require.Equal(t, len(productIDs)-1, result.ResultsFound)
+	require.Equal(t, productIDs[0], result.Data[0].ID)
+	for _, v := range result.Data {
+		require.NotEqual(t, productIDs[1], v.ID)
+		require.NotZero(t, v.Price)
+		require.NotZero(t, v.Quantity)
+	}
+
+	// Share the wishlist and read it without logging in
+	public := true
+	sJson, _ := json.Marshal(ShareWishlistRequest{Public: &public})
+	recorder = wishlistRequestTest(t, details, user, "PUT", "/user/wishlist/share", sJson)
+	require.Equal(t, 200, recorder.Code)
+
+	var share struct {
+		ShareToken string `json:"share_token"`
+	}
+	err = json.Unmarshal(recorder.Body.Bytes(), &share)
+	require.NoError(t, err)
+	require.NotZero(t, share.ShareToken)
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/wishlist/shared/%s", share.ShareToken), nil)
+	require.NoError(t, err)
+
+	recorder = httptest.NewRecorder()
+	details.Server.ServeHTTP(recorder, req)
+	require.Equal(t, 200, recorder.Code)
+
+	var shared entity.SharedWishlist
+	err = json.Unmarshal(recorder.Body.Bytes(), &shared)
+	require.NoError(t, err)
+	require.Equal(t, "Thompson Harry", shared.Owner)
+	require.Equal(t, len(productIDs)-1, len(shared.Products))
+
+	deleteRecords(details.Db, "products")
+	deleteRecords(details.Db, "users")
+	dropDatabase(details.Db)
+}
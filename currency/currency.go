@@ -0,0 +1,96 @@
+package currency
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// defaultBase is the store currency used when BASE_CURRENCY is not set
+const defaultBase = "USD"
+
+var (
+	// ErrInvalidCode is returned for currency codes that are not three letter ISO 4217 codes
+	ErrInvalidCode = errors.New("currency must be a three letter ISO 4217 code")
+	// ErrNoRate is returned when the exchange-rate table has no rate for a currency
+	ErrNoRate = errors.New("no exchange rate")
+)
+
+// minorUnits lists currencies that do not use 2 decimal places
+var minorUnits = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// Base returns the store base currency set with the BASE_CURRENCY environment variable
+func Base() string {
+	if code, err := Normalize(os.Getenv("BASE_CURRENCY")); err == nil {
+		return code
+	}
+	return defaultBase
+}
+
+// Normalize upper cases and validates a currency code
+func Normalize(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) != 3 {
+		return "", ErrInvalidCode
+	}
+
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return "", ErrInvalidCode
+		}
+	}
+
+	return code, nil
+}
+
+// MinorUnits returns the number of decimal places used by a currency
+func MinorUnits(code string) int {
+	if units, ok := minorUnits[code]; ok {
+		return units
+	}
+	return 2
+}
+
+// Round rounds an amount half away from zero to the minor unit of a currency
+func Round(amount float64, code string) float64 {
+	scale := math.Pow10(MinorUnits(code))
+	return math.Round(amount*scale) / scale
+}
+
+// Rates maps currency codes to the number of units of that currency worth one unit of the base currency
+type Rates map[string]float64
+
+// Rate returns the factor that converts an amount in one currency to another
+func (r Rates) Rate(from, to string) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+
+	fromRate, ok := r[from]
+	if !ok || fromRate <= 0 {
+		return 0, fmt.Errorf("%w for %s", ErrNoRate, from)
+	}
+
+	toRate, ok := r[to]
+	if !ok || toRate <= 0 {
+		return 0, fmt.Errorf("%w for %s", ErrNoRate, to)
+	}
+
+	return toRate / fromRate, nil
+}
+
+// Convert converts an amount between currencies and rounds it to the minor unit of the target currency
+func (r Rates) Convert(amount float64, from, to string) (float64, error) {
+	rate, err := r.Rate(from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	return Round(amount*rate, to), nil
+}
@@ -61,6 +61,10 @@ func configDBCollections(db *mongo.Database) error {
 				Keys:    bson.D{{Key: "mobile_number", Value: 1}},
 				Options: options.Index().SetName("mobile_number_index").SetUnique(true),
 			},
+			{
+				Keys:    bson.D{{Key: "wishlist_share_token", Value: 1}},
+				Options: options.Index().SetName("wishlist_share_token_index").SetUnique(true).SetSparse(true),
+			},
 		})
 
 	if err != nil {
@@ -69,21 +73,368 @@ func configDBCollections(db *mongo.Database) error {
 
 	collection = GetCollection(db, "products")
 
+	_, err = collection.Indexes().CreateMany(ctx,
+		[]mongo.IndexModel{
+			{
+				Keys:    bson.D{{Key: "name", Value: 1}},
+				Options: options.Index().SetName("name_index").SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "slug", Value: 1}},
+				Options: options.Index().SetName("slug_index").SetUnique(true).SetSparse(true),
+			},
+			{
+				Keys:    bson.D{{Key: "sku", Value: 1}},
+				Options: options.Index().SetName("sku_index").SetUnique(true).SetSparse(true),
+			},
+			{
+				Keys:    bson.D{{Key: "previous_slugs", Value: 1}},
+				Options: options.Index().SetName("previous_slugs_index"),
+			},
+			{
+				Keys:    bson.D{{Key: "status", Value: 1}, {Key: "publish_at", Value: 1}},
+				Options: options.Index().SetName("status_publish_at_index"),
+			},
+			{
+				Keys:    bson.D{{Key: "tags", Value: 1}},
+				Options: options.Index().SetName("tags_index"),
+			},
+			{
+				Keys:    bson.D{{Key: "attributes.$**", Value: 1}},
+				Options: options.Index().SetName("attributes_index"),
+			},
+		})
+
+	if err != nil {
+		return err
+	}
+
+	collection = GetCollection(db, "cart")
+
+	// product_id_index made a product unique across every cart, so only one shopper could cart it
+	if _, err := collection.Indexes().DropOne(ctx, "product_id_index"); err != nil && !isIndexNotFound(err) {
+		return err
+	}
+
 	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys:    bson.D{{Key: "name", Value: 1}},
-		Options: options.Index().SetName("name_index").SetUnique(true),
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "product_id", Value: 1}},
+		Options: options.Index().SetName("user_product_index").SetUnique(true),
 	})
 
 	if err != nil {
 		return err
 	}
 
-	collection = GetCollection(db, "cart")
+	collection = GetCollection(db, "guest_carts")
+
+	_, err = collection.Indexes().CreateMany(ctx,
+		[]mongo.IndexModel{
+			{
+				Keys:    bson.D{{Key: "token", Value: 1}},
+				Options: options.Index().SetName("token_index").SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "last_active", Value: 1}},
+				Options: options.Index().SetName("last_active_index"),
+			},
+		})
+
+	if err != nil {
+		return err
+	}
+
+	collection = GetCollection(db, "reservations")
+
+	_, err = collection.Indexes().CreateMany(ctx,
+		[]mongo.IndexModel{
+			{
+				// Holds are removed by MongoDB once they expire
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetName("expires_at_index").SetExpireAfterSeconds(0),
+			},
+			{
+				Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "product_id", Value: 1}},
+				Options: options.Index().SetName("user_product_index").SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "product_id", Value: 1}, {Key: "expires_at", Value: 1}},
+				Options: options.Index().SetName("product_expires_at_index"),
+			},
+		})
+
+	if err != nil {
+		return err
+	}
+
+	collection = GetCollection(db, "saved_items")
+
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "product_id", Value: 1}},
+		Options: options.Index().SetName("user_product_index").SetUnique(true),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	collection = GetCollection(db, "abandoned_carts")
+
+	_, err = collection.Indexes().CreateMany(ctx,
+		[]mongo.IndexModel{
+			{
+				// A user has one open abandoned cart at a time
+				Keys: bson.D{{Key: "user_id", Value: 1}},
+				Options: options.Index().SetName("open_user_index").SetUnique(true).
+					SetPartialFilterExpression(bson.M{"status": "open"}),
+			},
+			{
+				Keys:    bson.D{{Key: "detected_at", Value: 1}},
+				Options: options.Index().SetName("detected_at_index"),
+			},
+		})
+
+	if err != nil {
+		return err
+	}
+
+	collection = GetCollection(db, "coupons")
+
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}},
+		Options: options.Index().SetName("user_id_index"),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	collection = GetCollection(db, "price_history")
+
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "product_id", Value: 1}, {Key: "changed_at", Value: -1}},
+		Options: options.Index().SetName("product_changed_at_index"),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	collection = GetCollection(db, "exchange_rates")
 
 	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys:    bson.D{{Key: "product_id", Value: 1}},
-		Options: options.Index().SetName("product_id_index").SetUnique(true),
+		Keys:    bson.D{{Key: "currency", Value: 1}, {Key: "effective_from", Value: -1}},
+		Options: options.Index().SetName("currency_effective_from_index"),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	collection = GetCollection(db, "stock_alerts")
+
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "resolved", Value: 1}, {Key: "created_at", Value: -1}},
+		Options: options.Index().SetName("resolved_created_at_index"),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	collection = GetCollection(db, "subscriptions")
+
+	_, err = collection.Indexes().CreateMany(ctx,
+		[]mongo.IndexModel{
+			{
+				Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "product_id", Value: 1}, {Key: "type", Value: 1}},
+				Options: options.Index().SetName("user_product_type_index").SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "product_id", Value: 1}},
+				Options: options.Index().SetName("product_id_index"),
+			},
+		})
+
+	if err != nil {
+		return err
+	}
+
+	collection = GetCollection(db, "notifications")
+
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+		Options: options.Index().SetName("user_created_at_index"),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	collection = GetCollection(db, "questions")
+
+	_, err = collection.Indexes().CreateMany(ctx,
+		[]mongo.IndexModel{
+			{
+				Keys:    bson.D{{Key: "product_id", Value: 1}, {Key: "answer_count", Value: -1}},
+				Options: options.Index().SetName("product_answer_count_index"),
+			},
+			{
+				Keys:    bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}},
+				Options: options.Index().SetName("status_created_at_index"),
+			},
+		})
+
+	if err != nil {
+		return err
+	}
+
+	collection = GetCollection(db, "answers")
+
+	_, err = collection.Indexes().CreateMany(ctx,
+		[]mongo.IndexModel{
+			{
+				Keys:    bson.D{{Key: "question_id", Value: 1}, {Key: "helpful_votes", Value: -1}},
+				Options: options.Index().SetName("question_helpful_votes_index"),
+			},
+			{
+				Keys:    bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}},
+				Options: options.Index().SetName("status_created_at_index"),
+			},
+		})
+
+	if err != nil {
+		return err
+	}
+
+	collection = GetCollection(db, "answer_votes")
+
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "answer_id", Value: 1}, {Key: "user_id", Value: 1}},
+		Options: options.Index().SetName("answer_user_index").SetUnique(true),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	collection = GetCollection(db, "licence_keys")
+
+	_, err = collection.Indexes().CreateMany(ctx,
+		[]mongo.IndexModel{
+			{
+				Keys:    bson.D{{Key: "product_id", Value: 1}, {Key: "key", Value: 1}},
+				Options: options.Index().SetName("product_key_index").SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "product_id", Value: 1}, {Key: "order_id", Value: 1}},
+				Options: options.Index().SetName("product_order_index"),
+			},
+		})
+
+	if err != nil {
+		return err
+	}
+
+	collection = GetCollection(db, "downloads")
+
+	_, err = collection.Indexes().CreateMany(ctx,
+		[]mongo.IndexModel{
+			{
+				Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+				Options: options.Index().SetName("user_created_at_index"),
+			},
+			{
+				Keys:    bson.D{{Key: "order_id", Value: 1}},
+				Options: options.Index().SetName("order_id_index"),
+			},
+		})
+
+	if err != nil {
+		return err
+	}
+
+	collection = GetCollection(db, "location_stock")
+
+	_, err = collection.Indexes().CreateMany(ctx,
+		[]mongo.IndexModel{
+			{
+				Keys:    bson.D{{Key: "product_id", Value: 1}, {Key: "location_id", Value: 1}},
+				Options: options.Index().SetName("product_location_index").SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "location_id", Value: 1}},
+				Options: options.Index().SetName("location_id_index"),
+			},
+		})
+
+	if err != nil {
+		return err
+	}
+
+	collection = GetCollection(db, "stock_ledger")
+
+	_, err = collection.Indexes().CreateMany(ctx,
+		[]mongo.IndexModel{
+			{
+				Keys:    bson.D{{Key: "product_id", Value: 1}, {Key: "created_at", Value: -1}},
+				Options: options.Index().SetName("product_created_at_index"),
+			},
+			{
+				Keys:    bson.D{{Key: "reference_id", Value: 1}},
+				Options: options.Index().SetName("reference_id_index"),
+			},
+		})
+
+	if err != nil {
+		return err
+	}
+
+	collection = GetCollection(db, "reviews")
+
+	_, err = collection.Indexes().CreateMany(ctx,
+		[]mongo.IndexModel{
+			{
+				Keys:    bson.D{{Key: "product_id", Value: 1}, {Key: "user_id", Value: 1}},
+				Options: options.Index().SetName("product_user_index").SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "product_id", Value: 1}, {Key: "created_at", Value: -1}},
+				Options: options.Index().SetName("product_created_at_index"),
+			},
+			{
+				Keys:    bson.D{{Key: "status", Value: 1}, {Key: "report_count", Value: -1}},
+				Options: options.Index().SetName("status_report_count_index"),
+			},
+		})
+
+	if err != nil {
+		return err
+	}
+
+	collection = GetCollection(db, "review_reports")
+
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "review_id", Value: 1}, {Key: "user_id", Value: 1}},
+		Options: options.Index().SetName("review_user_index").SetUnique(true),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	collection = GetCollection(db, "review_votes")
+
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "review_id", Value: 1}, {Key: "user_id", Value: 1}},
+		Options: options.Index().SetName("review_user_index").SetUnique(true),
 	})
 
 	return err
 }
+
+// isIndexNotFound reports whether dropping an index failed because it, or its collection, does not exist
+func isIndexNotFound(err error) bool {
+	cmdErr, ok := err.(mongo.CommandError)
+	// 26 is NamespaceNotFound and 27 is IndexNotFound
+	return ok && (cmdErr.Code == 26 || cmdErr.Code == 27)
+}
@@ -16,22 +16,66 @@ func InitializeAdminEndpoints(db *mongo.Database, e *gin.Engine, mdw gin.Handler
 		admin.GET("/cart/:cart-id", adminController.GetCartItem)
 		admin.GET("/cart/get_all", adminController.GetAllCartItems)
 		admin.DELETE("/cart/:id", adminController.DeleteCartItem)
-		admin.DELETE("/cart/delete_all/:username", adminController.DeleteAllUserCartItems)
+		admin.DELETE("/cart/delete_all/:user-id", adminController.DeleteAllUserCartItems)
 		admin.DELETE("/cart/delete_all", adminController.DeleteAllCartItems)
 
 		admin.GET("/orders/get_all", adminController.GetAllOrders)
 		admin.PATCH("/deliver/:order-id", adminController.DeliverOrder)
+		admin.PATCH("/orders/:id/status", adminController.UpdateOrderStatus)
+		admin.GET("/orders/:id/timeline", adminController.GetOrderTimeline)
 		admin.DELETE("/orders/:id", adminController.DeleteOrder)
 		admin.DELETE("/orders/delete_all/:user-id", adminController.DeleteAllOrdersWithUserID)
 		admin.DELETE("/orders/delete_all", adminController.DeleteAllOrders)
 
 		admin.POST("/products/add_one", adminController.AddOneProduct)
 		admin.POST("/products", adminController.AddProducts)
+		admin.GET("/products", adminController.GetProducts)
+		admin.GET("/products/:id", adminController.GetProduct)
 		admin.DELETE("/products", adminController.DeleteProducts)
 		admin.DELETE("/products/delete_all", adminController.DeleteAllProducts)
 		admin.PATCH("/products/:id", adminController.UpdateProduct)
+		admin.POST("/products/import", adminController.ImportProducts)
+		admin.GET("/products/import/:job-id", adminController.GetImportJob)
+		admin.GET("/products/export", adminController.ExportProducts)
+		admin.POST("/products/:id/sales", adminController.AddProductSale)
+		admin.DELETE("/products/:id/sales/:sale-id", adminController.RemoveProductSale)
+		admin.GET("/products/:id/price_history", adminController.GetPriceHistory)
+		admin.PATCH("/products/:id/identifiers", adminController.UpdateProductIdentifiers)
+		admin.POST("/products/:id/file", adminController.UploadDigitalFile)
+		admin.POST("/products/:id/licence_keys", adminController.AddLicenceKeys)
+		admin.GET("/products/:id/licence_keys", adminController.GetLicenceKeyStock)
+		admin.GET("/products/:id/stock", adminController.GetProductStock)
+		admin.PUT("/products/:id/stock/:location-id", adminController.SetLocationStock)
+		admin.POST("/products/:id/stock/adjustments", adminController.AdjustStock)
+		admin.GET("/products/:id/stock/movements", adminController.GetStockMovements)
 		//products.GET("/categories", getAllCategories)
 
+		admin.POST("/locations", adminController.CreateLocation)
+		admin.GET("/locations", adminController.GetLocations)
+		admin.PUT("/locations/:id", adminController.UpdateLocation)
+		admin.DELETE("/locations/:id", adminController.DeleteLocation)
+
+		admin.PUT("/categories/:category/schema", adminController.SaveCategorySchema)
+		admin.DELETE("/categories/:category/schema", adminController.DeleteCategorySchema)
+
+		admin.POST("/exchange_rates", adminController.AddExchangeRate)
+		admin.GET("/exchange_rates", adminController.GetExchangeRates)
+		admin.DELETE("/exchange_rates/:id", adminController.DeleteExchangeRate)
+
+		admin.GET("/reports/wishlist", adminController.GetWishlistReport)
+		admin.GET("/reports/low_stock", adminController.GetLowStockReport)
+		admin.GET("/reports/stock_reconciliation", adminController.GetStockReconciliationReport)
+		admin.GET("/reports/abandoned_carts", adminController.GetAbandonedCartReport)
+		admin.GET("/stock_alerts", adminController.GetStockAlerts)
+
+		admin.GET("/reviews/moderation", adminController.GetReviewModerationQueue)
+		admin.PATCH("/reviews/:review-id/moderate", adminController.ModerateReview)
+
+		admin.GET("/questions/moderation", adminController.GetQAModerationQueue)
+		admin.POST("/questions/:question-id/answers", adminController.AnswerQuestion)
+		admin.PATCH("/questions/:question-id/moderate", adminController.ModerateQuestion)
+		admin.PATCH("/answers/:answer-id/moderate", adminController.ModerateAnswer)
+
 		admin.GET("/user/:user-id", adminController.GetUser)
 		admin.GET("/user/get_all", adminController.GetAllUsers)
 		admin.PATCH("/user", adminController.UpdateUserFlexible)
@@ -15,5 +15,22 @@ func InitializeCartEndpoints(db *mongo.Database, e *gin.Engine, mdw gin.HandlerF
 		cart.DELETE("/cart/:cart-id", usercontroller.RemoveFromCart)
 		cart.PUT("/cart", usercontroller.UpdateCartQuantity)
 		cart.GET("/cart/get_all", usercontroller.GetUserCartItems)
+		cart.GET("/cart/summary", usercontroller.GetCartSummary)
+		cart.POST("/cart/checkout", usercontroller.StartCheckout)
+		cart.GET("/cart/checkout", usercontroller.GetReservations)
+		cart.DELETE("/cart/checkout", usercontroller.LeaveCheckout)
+		cart.POST("/cart/saved", usercontroller.SaveForLater)
+		cart.POST("/cart/saved/:saved-id/move_to_cart", usercontroller.MoveSavedItemToCart)
+		cart.DELETE("/cart/saved/:saved-id", usercontroller.RemoveSavedItem)
+	}
+
+	// Guest carts are found by their X-Cart-Token header rather than a login
+	guest := e.Group("/guest")
+	{
+		guest.POST("/cart", usercontroller.GuestAddToCart)
+		guest.DELETE("/cart/:cart-id", usercontroller.GuestRemoveFromCart)
+		guest.PUT("/cart", usercontroller.GuestUpdateCartQuantity)
+		guest.GET("/cart/get_all", usercontroller.GetGuestCartItems)
+		guest.GET("/cart/summary", usercontroller.GetGuestCartSummary)
 	}
 }
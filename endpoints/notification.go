@@ -0,0 +1,25 @@
+package endpoints
+
+import (
+	"github.com/Emmrys-Jay/ecommerce-api/controller"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func InitializeNotificationEndpoints(db *mongo.Database, e *gin.Engine, mdw gin.HandlerFunc) {
+	userController := controller.NewUserController(db)
+
+	subscriptions := e.Group("/user/subscriptions", mdw)
+	{
+		subscriptions.GET("", userController.GetSubscriptions)
+		subscriptions.POST("", userController.CreateSubscription)
+		subscriptions.DELETE("/:id", userController.DeleteSubscription)
+	}
+
+	notifications := e.Group("/user/notifications", mdw)
+	{
+		notifications.GET("", userController.GetNotifications)
+		notifications.PATCH("/:id/read", userController.MarkNotificationRead)
+		notifications.PUT("/channels", userController.UpdateNotificationChannels)
+	}
+}
@@ -13,8 +13,12 @@ func InitializeOrdersEndpoints(db *mongo.Database, e *gin.Engine, mdw gin.Handle
 	{
 		orders.POST("/:productID", userController.OrderProduct)
 		orders.GET("/:order-ID", userController.GetOrder)
+		orders.GET("/:order-ID/timeline", userController.GetOrderTimeline)
 		orders.GET("/orders", userController.GetOrdersWithUsername)
 		orders.PATCH("/receive/:order-id", userController.ReceiveOrder)
 		orders.POST("/cart", userController.OrderAllCartItems)
 	}
+
+	e.GET("/user/downloads", mdw, userController.GetDownloads)
+	e.GET("/downloads/:download-id", userController.Download)
 }
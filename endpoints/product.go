@@ -14,9 +14,27 @@ func InitializeProductEndpoints(db *mongo.Database, e *gin.Engine, mdw gin.Handl
 		products.GET("/:category", userController.GetProductsByCategory)
 		products.GET("/find", userController.FindProducts)
 		products.GET("/find_one/:productID", userController.FindOneProduct)
+		products.GET("/slug/:slug", userController.FindProductBySlug)
+		products.GET("/sku/:sku", userController.FindProductBySKU)
 		// products.GET("/find/recent", userController.FindProductsWithTime)
 		// products.GET("/find/reviews", userController.FindProductsBasedOnReviews)
 		products.PATCH("/:productID/add_review", mdw, userController.AddReview)
+		products.GET("/reviews/:productID", userController.GetProductReviews)
+		products.POST("/reviews/:productID", mdw, userController.AddReview)
+		products.PATCH("/reviews/:productID", mdw, userController.UpdateReview)
+		products.DELETE("/reviews/:productID", mdw, userController.DeleteReview)
+		products.POST("/reviews/report/:review-id", mdw, userController.ReportReview)
+		products.POST("/reviews/vote/:review-id", mdw, userController.VoteReview)
+		products.GET("/questions/:productID", userController.GetProductQuestions)
+		products.POST("/questions/:productID", mdw, userController.AskQuestion)
+		products.GET("/answers/:question-id", userController.GetQuestionAnswers)
+		products.POST("/answers/:question-id", mdw, userController.AnswerQuestion)
+		products.POST("/answers/vote/:answer-id", mdw, userController.VoteAnswer)
 		// products.GET("/categories", getAllCategories)
 	}
+
+	categories := e.Group("/categories")
+	{
+		categories.GET("/:category/schema", userController.GetCategorySchema)
+	}
 }
@@ -32,6 +32,8 @@ func SetupRoutes(db *mongo.Database, server *gin.Engine, mdws ...gin.HandlerFunc
 	InitializeUserEndpoints(db, server, userMdw)
 	InitializeProductEndpoints(db, server, userMdw)
 	InitializeOrdersEndpoints(db, server, userMdw)
+	InitializeWishlistEndpoints(db, server, userMdw)
+	InitializeNotificationEndpoints(db, server, userMdw)
 
 	server.NoRoute(func(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{
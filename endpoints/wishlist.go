@@ -0,0 +1,22 @@
+package endpoints
+
+import (
+	"github.com/Emmrys-Jay/ecommerce-api/controller"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func InitializeWishlistEndpoints(db *mongo.Database, e *gin.Engine, mdw gin.HandlerFunc) {
+	userController := controller.NewUserController(db)
+
+	wishlist := e.Group("/user/wishlist", mdw)
+	{
+		wishlist.GET("", userController.GetWishlist)
+		wishlist.POST("/:productID", userController.AddToWishlist)
+		wishlist.DELETE("/:productID", userController.RemoveFromWishlist)
+		wishlist.POST("/:productID/move_to_cart", userController.MoveWishlistItemToCart)
+		wishlist.PATCH("/share", userController.ShareWishlist)
+	}
+
+	e.GET("/wishlist/shared/:token", userController.GetSharedWishlist)
+}
@@ -0,0 +1,46 @@
+package entity
+
+import (
+	"time"
+)
+
+// States of an abandoned cart
+const (
+	// AbandonedCartOpen carts are still sent reminders until they run out
+	AbandonedCartOpen = "open"
+	// AbandonedCartRecovered carts were ordered after a reminder
+	AbandonedCartRecovered = "recovered"
+	// AbandonedCartCleared carts were emptied without being ordered
+	AbandonedCartCleared = "cleared"
+)
+
+// AbandonedCart records a user's cart that was left untouched for longer than ABANDONED_CART_AFTER, the reminders
+// sent about it and what was ordered once the user came back. A user has at most one open abandoned cart.
+type AbandonedCart struct {
+	ID               string    `json:"_id" bson:"_id"`
+	UserID           string    `json:"user_id" bson:"user_id"`
+	Status           string    `json:"status" bson:"status"`
+	Value            float64   `json:"value" bson:"value" description:"grand total of the cart in the base currency when it was found"`
+	LastActivity     time.Time `json:"last_activity" bson:"last_activity" description:"when a line of the cart was last added or changed"`
+	Reminders        int       `json:"reminders" bson:"reminders"`
+	LastReminderAt   time.Time `json:"last_reminder_at,omitempty" bson:"last_reminder_at,omitempty"`
+	CouponCode       string    `json:"coupon_code,omitempty" bson:"coupon_code,omitempty"`
+	RecoveredRevenue float64   `json:"recovered_revenue,omitempty" bson:"recovered_revenue,omitempty" description:"in the base currency"`
+	DetectedAt       time.Time `json:"detected_at" bson:"detected_at"`
+	ClosedAt         time.Time `json:"closed_at,omitempty" bson:"closed_at,omitempty"`
+}
+
+// AbandonmentReport sums up the carts abandoned since a point in time. Carts that were ordered without being
+// abandoned count as converted; a cart is lost when it was abandoned and not recovered.
+type AbandonmentReport struct {
+	Since            time.Time `json:"since"`
+	Currency         string    `json:"currency"`
+	Converted        int64     `json:"converted" description:"users who ordered"`
+	Abandoned        int64     `json:"abandoned"`
+	Recovered        int64     `json:"recovered"`
+	AbandonmentRate  float64   `json:"abandonment_rate" description:"lost carts as a share of converted and lost carts"`
+	RecoveryRate     float64   `json:"recovery_rate" description:"recovered carts as a share of abandoned carts"`
+	AbandonedValue   float64   `json:"abandoned_value"`
+	RecoveredRevenue float64   `json:"recovered_revenue"`
+	RemindersSent    int64     `json:"reminders_sent"`
+}
@@ -0,0 +1,31 @@
+package entity
+
+import (
+	"time"
+)
+
+// Attribute types a category schema can declare
+const (
+	AttributeText    = "text"
+	AttributeNumber  = "number"
+	AttributeBoolean = "boolean"
+	AttributeEnum    = "enum"
+)
+
+// AttributeDefinition describes one structured spec of the products in a category, e.g. a "screen_size" number in inches
+type AttributeDefinition struct {
+	Key      string   `json:"key" bson:"key" binding:"required"`
+	Label    string   `json:"label,omitempty" bson:"label"`
+	Type     string   `json:"type" bson:"type" binding:"required,oneof=text number boolean enum"`
+	Unit     string   `json:"unit,omitempty" bson:"unit" description:"unit number values are stored in, e.g. inches or kg"`
+	Required bool     `json:"required,omitempty" bson:"required"`
+	Options  []string `json:"options,omitempty" bson:"options" description:"allowed values of an enum attribute"`
+}
+
+// CategorySchema lists the attributes products in a category may have. Products in a category
+// without a schema can have any text, number or boolean attributes.
+type CategorySchema struct {
+	Category   string                `json:"category" bson:"_id"`
+	Attributes []AttributeDefinition `json:"attributes" bson:"attributes" binding:"dive"`
+	UpdatedAt  time.Time             `json:"updated_at" bson:"updated_at"`
+}
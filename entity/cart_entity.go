@@ -10,5 +10,75 @@ type CartItem struct {
 	UserID    string    `json:"user_id" bson:"user_id"`
 	Quantity  int64     `json:"quantity" bson:"quantity"`
 	DateAdded time.Time `json:"date_added" bson:"date_added"`
-	Product   Product   `json:"product" bson:"product"`
+	// LastUpdated is when the line was last added to or changed, missing on lines older than the field
+	LastUpdated time.Time `json:"last_updated,omitempty" bson:"last_updated,omitempty"`
+	Product     Product   `json:"product" bson:"product"`
+	UnitPrice   float64   `json:"unit_price,omitempty" bson:"unit_price,omitempty" description:"effective price of the product, in its own currency, when it was last added"`
+}
+
+// SavedItem is a product a user moved out of their cart to buy later. It is never checked out or held.
+type SavedItem struct {
+	ID        string    `json:"_id" bson:"_id"`
+	ProductID string    `json:"product_id" bson:"product_id"`
+	UserID    string    `json:"user_id" bson:"user_id"`
+	Quantity  int64     `json:"quantity" bson:"quantity"`
+	SavedAt   time.Time `json:"saved_at" bson:"saved_at"`
+	Product   *Product  `json:"product,omitempty" bson:"-" description:"current product data, missing when the product was deleted"`
+}
+
+// GuestCart is the cart of a shopper who has not signed in, found by its opaque token.
+// Its lines are cart items owned by OwnerID.
+type GuestCart struct {
+	ID         string    `json:"_id" bson:"_id"`
+	Token      string    `json:"-" bson:"token"`
+	CreatedAt  time.Time `json:"created_at" bson:"created_at"`
+	LastActive time.Time `json:"last_active" bson:"last_active"`
+}
+
+// OwnerID is the user ID the lines of a guest cart are stored under
+func (c GuestCart) OwnerID() string {
+	return "guest_" + c.ID
+}
+
+// CartMerge reports how a guest cart was merged into a user's cart
+type CartMerge struct {
+	Merged  int      `json:"merged"`
+	Skipped []string `json:"skipped,omitempty" description:"IDs of products that could not be merged, e.g. because they sold out"`
+}
+
+// Problems with a cart line found when the cart is priced. All but a price change stop the cart being checked out.
+const (
+	CartIssuePriceChanged      = "price_changed"
+	CartIssueOutOfStock        = "out_of_stock"
+	CartIssueInsufficientStock = "insufficient_stock"
+	CartIssueBelowMinimum      = "below_minimum_order"
+	CartIssueAboveMaximum      = "above_maximum_order"
+	CartIssueNotForSale        = "not_for_sale"
+	CartIssueUnavailable       = "unavailable"
+)
+
+// CartLine is a cart item priced with the current product data
+type CartLine struct {
+	CartItemID     string   `json:"cart_item_id"`
+	ProductID      string   `json:"product_id"`
+	Name           string   `json:"name"`
+	Quantity       int64    `json:"quantity"`
+	UnitPrice      float64  `json:"unit_price" description:"list price"`
+	EffectivePrice float64  `json:"effective_price" description:"price after any sale"`
+	PreviousPrice  float64  `json:"previous_price,omitempty" description:"effective price when the product was added, set when it changed"`
+	Discount       float64  `json:"discount"`
+	LineTotal      float64  `json:"line_total"`
+	Issues         []string `json:"issues,omitempty"`
+}
+
+// CartSummary is the priced contents of a cart. Lines with issues other than a price change are left out of the totals.
+type CartSummary struct {
+	Currency    string     `json:"currency"`
+	Lines       []CartLine `json:"lines"`
+	Subtotal    float64    `json:"subtotal" description:"list price of the lines"`
+	Discount    float64    `json:"discount"`
+	Shipping    float64    `json:"shipping" description:"estimated"`
+	Tax         float64    `json:"tax"`
+	GrandTotal  float64    `json:"grand_total"`
+	CanCheckout bool       `json:"can_checkout"`
 }
@@ -0,0 +1,16 @@
+package entity
+
+import (
+	"time"
+)
+
+// Coupon takes a percentage off one cart order of the user it was issued to. It can be used once, before it expires.
+type Coupon struct {
+	Code      string    `json:"code" bson:"_id"`
+	UserID    string    `json:"user_id" bson:"user_id"`
+	Percent   float64   `json:"percent" bson:"percent"`
+	Reason    string    `json:"reason,omitempty" bson:"reason,omitempty" description:"why it was issued, e.g. abandoned_cart"`
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+	UsedAt    time.Time `json:"used_at,omitempty" bson:"used_at,omitempty"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
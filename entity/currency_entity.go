@@ -0,0 +1,15 @@
+package entity
+
+import (
+	"time"
+)
+
+// ExchangeRate is an entry of the admin managed exchange-rate table.
+// Rate is the number of units of Currency worth one unit of the store base currency.
+type ExchangeRate struct {
+	ID            string    `json:"_id" bson:"_id"`
+	Currency      string    `json:"currency" bson:"currency" binding:"required"`
+	Rate          float64   `json:"rate" bson:"rate" binding:"required,gt=0"`
+	EffectiveFrom time.Time `json:"effective_from" bson:"effective_from"`
+	CreatedAt     time.Time `json:"created_at" bson:"created_at"`
+}
@@ -0,0 +1,54 @@
+package entity
+
+import (
+	"time"
+)
+
+// Ways a digital product is delivered
+const (
+	DeliveryFile       = "file"
+	DeliveryLicenceKey = "licence_key"
+)
+
+// DigitalContent describes how a digital product is delivered. A file product is downloaded through signed links,
+// a licence key product hands out one key from its pool per unit ordered.
+type DigitalContent struct {
+	Delivery        string `json:"delivery" bson:"delivery" binding:"required,oneof=file licence_key"`
+	FileName        string `json:"file_name,omitempty" bson:"file_name,omitempty" description:"name of the uploaded file, set when the file is uploaded"`
+	DownloadLimit   int    `json:"download_limit,omitempty" bson:"download_limit,omitempty" binding:"min=0" description:"downloads allowed per order, the store default when 0"`
+	LinkExpiryHours int    `json:"link_expiry_hours,omitempty" bson:"link_expiry_hours,omitempty" binding:"min=0" description:"hours a download link stays valid, the store default when 0"`
+}
+
+// LicenceKey is a key in the pool of a licence key product. It is unassigned until it is ordered.
+type LicenceKey struct {
+	ID         string    `json:"_id" bson:"_id"`
+	ProductID  string    `json:"product_id" bson:"product_id"`
+	Key        string    `json:"key" bson:"key"`
+	OrderID    string    `json:"order_id,omitempty" bson:"order_id"`
+	UserID     string    `json:"user_id,omitempty" bson:"user_id"`
+	CreatedAt  time.Time `json:"created_at" bson:"created_at"`
+	AssignedAt time.Time `json:"assigned_at,omitempty" bson:"assigned_at,omitempty"`
+}
+
+// LicenceKeyStock models the number of free and assigned keys in a product's pool
+type LicenceKeyStock struct {
+	ProductID string `json:"product_id"`
+	Available int64  `json:"available"`
+	Assigned  int64  `json:"assigned"`
+}
+
+// Download grants the buyer of a file product a limited number of downloads until it expires
+type Download struct {
+	ID        string    `json:"_id" bson:"_id"`
+	OrderID   string    `json:"order_id" bson:"order_id"`
+	UserID    string    `json:"user_id" bson:"user_id"`
+	ProductID string    `json:"product_id" bson:"product_id"`
+	FileName  string    `json:"file_name" bson:"file_name"`
+	Limit     int       `json:"limit" bson:"limit"`
+	Count     int       `json:"count" bson:"count"`
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+
+	// Signed link to the file, computed when the download is read
+	URL string `json:"url,omitempty" bson:"-"`
+}
@@ -0,0 +1,36 @@
+package entity
+
+import (
+	"time"
+)
+
+// Import job statuses
+const (
+	ImportStatusPending   = "pending"
+	ImportStatusRunning   = "running"
+	ImportStatusCompleted = "completed"
+	ImportStatusFailed    = "failed"
+)
+
+// ImportJob models a background bulk product import and its per-row report
+type ImportJob struct {
+	ID         string           `json:"_id" bson:"_id"`
+	Format     string           `json:"format" bson:"format" description:"csv or ndjson"`
+	Status     string           `json:"status" bson:"status"`
+	TotalRows  int              `json:"total_rows" bson:"total_rows"`
+	Inserted   int              `json:"inserted" bson:"inserted"`
+	Updated    int              `json:"updated" bson:"updated"`
+	Failed     int              `json:"failed" bson:"failed"`
+	Errors     []ImportRowError `json:"errors" bson:"errors"`
+	CreatedBy  string           `json:"created_by,omitempty" bson:"created_by,omitempty"`
+	CreatedAt  time.Time        `json:"created_at" bson:"created_at"`
+	FinishedAt time.Time        `json:"finished_at,omitempty" bson:"finished_at"`
+}
+
+// ImportRowError records why a single row of an import was rejected
+type ImportRowError struct {
+	Row   int    `json:"row" bson:"row"`
+	Name  string `json:"name,omitempty" bson:"name"`
+	SKU   string `json:"sku,omitempty" bson:"sku"`
+	Error string `json:"error" bson:"error"`
+}
@@ -0,0 +1,37 @@
+package entity
+
+import (
+	"time"
+)
+
+// Reasons for a stock movement
+const (
+	StockSale       = "sale"
+	StockRestock    = "restock"
+	StockReturn     = "return"
+	StockAdjustment = "adjustment"
+	StockDamage     = "damage"
+)
+
+// StockMovement is an entry of the stock ledger. Entries are only ever added, so the deltas of a product
+// sum to its stock.
+type StockMovement struct {
+	ID          string    `json:"_id" bson:"_id"`
+	ProductID   string    `json:"product_id" bson:"product_id"`
+	LocationID  string    `json:"location_id,omitempty" bson:"location_id,omitempty"`
+	Delta       int64     `json:"delta" bson:"delta"`
+	Reason      string    `json:"reason" bson:"reason" description:"sale, restock, return, adjustment or damage"`
+	Actor       string    `json:"actor,omitempty" bson:"actor,omitempty" description:"ID of the user or admin who made the change"`
+	ReferenceID string    `json:"reference_id,omitempty" bson:"reference_id,omitempty" description:"order, import job or delivery the change belongs to"`
+	Note        string    `json:"note,omitempty" bson:"note,omitempty"`
+	CreatedAt   time.Time `json:"created_at" bson:"created_at"`
+}
+
+// StockReconciliation compares the stock of a product with the sum of its ledger entries
+type StockReconciliation struct {
+	ProductID   string `json:"product_id" bson:"_id"`
+	Name        string `json:"name" bson:"name"`
+	Quantity    int64  `json:"quantity" bson:"quantity"`
+	LedgerTotal int64  `json:"ledger_total" bson:"ledger_total"`
+	Difference  int64  `json:"difference" bson:"difference" description:"quantity minus ledger total"`
+}
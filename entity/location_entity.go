@@ -0,0 +1,42 @@
+package entity
+
+import (
+	"time"
+)
+
+// StockLocation is a warehouse that holds stock and ships orders
+type StockLocation struct {
+	ID         string    `json:"_id" bson:"_id"`
+	Name       string    `json:"name" bson:"name" binding:"required"`
+	Country    string    `json:"country" bson:"country" binding:"required"`
+	State      string    `json:"state,omitempty" bson:"state"`
+	CityOrTown string    `json:"city_or_town,omitempty" bson:"city_or_town"`
+	Priority   int       `json:"priority" bson:"priority" description:"breaks ties between equally near locations, higher first"`
+	CreatedAt  time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// LocationStock is the stock of a product held at one location
+type LocationStock struct {
+	ID           string    `json:"_id" bson:"_id"`
+	ProductID    string    `json:"product_id" bson:"product_id"`
+	LocationID   string    `json:"location_id" bson:"location_id"`
+	LocationName string    `json:"location_name,omitempty" bson:"-"`
+	Quantity     int64     `json:"quantity" bson:"quantity"`
+	UpdatedAt    time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// ProductStock models a product's stock broken down by location
+type ProductStock struct {
+	ProductID string          `json:"product_id"`
+	Total     int64           `json:"total"`
+	Locations []LocationStock `json:"locations"`
+}
+
+// StockAllocation records how much of a product an order took from a location.
+// Stock of products that are not kept by location has no location ID.
+type StockAllocation struct {
+	ProductID  string `json:"product_id" bson:"product_id"`
+	LocationID string `json:"location_id,omitempty" bson:"location_id,omitempty"`
+	Quantity   int64  `json:"quantity" bson:"quantity"`
+}
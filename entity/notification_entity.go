@@ -0,0 +1,33 @@
+package entity
+
+import (
+	"time"
+)
+
+// Subscription types
+const (
+	SubscriptionBackInStock = "back_in_stock"
+	SubscriptionPriceDrop   = "price_drop"
+)
+
+// Subscription asks for a one-off notification when a product is back in stock or its price drops to TargetPrice.
+// It is deleted once the user has been notified.
+type Subscription struct {
+	ID          string    `json:"_id" bson:"_id"`
+	UserID      string    `json:"user_id" bson:"user_id"`
+	ProductID   string    `json:"product_id" bson:"product_id"`
+	Type        string    `json:"type" bson:"type"`
+	TargetPrice float64   `json:"target_price,omitempty" bson:"target_price" description:"notify when the effective price is at or below this, in the product currency"`
+	CreatedAt   time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Notification is a message shown to a user inside the store
+type Notification struct {
+	ID        string    `json:"_id" bson:"_id"`
+	UserID    string    `json:"user_id" bson:"user_id"`
+	Subject   string    `json:"subject" bson:"subject"`
+	Body      string    `json:"body" bson:"body"`
+	Link      string    `json:"link,omitempty" bson:"link"`
+	Read      bool      `json:"read" bson:"read"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
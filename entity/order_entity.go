@@ -4,16 +4,85 @@ import (
 	"time"
 )
 
+// Statuses of an order
+const (
+	OrderPendingPayment = "pending_payment"
+	OrderPaid           = "paid"
+	OrderProcessing     = "processing"
+	OrderShipped        = "shipped"
+	OrderDelivered      = "delivered"
+	OrderCompleted      = "completed"
+	OrderCancelled      = "cancelled"
+	OrderRefunded       = "refunded"
+)
+
+// OrderActorSystem is the actor of status changes the store makes on its own
+const OrderActorSystem = "system"
+
+// Order is one checkout of one or more products. Its totals follow the cart summary: the subtotal is the list
+// price of the lines, the discount covers sales and coupons, and the grand total adds shipping and tax.
+// Amounts are in the order currency.
 type Order struct {
-	ID               string    `json:"_id" bson:"_id"`
-	UserID           string    `json:"user_id,omitempty" bson:"user_id" binding:"required" description:"user who placed the order"`
-	FullName         string    `json:"fullname,omitempty" bson:"fullname" binding:"required" description:"fullname specified during checkout"`
-	DeliveryLocation Location  `json:"delivery_address,omitempty" bson:"delivery_address" description:"location specified during checkout"`
-	DeliveryFee      float64   `json:"delivery_fee,omitempty" bson:"delivery_fee" binding:"required"`
-	Product          Product   `json:"product,omitempty" bson:"product" binding:"required"`
-	ProductQuantity  int       `json:"product_quantity,omitempty" bson:"product_quantity" binding:"required"`
-	IsDelivered      bool      `json:"is_delivered,omitempty" bson:"is_delivered"  binding:"required"`
-	CreatedAt        time.Time `json:"created_at,omitempty" bson:"created_at"`
-	TimeDelivered    time.Time `json:"time_delivered,omitempty" bson:"time_delivered"`
-	IsReceived       bool      `json:"is_received,omitempty" bson:"is_received"`
+	ID               string      `json:"_id" bson:"_id"`
+	UserID           string      `json:"user_id,omitempty" bson:"user_id" binding:"required" description:"user who placed the order"`
+	FullName         string      `json:"fullname,omitempty" bson:"fullname" binding:"required" description:"fullname specified during checkout"`
+	DeliveryLocation Location    `json:"delivery_address,omitempty" bson:"delivery_address" description:"location specified during checkout"`
+	Lines            []OrderLine `json:"lines" bson:"lines"`
+	Currency         string      `json:"currency,omitempty" bson:"currency"`
+	PaymentMethod    string      `json:"payment_method,omitempty" bson:"payment_method,omitempty"`
+	Subtotal         float64     `json:"subtotal" bson:"subtotal"`
+	Shipping         float64     `json:"shipping" bson:"shipping"`
+	Tax              float64     `json:"tax" bson:"tax"`
+	Discount         float64     `json:"discount" bson:"discount"`
+	GrandTotal       float64     `json:"grand_total" bson:"grand_total"`
+	CouponCode       string      `json:"coupon_code,omitempty" bson:"coupon_code,omitempty"`
+	Status           string      `json:"status" bson:"status" description:"pending_payment, paid, processing, shipped, delivered, completed, cancelled or refunded"`
+	CreatedAt        time.Time   `json:"created_at,omitempty" bson:"created_at"`
+
+	// Every change of status, oldest first
+	History []OrderTransition `json:"history,omitempty" bson:"history"`
+
+	// Set while the stock of an order cancelled or refunded before shipping is being put back. Restocked counts
+	// the allocations already returned, so a retry carries on where the last attempt stopped.
+	RestockPending bool `json:"restock_pending,omitempty" bson:"restock_pending,omitempty"`
+	Restocked      int  `json:"-" bson:"restocked,omitempty"`
+
+	// Where the stock of the order was taken from
+	Allocations []StockAllocation `json:"allocations,omitempty" bson:"allocations,omitempty"`
+}
+
+// OrderTransition is a change of the status of an order
+type OrderTransition struct {
+	From  string    `json:"from,omitempty" bson:"from,omitempty"`
+	To    string    `json:"to" bson:"to"`
+	Actor string    `json:"actor" bson:"actor" description:"ID of the user or admin who made the change, or system"`
+	Note  string    `json:"note,omitempty" bson:"note,omitempty"`
+	At    time.Time `json:"at" bson:"at"`
+}
+
+// OrderLine is one product of an order, with the product as it was when the order was placed
+type OrderLine struct {
+	ProductID    string  `json:"product_id" bson:"product_id"`
+	Product      Product `json:"product" bson:"product"`
+	Variant      string  `json:"variant,omitempty" bson:"variant,omitempty" description:"SKU of the product ordered"`
+	Quantity     int64   `json:"quantity" bson:"quantity"`
+	UnitPrice    float64 `json:"unit_price" bson:"unit_price" description:"list price of the product in the order currency when the order was placed"`
+	Discount     float64 `json:"discount" bson:"discount" description:"sale and coupon discount on the whole line"`
+	LineTotal    float64 `json:"line_total" bson:"line_total"`
+	ExchangeRate float64 `json:"exchange_rate,omitempty" bson:"exchange_rate" description:"factor used to convert the product price to the order currency"`
+
+	// Digital lines are delivered when paid for, with licence keys from the product's pool or download links
+	LicenceKeys []string   `json:"licence_keys,omitempty" bson:"licence_keys,omitempty"`
+	Downloads   []Download `json:"downloads,omitempty" bson:"-"`
+}
+
+// Shipped reports whether any line of the order has to be shipped. Orders of digital products only are
+// delivered when they are placed.
+func (o Order) Shipped() bool {
+	for _, l := range o.Lines {
+		if !l.Product.IsDigital() {
+			return true
+		}
+	}
+	return false
 }
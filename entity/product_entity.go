@@ -4,35 +4,168 @@ import (
 	"time"
 )
 
+// Product types
+const (
+	ProductTypeSimple  = "simple"
+	ProductTypeBundle  = "bundle"
+	ProductTypeDigital = "digital"
+)
+
+// Product statuses. The storefront shows published products, and scheduled ones once their publish time has passed.
+// Products without a status were created before statuses existed and count as published.
+const (
+	ProductDraft     = "draft"
+	ProductScheduled = "scheduled"
+	ProductPublished = "published"
+	ProductArchived  = "archived"
+)
+
+// ProductStatuses lists every product status
+var ProductStatuses = []string{ProductDraft, ProductScheduled, ProductPublished, ProductArchived}
+
 type Product struct {
 	ID          string    `json:"_id" bson:"_id"`
+	Type        string    `json:"type,omitempty" bson:"type,omitempty" binding:"omitempty,oneof=simple bundle digital" description:"simple when empty"`
+	Status      string    `json:"status,omitempty" bson:"status,omitempty" binding:"omitempty,oneof=draft scheduled published archived" description:"published when empty"`
+	PublishAt   time.Time `json:"publish_at,omitempty" bson:"publish_at,omitempty" binding:"required_if=Status scheduled" description:"when a scheduled product goes live"`
 	Name        string    `json:"name,omitempty" bson:"name" binding:"required"`
-	Price       float64   `json:"price,omitempty" bson:"price" binding:"required"`
+	SKU         string    `json:"sku,omitempty" bson:"sku,omitempty"`
+	Slug        string    `json:"slug,omitempty" bson:"slug,omitempty"`
+	Price       float64   `json:"price,omitempty" bson:"price" binding:"required_unless=Type bundle"`
 	Pictures    []string  `json:"pictures" bson:"pictures"`
 	Videos      []string  `json:"videos" bson:"videos"`
-	Currency    string    `json:"currency,omitempty" bson:"currency"`
-	Quantity    int64     `json:"quantity,omitempty" bson:"quantity" binding:"required"`
+	Currency    string    `json:"currency,omitempty" bson:"currency" description:"currency of price, the store base currency when empty"`
+	Quantity    int64     `json:"quantity,omitempty" bson:"quantity" binding:"required_unless=Type bundle Type digital"`
 	Description string    `json:"description,omitempty" bson:"description" binding:"required"`
 	Category    string    `json:"category,omitempty" bson:"category" binding:"required"`
 	Features    []Feature `json:"features,omitempty" bson:"features"`
-	Reviews     []Review  `json:"reviews,omitempty" bson:"reviews"`
 	NoOfReviews int64     `json:"no_of_reviews,omitempty" bson:"no_of_reviews"`
 	CreatedAt   time.Time `json:"created_at,omitempty" bson:"created_at"`
 	LastUpdated time.Time `json:"last_updated,omitempty" bson:"last_updated"`
 	NumOfOrders int64     `json:"num_of_orders,omitempty"`
 
+	// Structured specs keyed by attribute, checked against the category schema, and free form search tags
+	Attributes map[string]interface{} `json:"attributes,omitempty" bson:"attributes,omitempty"`
+	Tags       []string               `json:"tags,omitempty" bson:"tags,omitempty"`
+
+	// A bundle sells its components together. Its price is the sum of the component prices less
+	// BundleDiscount percent, and its stock is how many complete bundles the component stock makes up.
+	Components     []BundleComponent `json:"components,omitempty" bson:"components,omitempty" binding:"required_if=Type bundle,dive"`
+	BundleDiscount float64           `json:"bundle_discount,omitempty" bson:"bundle_discount,omitempty" binding:"min=0,max=100"`
+
+	// How a digital product is delivered once it is paid for. Digital products are never shipped.
+	Digital *DigitalContent `json:"digital,omitempty" bson:"digital,omitempty" binding:"required_if=Type digital"`
+
+	// Most answered questions, only filled in when asked for
+	TopQuestions []Question `json:"top_questions,omitempty" bson:"-"`
+
+	// Slugs the product was known by before it was renamed, kept so old links redirect to it
+	PreviousSlugs []string `json:"previous_slugs,omitempty" bson:"previous_slugs,omitempty"`
+
+	// Ratings are derived from the reviews collection and kept up to date on every review change
+	AverageRating   float64         `json:"average_rating,omitempty" bson:"average_rating"`
+	RatingHistogram RatingHistogram `json:"rating_histogram" bson:"rating_histogram"`
+
+	// Explicit prices in other currencies keyed by ISO 4217 code. They take precedence over exchange rates.
+	Prices map[string]float64 `json:"prices,omitempty" bson:"prices"`
+
+	// Scheduled sales. Past sales are kept for 30 days so the lowest recent price can be shown.
+	Sales []Sale `json:"sales,omitempty" bson:"sales"`
+
+	// Computed at read time from Price, Sales and the price history, never stored
+	EffectivePrice    float64  `json:"effective_price" bson:"-"`
+	OnSale            bool     `json:"on_sale" bson:"-"`
+	LowestPrice30Days float64  `json:"lowest_price_30_days,omitempty" bson:"-"`
+	Display           *Display `json:"display,omitempty" bson:"-" description:"prices converted to the requested display currency"`
+
 	// Optional
-	SlashedPrice float64 `json:"slashed_price,omitempty" bson:"slashed_price"`
-	MinimumOrder int64   `json:"minimum_order,omitempty"`
+	SlashedPrice      float64 `json:"slashed_price,omitempty" bson:"slashed_price"`
+	MinimumOrder      int64   `json:"minimum_order,omitempty" bson:"minimum_order"`
+	MaximumPerOrder   int64   `json:"maximum_per_order,omitempty" bson:"maximum_per_order" description:"largest quantity allowed in one order, unlimited when 0"`
+	LowStockThreshold int64   `json:"low_stock_threshold,omitempty" bson:"low_stock_threshold" description:"stock level that raises a low stock alert, the store default when 0"`
+	FlashSale         bool    `json:"flash_sale,omitempty" bson:"flash_sale,omitempty" description:"hold stock for shoppers as soon as they add the product to their cart"`
 }
 
-type Feature struct {
-	F string `json:"feature" bson:"feature"`
+// BundleComponent is a product and the quantity of it in one bundle
+type BundleComponent struct {
+	ProductID string `json:"product_id" bson:"product_id" binding:"required"`
+	Quantity  int64  `json:"quantity" bson:"quantity" binding:"required,min=1"`
+	Name      string `json:"name,omitempty" bson:"-"`
+}
+
+// IsLive reports whether a product is shown and sold in the storefront at the given time.
+// Drafts and archived products stay readable for admins and for the orders and carts that refer to them.
+func (p *Product) IsLive(at time.Time) bool {
+	switch p.Status {
+	case "", ProductPublished:
+		return true
+	case ProductScheduled:
+		return !p.PublishAt.After(at)
+	default:
+		return false
+	}
 }
 
-type Review struct {
-	User      string    `json:"user"`
-	Stars     int64     `json:"stars" binding:"required,min=1,max=5"`
-	Comment   string    `json:"comment,omitempty"`
-	CreatedAt time.Time `json:"created_at"`
+// IsBundle reports whether a product is a bundle of other products
+func (p *Product) IsBundle() bool {
+	return p.Type == ProductTypeBundle
+}
+
+// IsDigital reports whether a product is delivered digitally rather than shipped
+func (p *Product) IsDigital() bool {
+	return p.Type == ProductTypeDigital
+}
+
+// UnlimitedStock reports whether a product never runs out, as is the case for downloadable files
+func (p *Product) UnlimitedStock() bool {
+	return p.IsDigital() && p.Digital != nil && p.Digital.Delivery == DeliveryFile
+}
+
+// Display models a product's prices converted to a display currency
+type Display struct {
+	Currency          string  `json:"currency"`
+	Rate              float64 `json:"rate"`
+	Price             float64 `json:"price"`
+	EffectivePrice    float64 `json:"effective_price"`
+	LowestPrice30Days float64 `json:"lowest_price_30_days,omitempty"`
+}
+
+// Sale is a scheduled window during which a product sells at a reduced price
+type Sale struct {
+	ID        string    `json:"_id" bson:"_id"`
+	Price     float64   `json:"price" bson:"price" binding:"required,gt=0"`
+	StartsAt  time.Time `json:"starts_at" bson:"starts_at" binding:"required"`
+	EndsAt    time.Time `json:"ends_at" bson:"ends_at" binding:"required"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// PriceChange records a change of a product's base price in the price history
+type PriceChange struct {
+	ID        string    `json:"_id" bson:"_id"`
+	ProductID string    `json:"product_id" bson:"product_id"`
+	OldPrice  float64   `json:"old_price" bson:"old_price"`
+	NewPrice  float64   `json:"new_price" bson:"new_price"`
+	ChangedAt time.Time `json:"changed_at" bson:"changed_at"`
+}
+
+// ActiveSale returns the sale running at the given time, or nil if there is none
+func (p *Product) ActiveSale(at time.Time) *Sale {
+	for i, sale := range p.Sales {
+		if !at.Before(sale.StartsAt) && at.Before(sale.EndsAt) {
+			return &p.Sales[i]
+		}
+	}
+	return nil
+}
+
+// PriceAt returns the price a product sells for at the given time, taking running sales into account
+func (p *Product) PriceAt(at time.Time) float64 {
+	if sale := p.ActiveSale(at); sale != nil && sale.Price < p.Price {
+		return sale.Price
+	}
+	return p.Price
+}
+
+type Feature struct {
+	F string `json:"feature" bson:"feature"`
 }
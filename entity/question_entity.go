@@ -0,0 +1,62 @@
+package entity
+
+import (
+	"time"
+)
+
+// Question is a shopper's question about a product. Admins and shoppers who have received the product can answer it.
+type Question struct {
+	ID          string    `json:"_id" bson:"_id"`
+	ProductID   string    `json:"product_id" bson:"product_id"`
+	UserID      string    `json:"user_id" bson:"user_id"`
+	User        string    `json:"user" bson:"user" description:"username of the asker, taken from the token"`
+	Body        string    `json:"body" bson:"body"`
+	AnswerCount int64     `json:"answer_count" bson:"answer_count" description:"number of approved answers"`
+	CreatedAt   time.Time `json:"created_at" bson:"created_at"`
+
+	// Moderation
+	Status         string    `json:"status" bson:"status" description:"pending, approved or rejected"`
+	Flags          []string  `json:"flags,omitempty" bson:"flags"`
+	ModerationNote string    `json:"moderation_note,omitempty" bson:"moderation_note"`
+	ModeratedAt    time.Time `json:"moderated_at,omitempty" bson:"moderated_at"`
+
+	// Most helpful approved answers, filled in when questions are listed
+	Answers []Answer `json:"answers,omitempty" bson:"-"`
+}
+
+// Answer is a reply to a product question
+type Answer struct {
+	ID            string    `json:"_id" bson:"_id"`
+	QuestionID    string    `json:"question_id" bson:"question_id"`
+	ProductID     string    `json:"product_id" bson:"product_id"`
+	UserID        string    `json:"user_id" bson:"user_id"`
+	User          string    `json:"user" bson:"user"`
+	Body          string    `json:"body" bson:"body"`
+	ByAdmin       bool      `json:"by_admin" bson:"by_admin" description:"answered by the store"`
+	VerifiedBuyer bool      `json:"verified_buyer" bson:"verified_buyer" description:"answerer has received an order of this product"`
+	CreatedAt     time.Time `json:"created_at" bson:"created_at"`
+
+	// Moderation
+	Status         string    `json:"status" bson:"status" description:"pending, approved or rejected"`
+	Flags          []string  `json:"flags,omitempty" bson:"flags"`
+	ModerationNote string    `json:"moderation_note,omitempty" bson:"moderation_note"`
+	ModeratedAt    time.Time `json:"moderated_at,omitempty" bson:"moderated_at"`
+
+	// Helpfulness
+	HelpfulVotes   int64 `json:"helpful_votes" bson:"helpful_votes"`
+	UnhelpfulVotes int64 `json:"unhelpful_votes" bson:"unhelpful_votes"`
+}
+
+// AnswerVote is a shopper's helpfulness vote on an answer. A shopper has one vote per answer.
+type AnswerVote struct {
+	ID        string    `json:"_id" bson:"_id"`
+	AnswerID  string    `json:"answer_id" bson:"answer_id"`
+	UserID    string    `json:"user_id" bson:"user_id"`
+	Helpful   bool      `json:"helpful" bson:"helpful"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// QuestionRequest models the body of a question or an answer
+type QuestionRequest struct {
+	Body string `json:"body" binding:"required,max=2000"`
+}
@@ -0,0 +1,23 @@
+package entity
+
+import (
+	"time"
+)
+
+// Reasons stock is held
+const (
+	ReservationCheckout  = "checkout"
+	ReservationFlashSale = "flash_sale"
+)
+
+// Reservation holds stock of a product for a cart until it expires. Held stock is not available to other
+// shoppers; it is taken for real when the holder orders.
+type Reservation struct {
+	ID        string    `json:"_id" bson:"_id"`
+	UserID    string    `json:"user_id" bson:"user_id" description:"owner of the cart the stock is held for"`
+	ProductID string    `json:"product_id" bson:"product_id"`
+	Quantity  int64     `json:"quantity" bson:"quantity"`
+	Reason    string    `json:"reason" bson:"reason" description:"checkout or flash_sale"`
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
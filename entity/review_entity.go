@@ -0,0 +1,78 @@
+package entity
+
+import (
+	"time"
+)
+
+// Moderation statuses shared by user generated content
+const (
+	ModerationPending  = "pending"
+	ModerationApproved = "approved"
+	ModerationRejected = "rejected"
+)
+
+// Review is a single product review stored in the reviews collection. A user can review a product once.
+type Review struct {
+	ID               string    `json:"_id" bson:"_id"`
+	ProductID        string    `json:"product_id" bson:"product_id"`
+	UserID           string    `json:"user_id" bson:"user_id"`
+	User             string    `json:"user" bson:"user" description:"username of the reviewer, taken from the token"`
+	Stars            int64     `json:"stars" bson:"stars" binding:"required,min=1,max=5"`
+	Title            string    `json:"title,omitempty" bson:"title"`
+	Comment          string    `json:"comment,omitempty" bson:"comment"`
+	VerifiedPurchase bool      `json:"verified_purchase" bson:"verified_purchase" description:"reviewer has received an order of this product"`
+	CreatedAt        time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at,omitempty" bson:"updated_at"`
+
+	// Moderation
+	Status         string    `json:"status" bson:"status" description:"pending, approved or rejected"`
+	Flags          []string  `json:"flags,omitempty" bson:"flags" description:"reasons the content filter or shoppers flagged the review"`
+	ReportCount    int64     `json:"report_count" bson:"report_count"`
+	ModerationNote string    `json:"moderation_note,omitempty" bson:"moderation_note"`
+	ModeratedAt    time.Time `json:"moderated_at,omitempty" bson:"moderated_at"`
+
+	// Helpfulness
+	HelpfulVotes   int64 `json:"helpful_votes" bson:"helpful_votes"`
+	UnhelpfulVotes int64 `json:"unhelpful_votes" bson:"unhelpful_votes"`
+}
+
+// ReviewReport is a shopper's report of an abusive review
+type ReviewReport struct {
+	ID        string    `json:"_id" bson:"_id"`
+	ReviewID  string    `json:"review_id" bson:"review_id"`
+	UserID    string    `json:"user_id" bson:"user_id"`
+	Reason    string    `json:"reason" bson:"reason"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// ReviewVote is a shopper's helpful or unhelpful vote on a review
+type ReviewVote struct {
+	ID        string    `json:"_id" bson:"_id"`
+	ReviewID  string    `json:"review_id" bson:"review_id"`
+	UserID    string    `json:"user_id" bson:"user_id"`
+	Helpful   bool      `json:"helpful" bson:"helpful"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// ReviewRequest models the body of an add or edit review request
+type ReviewRequest struct {
+	Stars   int64  `json:"stars" binding:"required,min=1,max=5"`
+	Title   string `json:"title"`
+	Comment string `json:"comment"`
+}
+
+// RatingHistogram holds the number of reviews given for each star rating
+type RatingHistogram struct {
+	One   int64 `json:"1" bson:"1"`
+	Two   int64 `json:"2" bson:"2"`
+	Three int64 `json:"3" bson:"3"`
+	Four  int64 `json:"4" bson:"4"`
+	Five  int64 `json:"5" bson:"5"`
+}
+
+// RatingSummary models the aggregated ratings of a product
+type RatingSummary struct {
+	NoOfReviews   int64           `json:"no_of_reviews"`
+	AverageRating float64         `json:"average_rating"`
+	Histogram     RatingHistogram `json:"histogram"`
+}
@@ -0,0 +1,18 @@
+package entity
+
+import (
+	"time"
+)
+
+// StockAlert is raised when a product's stock falls to or below its low stock threshold.
+// It is resolved once the product is restocked above the threshold.
+type StockAlert struct {
+	ID          string    `json:"_id" bson:"_id"`
+	ProductID   string    `json:"product_id" bson:"product_id"`
+	ProductName string    `json:"product_name" bson:"product_name"`
+	Quantity    int64     `json:"quantity" bson:"quantity" description:"stock left when the alert was raised"`
+	Threshold   int64     `json:"threshold" bson:"threshold"`
+	Resolved    bool      `json:"resolved" bson:"resolved"`
+	CreatedAt   time.Time `json:"created_at" bson:"created_at"`
+	ResolvedAt  time.Time `json:"resolved_at,omitempty" bson:"resolved_at"`
+}
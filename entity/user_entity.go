@@ -38,20 +38,23 @@ type User struct {
 	DefaultDeliveryLocation Location  `json:"default_delivery_location" bson:"default_delivery_loaction"`
 
 	// Optional
-	FavouriteProducts   []string   `json:"favourite_products,omitempty" bson:"favourite_products" description:"ID's of user's favourite products"`
-	RegisteredLocations []Location `json:"locations,omitempty" bson:"locations"`
+	FavouriteProducts    []string   `json:"favourite_products,omitempty" bson:"favourite_products" description:"ID's of user's favourite products"`
+	WishlistShareToken   string     `json:"wishlist_share_token,omitempty" bson:"wishlist_share_token,omitempty" description:"token of the public read-only wishlist link, empty when not shared"`
+	RegisteredLocations  []Location `json:"locations,omitempty" bson:"locations"`
+	NotificationChannels []string   `json:"notification_channels,omitempty" bson:"notification_channels,omitempty" description:"channels the user is notified on, email and in_app when empty"`
 }
 
 // UserResponse models the response of a createuser or loginuser request
 type UserResponse struct {
-	ID             string    `json:"_id"`
-	Username       string    `json:"username"`
-	Fullname       string    `json:"fullname"`
-	Email          string    `json:"email"`
-	Token          string    `json:"token"`
-	CreatedAt      time.Time `json:"created_at"`
-	EmailIsVerfied bool      `json:"email_is_verified"`
-	MobileNumber   string    `json:"mobile_number,omitempty"`
+	ID             string     `json:"_id"`
+	Username       string     `json:"username"`
+	Fullname       string     `json:"fullname"`
+	Email          string     `json:"email"`
+	Token          string     `json:"token"`
+	CreatedAt      time.Time  `json:"created_at"`
+	EmailIsVerfied bool       `json:"email_is_verified"`
+	MobileNumber   string     `json:"mobile_number,omitempty"`
+	CartMerge      *CartMerge `json:"cart_merge,omitempty" description:"set when a guest cart was merged on login or signup"`
 }
 
 type Location struct {
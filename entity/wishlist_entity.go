@@ -0,0 +1,16 @@
+package entity
+
+// SharedWishlist models the read-only public view of a user's wishlist
+type SharedWishlist struct {
+	Owner    string    `json:"owner"`
+	Products []Product `json:"products"`
+}
+
+// WishlistReportEntry models how many users have a product on their wishlist
+type WishlistReportEntry struct {
+	ProductID string  `json:"product_id" bson:"_id"`
+	Name      string  `json:"name" bson:"name"`
+	Price     float64 `json:"price" bson:"price"`
+	Quantity  int64   `json:"quantity" bson:"quantity"`
+	Count     int64   `json:"count" bson:"count"`
+}
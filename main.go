@@ -2,6 +2,8 @@ package main
 
 import (
 	"log"
+	"os"
+	"time"
 
 	"github.com/Emmrys-Jay/ecommerce-api/db"
 	"github.com/Emmrys-Jay/ecommerce-api/endpoints"
@@ -23,6 +25,49 @@ func main() {
 		log.Fatalln(err)
 	}
 
+	// Orders used to hold a single product; give them order lines and totals. Verified
+	// purchases are looked up by order line, so this runs before reviews are migrated
+	if _, err := repository.MigrateSingleProductOrders(database); err != nil {
+		log.Fatalln("Error migrating single product orders: ", err)
+	}
+
+	// Orders used to be tracked with delivered and received flags; give them a status
+	if _, err := repository.MigrateOrderStatuses(database); err != nil {
+		log.Fatalln("Error migrating order statuses: ", err)
+	}
+
+	// Move reviews that are still embedded in product documents into the reviews collection
+	if _, err := repository.MigrateEmbeddedReviews(database); err != nil {
+		log.Fatalln("Error migrating product reviews: ", err)
+	}
+
+	// Products saved before minimum_order had a bson tag store it as "minimumorder"
+	if err := repository.MigrateMinimumOrderField(database); err != nil {
+		log.Fatalln("Error migrating product minimum order: ", err)
+	}
+
+	// Give products created before slugs and SKUs existed their identifiers
+	if _, err := repository.BackfillProductIdentifiers(database); err != nil {
+		log.Fatalln("Error generating product slugs and SKUs: ", err)
+	}
+
+	// Open the stock ledger of products stocked before it existed, so their stock reconciles
+	if _, err := repository.OpenStockLedger(database); err != nil {
+		log.Fatalln("Error opening the stock ledger: ", err)
+	}
+
+	// Notify shoppers waiting for products to come back in stock or drop in price
+	go repository.RunSubscriptionDispatcher(database, durationFromEnv("SUBSCRIPTION_SWEEP_INTERVAL", 10*time.Minute))
+
+	// Move scheduled products to published once their publish time passes
+	go repository.RunProductPublisher(database, durationFromEnv("PRODUCT_PUBLISH_INTERVAL", time.Minute))
+
+	// Remove guest carts that have not been used within GUEST_CART_TTL
+	go repository.RunGuestCartSweeper(database, durationFromEnv("GUEST_CART_SWEEP_INTERVAL", time.Hour))
+
+	// Remind users of carts they left untouched and have not ordered
+	go repository.RunAbandonedCartSweeper(database, durationFromEnv("ABANDONED_CART_SWEEP_INTERVAL", time.Hour))
+
 	// Get middlewares to verify admin and users
 	adminMdw := middleware.AuthorizeAdmin(adminUsername)
 	userMdw := middleware.AuthorizeJWT()
@@ -35,3 +80,12 @@ func main() {
 
 	log.Fatalln(server.Run())
 }
+
+// durationFromEnv reads a duration such as "10m" from an environment variable, falling back to def
+func durationFromEnv(key string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || d <= 0 {
+		return def
+	}
+	return d
+}
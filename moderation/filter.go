@@ -0,0 +1,120 @@
+package moderation
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Flags raised by the content filter
+const (
+	FlagProfanity = "profanity"
+	FlagLink      = "link"
+	FlagReported  = "reported"
+)
+
+// defaultReportThreshold is the number of reports that sends approved content back to the moderation queue
+const defaultReportThreshold = 3
+
+var linkPattern = regexp.MustCompile(`(?i)(https?://|www\.)\S+|\b[a-z0-9-]+\.(com|net|org|io|co|info|biz|xyz|ng)\b`)
+
+// Filter checks user submitted text against a list of banned words and, optionally, links
+type Filter struct {
+	BannedWords     []string
+	BlockLinks      bool
+	RequireApproval bool
+	ReportThreshold int64
+}
+
+var (
+	defaultFilter *Filter
+	once          sync.Once
+)
+
+// DefaultFilter returns the filter configured by the following environment variables:
+// - MODERATION_BANNED_WORDS: comma separated words that flag content
+// - MODERATION_BLOCK_LINKS: flag content containing links, defaults to true
+// - MODERATION_REQUIRE_APPROVAL: hold all content for an admin even when nothing is flagged
+// - MODERATION_REPORT_THRESHOLD: reports needed to send content back for moderation, defaults to 3
+func DefaultFilter() *Filter {
+	once.Do(func() {
+		defaultFilter = NewFilter(
+			strings.Split(os.Getenv("MODERATION_BANNED_WORDS"), ","),
+			envBool("MODERATION_BLOCK_LINKS", true),
+			envBool("MODERATION_REQUIRE_APPROVAL", false),
+			envInt("MODERATION_REPORT_THRESHOLD", defaultReportThreshold),
+		)
+	})
+
+	return defaultFilter
+}
+
+// NewFilter returns a filter for the given banned words, ignoring empty entries
+func NewFilter(bannedWords []string, blockLinks, requireApproval bool, reportThreshold int64) *Filter {
+	filter := &Filter{
+		BlockLinks:      blockLinks,
+		RequireApproval: requireApproval,
+		ReportThreshold: reportThreshold,
+	}
+
+	for _, w := range bannedWords {
+		if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+			filter.BannedWords = append(filter.BannedWords, w)
+		}
+	}
+
+	if filter.ReportThreshold < 1 {
+		filter.ReportThreshold = defaultReportThreshold
+	}
+
+	return filter
+}
+
+// Check returns the flags raised by the given texts, or nil if they are clean
+func (f *Filter) Check(texts ...string) []string {
+	var flags []string
+	text := strings.ToLower(strings.Join(texts, " "))
+
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '\'')
+	})
+
+WORDS:
+	for _, word := range words {
+		for _, banned := range f.BannedWords {
+			if word == banned {
+				flags = append(flags, FlagProfanity)
+				break WORDS
+			}
+		}
+	}
+
+	if f.BlockLinks && linkPattern.MatchString(text) {
+		flags = append(flags, FlagLink)
+	}
+
+	return flags
+}
+
+// NeedsApproval reports whether content with the given flags must wait for an admin before going live
+func (f *Filter) NeedsApproval(flags []string) bool {
+	return f.RequireApproval || len(flags) > 0
+}
+
+func envBool(key string, fallback bool) bool {
+	v, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+func envInt(key string, fallback int64) int64 {
+	v, err := strconv.ParseInt(os.Getenv(key), 10, 64)
+	if err != nil {
+		return fallback
+	}
+	return v
+}
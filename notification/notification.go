@@ -0,0 +1,156 @@
+// Package notification delivers messages to users over the channels they have enabled
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// Channels a user can be notified on
+const (
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+	ChannelInApp = "in_app"
+)
+
+// DefaultChannels are used for users who have not chosen their notification channels
+var DefaultChannels = []string{ChannelEmail, ChannelInApp}
+
+// ErrUnknownChannel is returned for a channel that is not one of the supported channels
+var ErrUnknownChannel = errors.New("unknown notification channel")
+
+// Recipient is who a message is sent to
+type Recipient struct {
+	UserID       string
+	Fullname     string
+	Email        string
+	MobileNumber string
+}
+
+// Message is the content of a notification. Link is an optional deep link into the store.
+type Message struct {
+	Subject string
+	Body    string
+	Link    string
+}
+
+// Sender delivers a message over one channel
+type Sender interface {
+	Send(ctx context.Context, to Recipient, msg Message) error
+}
+
+// IsChannel reports whether channel is a supported channel
+func IsChannel(channel string) bool {
+	return channel == ChannelEmail || channel == ChannelSMS || channel == ChannelInApp
+}
+
+// EmailSender sends messages over SMTP. Without a host, messages are only logged.
+type EmailSender struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// NewEmailSender reads the SMTP settings from SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD and SMTP_FROM
+func NewEmailSender() *EmailSender {
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	return &EmailSender{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+	}
+}
+
+// Send emails msg to the recipient
+func (s *EmailSender) Send(ctx context.Context, to Recipient, msg Message) error {
+	if to.Email == "" {
+		return nil
+	}
+
+	if s.Host == "" {
+		log.Printf("email to %s: %s", to.Email, msg.Subject)
+		return nil
+	}
+
+	body := msg.Body
+	if msg.Link != "" {
+		body += "\r\n\r\n" + msg.Link
+	}
+
+	data := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n", s.From, to.Email, msg.Subject, body)
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	return smtp.SendMail(s.Host+":"+s.Port, auth, s.From, []string{to.Email}, []byte(data))
+}
+
+// SMSSender posts messages to an SMS gateway webhook as {"to": ..., "body": ...}. Without a url, messages are only logged.
+type SMSSender struct {
+	WebhookURL string
+	Client     *http.Client
+}
+
+// NewSMSSender reads the gateway webhook from SMS_WEBHOOK_URL
+func NewSMSSender() *SMSSender {
+	return &SMSSender{
+		WebhookURL: os.Getenv("SMS_WEBHOOK_URL"),
+		Client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Send texts msg to the recipient
+func (s *SMSSender) Send(ctx context.Context, to Recipient, msg Message) error {
+	if to.MobileNumber == "" {
+		return nil
+	}
+
+	text := strings.TrimSpace(msg.Subject + " " + msg.Link)
+
+	if s.WebhookURL == "" {
+		log.Printf("sms to %s: %s", to.MobileNumber, text)
+		return nil
+	}
+
+	payload, err := json.Marshal(map[string]string{"to": to.MobileNumber, "body": text})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.WebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sms gateway returned %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,346 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Emmrys-Jay/ecommerce-api/currency"
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/notification"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultAbandonedCartAfter = 24 * time.Hour
+	defaultReminderInterval   = 24 * time.Hour
+	defaultMaxReminders       = 3
+	defaultReminderCouponTTL  = 7 * 24 * time.Hour
+)
+
+// staleCart is a user's cart with when any of its lines was last touched
+type staleCart struct {
+	UserID       string    `bson:"_id"`
+	LastActivity time.Time `bson:"last_activity"`
+	Lines        int       `bson:"lines"`
+}
+
+// RemindAbandonedCarts finds users' carts untouched for ABANDONED_CART_AFTER that were not ordered since, and
+// reminds their owners about them, at most ABANDONED_CART_REMINDERS times and ABANDONED_CART_REMINDER_INTERVAL
+// apart. Abandoned carts that have since been emptied are closed. It returns the number of reminders sent.
+func RemindAbandonedCarts(database *mongo.Database) (int, error) {
+	if err := closeClearedCarts(database); err != nil {
+		return 0, err
+	}
+
+	stale, err := findStaleCarts(database, time.Now().Add(-durationFromEnv("ABANDONED_CART_AFTER", defaultAbandonedCartAfter)))
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, cart := range stale {
+		ok, err := remindAbandonedCart(database, cart)
+		if err != nil {
+			log.Printf("abandoned cart of user %s: %v", cart.UserID, err)
+			continue
+		}
+		if ok {
+			sent++
+		}
+	}
+
+	return sent, nil
+}
+
+// RunAbandonedCartSweeper reminds users of their abandoned carts every interval until the process exits
+func RunAbandonedCartSweeper(database *mongo.Database, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := RemindAbandonedCarts(database); err != nil {
+			log.Printf("abandoned cart sweep: %v", err)
+		}
+	}
+}
+
+// findStaleCarts returns the carts of signed in users whose lines were all last touched before cutoff. Lines
+// older than their last_updated field count from when they were added.
+func findStaleCarts(database *mongo.Database, cutoff time.Time) ([]staleCart, error) {
+	ctx := context.Background()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"user_id": bson.M{"$not": primitive.Regex{Pattern: "^guest_"}}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":           "$user_id",
+			"last_activity": bson.M{"$max": bson.M{"$ifNull": bson.A{"$last_updated", "$date_added"}}},
+			"lines":         bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"last_activity": bson.M{"$lt": cutoff}}}},
+	}
+
+	cursor, err := db.GetCollection(database, "cart").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var carts []staleCart
+	if err := cursor.All(ctx, &carts); err != nil {
+		return nil, err
+	}
+
+	return carts, nil
+}
+
+// remindAbandonedCart sends the next reminder about a stale cart when one is due. It reports whether one was sent.
+func remindAbandonedCart(database *mongo.Database, cart staleCart) (bool, error) {
+	ctx := context.Background()
+	collection := db.GetCollection(database, "abandoned_carts")
+
+	// Ordering does not empty the cart, so a cart ordered since it was last touched is not abandoned
+	ordered, err := db.GetCollection(database, "orders").CountDocuments(ctx,
+		bson.M{"user_id": cart.UserID, "created_at": bson.M{"$gte": cart.LastActivity}}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	if ordered > 0 {
+		return false, nil
+	}
+
+	abandoned, err := openAbandonedCart(database, cart)
+	if err != nil {
+		return false, err
+	}
+
+	if abandoned.Reminders >= maxReminders() {
+		return false, nil
+	}
+	if abandoned.Reminders > 0 && time.Since(abandoned.LastReminderAt) < durationFromEnv("ABANDONED_CART_REMINDER_INTERVAL", defaultReminderInterval) {
+		return false, nil
+	}
+
+	var coupon *entity.Coupon
+	if percent := amountFromEnv("ABANDONED_CART_COUPON_PERCENT"); percent > 0 && percent < 100 && abandoned.CouponCode == "" {
+		ttl := durationFromEnv("ABANDONED_CART_COUPON_TTL", defaultReminderCouponTTL)
+		coupon, err = CreateCoupon(db.GetCollection(database, "coupons"), cart.UserID, percent, "abandoned_cart", ttl)
+		if err != nil {
+			return false, err
+		}
+		abandoned.CouponCode = coupon.Code
+	}
+
+	if err := NotifyUser(database, cart.UserID, abandonedCartMessage(cart, coupon)); err != nil {
+		return false, err
+	}
+
+	update := bson.M{
+		"$inc": bson.M{"reminders": 1},
+		"$set": bson.M{"last_reminder_at": time.Now(), "last_activity": cart.LastActivity, "coupon_code": abandoned.CouponCode},
+	}
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": abandoned.ID}, update); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// openAbandonedCart returns the open abandoned cart of a user, recording one when the cart was not abandoned before
+func openAbandonedCart(database *mongo.Database, cart staleCart) (*entity.AbandonedCart, error) {
+	ctx := context.Background()
+	collection := db.GetCollection(database, "abandoned_carts")
+	filter := bson.M{"user_id": cart.UserID, "status": entity.AbandonedCartOpen}
+
+	var abandoned entity.AbandonedCart
+	err := collection.FindOne(ctx, filter).Decode(&abandoned)
+	if err != mongo.ErrNoDocuments {
+		return &abandoned, err
+	}
+
+	abandoned = entity.AbandonedCart{
+		ID:           primitive.NewObjectIDFromTimestamp(time.Now()).Hex(),
+		UserID:       cart.UserID,
+		Status:       entity.AbandonedCartOpen,
+		LastActivity: cart.LastActivity,
+		DetectedAt:   time.Now(),
+	}
+
+	// The value is only for reporting, so a cart that cannot be priced is still reminded about
+	summary, err := PriceCart(database, cart.UserID, "")
+	if err != nil {
+		log.Printf("could not price abandoned cart of user %s: %v", cart.UserID, err)
+	} else {
+		abandoned.Value = summary.GrandTotal
+	}
+
+	_, err = collection.InsertOne(ctx, abandoned)
+
+	// Another sweep recorded it first
+	if mongo.IsDuplicateKeyError(err) {
+		err = collection.FindOne(ctx, filter).Decode(&abandoned)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &abandoned, nil
+}
+
+func abandonedCartMessage(cart staleCart, coupon *entity.Coupon) notification.Message {
+	msg := notification.Message{
+		Subject: "You left something in your cart",
+		Body:    "The products in your cart are still waiting for you. Check out before they sell out.",
+		Link:    storeLink("/user/cart/get_all"),
+	}
+
+	if cart.Lines == 1 {
+		msg.Body = "The product in your cart is still waiting for you. Check out before it sells out."
+	}
+
+	if coupon != nil {
+		msg.Body += fmt.Sprintf(" Use coupon %s at checkout for %g%% off before %s.",
+			coupon.Code, coupon.Percent, coupon.ExpiresAt.Format("2 Jan 2006"))
+	}
+
+	return msg
+}
+
+// closeClearedCarts closes the open abandoned carts whose owners have since emptied their cart
+func closeClearedCarts(database *mongo.Database) error {
+	ctx := context.Background()
+	collection := db.GetCollection(database, "abandoned_carts")
+
+	ids, err := collection.Distinct(ctx, "user_id", bson.M{"status": entity.AbandonedCartOpen})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		count, err := db.GetCollection(database, "cart").CountDocuments(ctx, bson.M{"user_id": id}, options.Count().SetLimit(1))
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+
+		filter := bson.M{"user_id": id, "status": entity.AbandonedCartOpen}
+		update := bson.M{"$set": bson.M{"status": entity.AbandonedCartCleared, "closed_at": time.Now()}}
+		if _, err := collection.UpdateOne(ctx, filter, update); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recoverAbandonedCart marks the open abandoned cart of a user as recovered by an order, taking the discounted
+// value of its goods in the base currency as recovered revenue, so no more reminders are sent. The order is
+// already placed, so failures are only logged.
+func recoverAbandonedCart(database *mongo.Database, userID string, order *entity.Order) {
+	rates, err := LoadRates(database, time.Now())
+	if err != nil {
+		log.Printf("could not recover abandoned cart of user %s: %v", userID, err)
+		return
+	}
+
+	revenue, err := rates.Convert(order.Subtotal-order.Discount, order.Currency, currency.Base())
+	if err != nil {
+		log.Printf("could not recover abandoned cart of user %s: %v", userID, err)
+		return
+	}
+
+	filter := bson.M{"user_id": userID, "status": entity.AbandonedCartOpen}
+	update := bson.M{
+		"$set": bson.M{
+			"status":            entity.AbandonedCartRecovered,
+			"recovered_revenue": currency.Round(revenue, currency.Base()),
+			"closed_at":         time.Now(),
+		},
+	}
+
+	_, err = db.GetCollection(database, "abandoned_carts").UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		log.Printf("could not recover abandoned cart of user %s: %v", userID, err)
+	}
+}
+
+// GetAbandonmentReport sums up the carts abandoned and the users who ordered since a point in time
+func GetAbandonmentReport(database *mongo.Database, since time.Time) (*entity.AbandonmentReport, error) {
+	ctx := context.Background()
+	base := currency.Base()
+	report := &entity.AbandonmentReport{Since: since, Currency: base}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"detected_at": bson.M{"$gte": since}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":       nil,
+			"abandoned": bson.M{"$sum": 1},
+			"recovered": bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$status", entity.AbandonedCartRecovered}}, 1, 0}}},
+			"value":     bson.M{"$sum": "$value"},
+			"revenue":   bson.M{"$sum": "$recovered_revenue"},
+			"reminders": bson.M{"$sum": "$reminders"},
+		}}},
+	}
+
+	cursor, err := db.GetCollection(database, "abandoned_carts").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var totals []struct {
+		Abandoned int64   `bson:"abandoned"`
+		Recovered int64   `bson:"recovered"`
+		Value     float64 `bson:"value"`
+		Revenue   float64 `bson:"revenue"`
+		Reminders int64   `bson:"reminders"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return nil, err
+	}
+
+	if len(totals) > 0 {
+		report.Abandoned = totals[0].Abandoned
+		report.Recovered = totals[0].Recovered
+		report.AbandonedValue = currency.Round(totals[0].Value, base)
+		report.RecoveredRevenue = currency.Round(totals[0].Revenue, base)
+		report.RemindersSent = totals[0].Reminders
+	}
+
+	buyers, err := db.GetCollection(database, "orders").Distinct(ctx, "user_id", bson.M{"created_at": bson.M{"$gte": since}})
+	if err != nil {
+		return nil, err
+	}
+	report.Converted = int64(len(buyers))
+
+	lost := report.Abandoned - report.Recovered
+	if lost+report.Converted > 0 {
+		report.AbandonmentRate = rate(lost, lost+report.Converted)
+	}
+	if report.Abandoned > 0 {
+		report.RecoveryRate = rate(report.Recovered, report.Abandoned)
+	}
+
+	return report, nil
+}
+
+// rate returns part as a share of whole, to four decimal places
+func rate(part, whole int64) float64 {
+	return math.Round(float64(part)/float64(whole)*10000) / 10000
+}
+
+func maxReminders() int {
+	n, err := strconv.Atoi(os.Getenv("ABANDONED_CART_REMINDERS"))
+	if err != nil || n < 0 {
+		return defaultMaxReminders
+	}
+	return n
+}
@@ -0,0 +1,264 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrInvalidAttribute is returned when a product attribute or attribute filter does not fit the category schema
+var ErrInvalidAttribute = errors.New("invalid attribute")
+
+// rangeSeparator separates the bounds of a numeric attribute filter, e.g. "13..15", "13.." or "..15"
+const rangeSeparator = ".."
+
+// ProductFilter narrows product listings to products with all of Tags and attribute values matching Attributes.
+// An attribute filter is a comma separated list of accepted values, or a numeric range such as "13..15".
+// Only products live in the storefront are listed unless Statuses asks for others.
+type ProductFilter struct {
+	Tags       []string
+	Attributes map[string]string
+	Statuses   []string
+}
+
+// apply adds the status, tag and attribute conditions of f to filter
+func (f ProductFilter) apply(filter bson.M) (bson.M, error) {
+	conditions := []bson.M{statusCondition(f.Statuses, time.Now())}
+
+	if tags := normalizeTags(f.Tags); len(tags) > 0 {
+		conditions = append(conditions, bson.M{"tags": bson.M{"$all": tags}})
+	}
+
+	for key, value := range f.Attributes {
+		if !validAttributeKey(key) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidAttribute, key)
+		}
+
+		condition, err := attributeCondition(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidAttribute, key, err)
+		}
+
+		conditions = append(conditions, bson.M{"attributes." + key: condition})
+	}
+
+	return bson.M{"$and": append([]bson.M{filter}, conditions...)}, nil
+}
+
+func attributeCondition(value string) (bson.M, error) {
+	if strings.Contains(value, rangeSeparator) {
+		bounds := strings.SplitN(value, rangeSeparator, 2)
+		condition := bson.M{}
+
+		for i, op := range []string{"$gte", "$lte"} {
+			bound := strings.TrimSpace(bounds[i])
+			if bound == "" {
+				continue
+			}
+
+			n, err := strconv.ParseFloat(bound, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid range bound %q", bound)
+			}
+			condition[op] = n
+		}
+
+		if len(condition) == 0 {
+			return nil, errors.New("empty range")
+		}
+
+		return condition, nil
+	}
+
+	// Values are matched both as written and as the number or boolean they spell, since the type is not known here
+	var accepted bson.A
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		accepted = append(accepted, v)
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			accepted = append(accepted, n)
+		}
+		if b, err := strconv.ParseBool(v); err == nil {
+			accepted = append(accepted, b)
+		}
+	}
+
+	if len(accepted) == 0 {
+		return nil, errors.New("no value")
+	}
+
+	return bson.M{"$in": accepted}, nil
+}
+
+// validAttributeKey rejects keys that would be read as a nested field path or an operator
+func validAttributeKey(key string) bool {
+	return key != "" && !strings.ContainsAny(key, ".$")
+}
+
+// normalizeTags lowercases, trims and de-duplicates tags
+func normalizeTags(tags []string) []string {
+	var normalized []string
+	seen := make(map[string]bool)
+
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+
+		seen[t] = true
+		normalized = append(normalized, t)
+	}
+
+	return normalized
+}
+
+// ValidateProductAttributes normalizes a product's tags and checks its attributes against the schema of its category.
+// Attribute values given as text, as they are in csv imports, are converted to the type the schema declares.
+func ValidateProductAttributes(database *mongo.Database, product *entity.Product) error {
+	product.Tags = normalizeTags(product.Tags)
+
+	schema, err := GetCategorySchema(db.GetCollection(database, "category_schemas"), product.Category)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	definitions := make(map[string]entity.AttributeDefinition)
+	if schema != nil {
+		for _, d := range schema.Attributes {
+			definitions[d.Key] = d
+		}
+	}
+
+	for key, value := range product.Attributes {
+		if !validAttributeKey(key) {
+			return fmt.Errorf("%w: %q", ErrInvalidAttribute, key)
+		}
+
+		if schema == nil {
+			if product.Attributes[key], err = scalarAttribute(value); err != nil {
+				return fmt.Errorf("%w: %s: %v", ErrInvalidAttribute, key, err)
+			}
+			continue
+		}
+
+		d, ok := definitions[key]
+		if !ok {
+			return fmt.Errorf("%w: %s is not an attribute of %s", ErrInvalidAttribute, key, product.Category)
+		}
+
+		if product.Attributes[key], err = typedAttribute(d, value); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidAttribute, key, err)
+		}
+	}
+
+	for key, d := range definitions {
+		if _, ok := product.Attributes[key]; d.Required && !ok {
+			return fmt.Errorf("%w: %s is required for %s", ErrInvalidAttribute, key, product.Category)
+		}
+	}
+
+	return nil
+}
+
+// scalarAttribute accepts text, numbers and booleans, storing every number as a float64 so they compare alike
+func scalarAttribute(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string, bool, float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	}
+
+	return nil, fmt.Errorf("unsupported value %v", value)
+}
+
+func typedAttribute(d entity.AttributeDefinition, value interface{}) (interface{}, error) {
+	value, err := scalarAttribute(value)
+	if err != nil {
+		return nil, err
+	}
+
+	text, isText := value.(string)
+
+	switch d.Type {
+	case entity.AttributeNumber:
+		if isText {
+			return strconv.ParseFloat(strings.TrimSpace(text), 64)
+		}
+		if n, ok := value.(float64); ok {
+			return n, nil
+		}
+	case entity.AttributeBoolean:
+		if isText {
+			return strconv.ParseBool(strings.TrimSpace(text))
+		}
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	case entity.AttributeEnum:
+		for _, option := range d.Options {
+			if isText && option == text {
+				return text, nil
+			}
+		}
+		return nil, fmt.Errorf("must be one of %s", strings.Join(d.Options, ", "))
+	default:
+		if isText {
+			return text, nil
+		}
+	}
+
+	return nil, fmt.Errorf("must be a %s", d.Type)
+}
+
+// SaveCategorySchema creates or replaces the attribute schema of a category
+func SaveCategorySchema(collection *mongo.Collection, schema *entity.CategorySchema) error {
+	for _, d := range schema.Attributes {
+		if !validAttributeKey(d.Key) {
+			return fmt.Errorf("%w: %q", ErrInvalidAttribute, d.Key)
+		}
+		if d.Type == entity.AttributeEnum && len(d.Options) == 0 {
+			return fmt.Errorf("%w: enum %s has no options", ErrInvalidAttribute, d.Key)
+		}
+	}
+
+	schema.UpdatedAt = time.Now()
+
+	_, err := collection.ReplaceOne(context.Background(), bson.M{"_id": schema.Category}, schema, options.Replace().SetUpsert(true))
+	return err
+}
+
+// GetCategorySchema returns the attribute schema of a category
+func GetCategorySchema(collection *mongo.Collection, category string) (*entity.CategorySchema, error) {
+	var schema entity.CategorySchema
+
+	err := collection.FindOne(context.Background(), bson.M{"_id": category}).Decode(&schema)
+	if err != nil {
+		return nil, err
+	}
+
+	return &schema, nil
+}
+
+// DeleteCategorySchema removes the attribute schema of a category
+func DeleteCategorySchema(collection *mongo.Collection, category string) (*mongo.DeleteResult, error) {
+	return collection.DeleteOne(context.Background(), bson.M{"_id": category})
+}
@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/Emmrys-Jay/ecommerce-api/currency"
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrInvalidBundle is returned when a bundle's components are missing, repeated, bundles themselves or priced in different currencies
+var ErrInvalidBundle = errors.New("invalid bundle")
+
+// PrepareBundle checks the components of a bundle and sets its list price from them.
+// Components and discounts are dropped from products that are not bundles.
+func PrepareBundle(collection *mongo.Collection, product *entity.Product) error {
+	if !product.IsBundle() {
+		product.Components = nil
+		product.BundleDiscount = 0
+		return nil
+	}
+
+	if len(product.Components) == 0 {
+		return fmt.Errorf("%w: a bundle needs at least one component", ErrInvalidBundle)
+	}
+
+	ids := make([]string, 0, len(product.Components))
+	seen := make(map[string]bool)
+	for _, c := range product.Components {
+		if c.ProductID == product.ID || seen[c.ProductID] {
+			return fmt.Errorf("%w: component %s is repeated", ErrInvalidBundle, c.ProductID)
+		}
+		if c.Quantity < 1 {
+			return fmt.Errorf("%w: component %s needs a quantity of at least 1", ErrInvalidBundle, c.ProductID)
+		}
+
+		seen[c.ProductID] = true
+		ids = append(ids, c.ProductID)
+	}
+
+	components, err := findProductsInOrder(collection, ids)
+	if err != nil {
+		return err
+	}
+
+	if len(components) != len(ids) {
+		return fmt.Errorf("%w: a component does not exist", ErrInvalidBundle)
+	}
+
+	var price float64
+	for i, c := range components {
+		if c.IsBundle() {
+			return fmt.Errorf("%w: %s is a bundle itself", ErrInvalidBundle, c.Name)
+		}
+		if c.IsDigital() {
+			return fmt.Errorf("%w: %s is digital and holds no stock", ErrInvalidBundle, c.Name)
+		}
+		if ProductCurrency(&c) != ProductCurrency(&components[0]) {
+			return fmt.Errorf("%w: components must be priced in the same currency", ErrInvalidBundle)
+		}
+
+		price += c.Price * float64(product.Components[i].Quantity)
+	}
+
+	product.Currency = ProductCurrency(&components[0])
+	product.Price = bundlePrice(price, product.BundleDiscount, product.Currency)
+	product.Quantity = 0
+
+	return nil
+}
+
+func bundlePrice(sum, discount float64, code string) float64 {
+	return currency.Round(sum*(1-discount/100), code)
+}
+
+// resolveBundles works out the list price, component names and stock of bundles from the current state of their components.
+// It returns the effective price of every bundle, which takes the running sales of its components into account.
+func resolveBundles(database *mongo.Database, products []entity.Product) (map[string]float64, error) {
+	var ids []string
+	for _, p := range products {
+		if !p.IsBundle() {
+			continue
+		}
+		for _, c := range p.Components {
+			ids = append(ids, c.ProductID)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	components, err := findProductsInOrder(db.GetCollection(database, "products"), ids)
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]entity.Product, len(components))
+	for _, c := range components {
+		if !c.IsBundle() {
+			current[c.ID] = c
+		}
+	}
+
+	effective := make(map[string]float64)
+	for i := range products {
+		p := &products[i]
+		if !p.IsBundle() {
+			continue
+		}
+
+		var price, effectivePrice float64
+		available := int64(math.MaxInt64)
+
+		for j, c := range p.Components {
+			component, ok := current[c.ProductID]
+			if !ok {
+				available = 0
+				continue
+			}
+
+			p.Components[j].Name = component.Name
+			price += component.Price * float64(c.Quantity)
+			effectivePrice += component.EffectivePrice * float64(c.Quantity)
+
+			if n := component.Quantity / c.Quantity; n < available {
+				available = n
+			}
+		}
+
+		if available < 0 || available == math.MaxInt64 {
+			available = 0
+		}
+
+		code := ProductCurrency(p)
+		p.Price = bundlePrice(price, p.BundleDiscount, code)
+		p.Quantity = available
+		effective[p.ID] = bundlePrice(effectivePrice, p.BundleDiscount, code)
+	}
+
+	return effective, nil
+}
+
+// takeStock removes quantity from a product's stock only if it has that much left, returning the product as it was before
+func takeStock(collection *mongo.Collection, productID string, quantity int64) (*entity.Product, error) {
+	var before entity.Product
+
+	filter := bson.M{"_id": productID, "quantity": bson.M{"$gte": quantity}}
+	update := bson.M{"$inc": bson.M{"quantity": -quantity}, "$set": bson.M{"last_updated": time.Now()}}
+
+	err := collection.FindOneAndUpdate(context.Background(), filter, update).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInsufficientStock
+	}
+
+	return &before, err
+}
+
+// decrementBundleStock takes the stock of every component of quantity bundles shipping to destination.
+// Either all components are allocated or, when one runs out, the ones already taken are put back and none are.
+func decrementBundleStock(database *mongo.Database, bundle *entity.Product, quantity int64, destination entity.Location) ([]entity.StockAllocation, error) {
+	var allocations []entity.StockAllocation
+
+	for _, c := range bundle.Components {
+		taken, err := allocateStock(database, c.ProductID, c.Quantity*quantity, destination)
+		allocations = append(allocations, taken...)
+
+		if err != nil {
+			releaseStock(database, allocations)
+
+			if err == ErrInsufficientStock {
+				return nil, fmt.Errorf("%w: %s", ErrInsufficientStock, c.Name)
+			}
+			return nil, err
+		}
+	}
+
+	if err := countOrders(database, bundle.ID, quantity); err != nil {
+		releaseStock(database, allocations)
+		return nil, err
+	}
+
+	return allocations, nil
+}
+
+// countOrders adds quantity to the number of orders of a product
+func countOrders(database *mongo.Database, productID string, quantity int64) error {
+	_, err := db.GetCollection(database, "products").UpdateByID(context.Background(), productID, bson.M{"$inc": bson.M{"numoforders": quantity}})
+	return err
+}
@@ -0,0 +1,190 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Emmrys-Jay/ecommerce-api/currency"
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// PriceCart prices the cart owned by ownerID with current product data in the given currency, or the base
+// currency when it is empty. Lines are flagged when their price changed since they were added or when they
+// can no longer be ordered as they are.
+func PriceCart(database *mongo.Database, ownerID, code string) (*entity.CartSummary, error) {
+	ctx := context.Background()
+
+	if code == "" {
+		code = currency.Base()
+	}
+
+	// The stored items keep the price each product had when it was added
+	var items = []entity.CartItem{}
+	cursor, err := db.GetCollection(database, "cart").Find(ctx, bson.M{"user_id": ownerID})
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ProductID)
+	}
+
+	products, err := findProductsInOrder(db.GetCollection(database, "products"), ids)
+	if err != nil {
+		return nil, err
+	}
+
+	held := make([]*entity.Product, len(products))
+	for i := range products {
+		held[i] = &products[i]
+	}
+
+	if err := applyReservations(database, ownerID, held...); err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]entity.Product, len(products))
+	for _, p := range products {
+		current[p.ID] = p
+	}
+
+	rates, err := LoadRates(database, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &entity.CartSummary{Currency: code, Lines: []entity.CartLine{}, CanCheckout: true}
+	shipped := false
+
+	for _, item := range items {
+		line := entity.CartLine{
+			CartItemID: item.ID,
+			ProductID:  item.ProductID,
+			Name:       item.Product.Name,
+			Quantity:   item.Quantity,
+		}
+
+		product, ok := current[item.ProductID]
+		if !ok {
+			line.Issues = []string{entity.CartIssueUnavailable}
+			summary.Lines = append(summary.Lines, line)
+			summary.CanCheckout = false
+			continue
+		}
+
+		display, err := ConvertProductPrice(rates, &product, code)
+		if err != nil {
+			return nil, err
+		}
+
+		line.Name = product.Name
+		line.UnitPrice = display.Price
+		line.EffectivePrice = display.EffectivePrice
+		line.Discount = currency.Round((display.Price-display.EffectivePrice)*float64(item.Quantity), code)
+		line.LineTotal = currency.Round(display.EffectivePrice*float64(item.Quantity), code)
+
+		previous := item.UnitPrice
+		if previous == 0 {
+			previous = item.Product.Price
+		}
+		if previous != product.EffectivePrice {
+			line.PreviousPrice = currency.Round(previous*display.Rate, code)
+			line.Issues = append(line.Issues, entity.CartIssuePriceChanged)
+		}
+
+		if issue := quantityIssue(&product, item.Quantity); issue != "" {
+			line.Issues = append(line.Issues, issue)
+			summary.Lines = append(summary.Lines, line)
+			summary.CanCheckout = false
+			continue
+		}
+
+		summary.Subtotal += currency.Round(display.Price*float64(item.Quantity), code)
+		summary.Discount += line.Discount
+		shipped = shipped || !product.IsDigital()
+		summary.Lines = append(summary.Lines, line)
+	}
+
+	goods := summary.Subtotal - summary.Discount
+
+	summary.Shipping, err = ShippingFee(rates, code, goods, shipped)
+	if err != nil {
+		return nil, err
+	}
+
+	summary.Tax = Tax(goods, code)
+	summary.Subtotal = currency.Round(summary.Subtotal, code)
+	summary.Discount = currency.Round(summary.Discount, code)
+	summary.GrandTotal = currency.Round(goods+summary.Shipping+summary.Tax, code)
+
+	if len(items) == 0 {
+		summary.CanCheckout = false
+	}
+
+	return summary, nil
+}
+
+// quantityIssue returns the cart issue that stops quantity of a product being ordered, if any
+func quantityIssue(product *entity.Product, quantity int64) string {
+	err := ValidateQuantity(product, quantity)
+
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrNotForSale):
+		return entity.CartIssueNotForSale
+	case errors.Is(err, ErrBelowMinimumOrder):
+		return entity.CartIssueBelowMinimum
+	case errors.Is(err, ErrAboveMaximumOrder):
+		return entity.CartIssueAboveMaximum
+	case errors.Is(err, ErrInsufficientStock) && product.Quantity <= 0:
+		return entity.CartIssueOutOfStock
+	default:
+		return entity.CartIssueInsufficientStock
+	}
+}
+
+// ShippingFee estimates the shipping of goods worth amount in currency code. SHIPPING_FEE is a flat fee in the
+// base currency, waived for goods worth at least FREE_SHIPPING_THRESHOLD. Nothing is charged when nothing is shipped.
+func ShippingFee(rates currency.Rates, code string, amount float64, shipped bool) (float64, error) {
+	fee := amountFromEnv("SHIPPING_FEE")
+	if !shipped || fee == 0 {
+		return 0, nil
+	}
+
+	if threshold := amountFromEnv("FREE_SHIPPING_THRESHOLD"); threshold > 0 {
+		converted, err := rates.Convert(threshold, currency.Base(), code)
+		if err != nil {
+			return 0, err
+		}
+		if amount >= converted {
+			return 0, nil
+		}
+	}
+
+	return rates.Convert(fee, currency.Base(), code)
+}
+
+// Tax returns the tax on amount at TAX_RATE percent
+func Tax(amount float64, code string) float64 {
+	return currency.Round(amount*amountFromEnv("TAX_RATE")/100, code)
+}
+
+// amountFromEnv reads a non-negative amount from an environment variable, or 0 when it is unset or invalid
+func amountFromEnv(key string) float64 {
+	amount, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil || amount < 0 {
+		return 0
+	}
+	return amount
+}
@@ -2,7 +2,7 @@ package repository
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"time"
 
 	"github.com/Emmrys-Jay/ecommerce-api/db"
@@ -14,7 +14,9 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func AddToCart(collection *mongo.Collection, quantity int64, productID, userID string) (*mongo.InsertOneResult, error) {
+// AddToCart adds quantity of a product to a user's cart. A product already in the cart has its quantity
+// increased, so each user has one line per product. It returns the cart line as it is after the add.
+func AddToCart(collection *mongo.Collection, quantity int64, productID, userID string) (*entity.CartItem, error) {
 	ctx := context.Background()
 
 	product, err := FindOneProduct(db.GetCollection(collection.Database(), "products"), productID)
@@ -22,23 +24,59 @@ func AddToCart(collection *mongo.Collection, quantity int64, productID, userID s
 		return nil, err
 	}
 
-	res := collection.FindOne(ctx, bson.M{"user_id": userID})
-	if res.Err() == nil {
-		return nil, errors.New("product already in your cart")
+	if err := applyReservations(collection.Database(), userID, product); err != nil {
+		return nil, err
 	}
 
-	item := entity.CartItem{
-		ID:        primitive.NewObjectIDFromTimestamp(time.Now()).Hex(),
-		ProductID: product.ID,
-		UserID:    userID,
-		Quantity:  quantity,
-		DateAdded: time.Now(),
-		Product:   *product,
+	filter := bson.M{"user_id": userID, "product_id": product.ID}
+	update := bson.M{
+		"$inc": bson.M{"quantity": quantity},
+		"$set": bson.M{"product": *product, "unit_price": product.EffectivePrice, "last_updated": time.Now()},
+		"$setOnInsert": bson.M{
+			"_id":        primitive.NewObjectIDFromTimestamp(time.Now()).Hex(),
+			"date_added": time.Now(),
+		},
 	}
 
-	result, err := collection.InsertOne(ctx, item)
+	var item entity.CartItem
+	for attempt := 0; ; attempt++ {
+		var existing entity.CartItem
+		err = collection.FindOne(ctx, filter).Decode(&existing)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+		found := err == nil
 
-	return result, err
+		// The limits apply to the whole line, not just what is being added
+		if err := ValidateQuantity(product, existing.Quantity+quantity); err != nil {
+			return nil, err
+		}
+
+		// The line is only added to if it still holds what was checked. A new line is inserted only if no other
+		// add inserted it first, which the unique user and product index turns into a duplicate key error.
+		match := bson.M{"user_id": userID, "product_id": product.ID, "quantity": existing.Quantity}
+		if !found {
+			match["quantity"] = bson.M{"$exists": false}
+		}
+		opts := options.FindOneAndUpdate().SetUpsert(!found).SetReturnDocument(options.After)
+
+		err = collection.FindOneAndUpdate(ctx, match, update, opts).Decode(&item)
+		if err == nil {
+			break
+		}
+		if err != mongo.ErrNoDocuments && !mongo.IsDuplicateKeyError(err) {
+			return nil, err
+		}
+		if attempt == 4 {
+			return nil, fmt.Errorf("the cart line of %s kept changing, try again", product.Name)
+		}
+	}
+
+	if err := holdFlashSale(collection.Database(), userID, product, item.Quantity); err != nil {
+		return nil, err
+	}
+
+	return &item, nil
 }
 
 func RemoveFromCart(collection *mongo.Collection, cartItemID, userID string) (*mongo.DeleteResult, error) {
@@ -55,39 +93,54 @@ func RemoveFromCart(collection *mongo.Collection, cartItemID, userID string) (*m
 		},
 	}
 
-	result, err := collection.DeleteOne(ctx, filter)
+	var item entity.CartItem
+	err := collection.FindOneAndDelete(ctx, filter).Decode(&item)
+	if err == mongo.ErrNoDocuments {
+		return &mongo.DeleteResult{}, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return result, nil
+	// Stock held for the line is no longer needed
+	if err := releaseReservation(collection.Database(), userID, item.ProductID); err != nil {
+		return nil, err
+	}
 
+	return &mongo.DeleteResult{DeletedCount: 1}, nil
 }
 
-func UpdateCartQuantity(collection *mongo.Collection, quantity int, cartItemID, username string) (*mongo.UpdateResult, error) {
+// UpdateCartQuantity sets the quantity of a line in a user's cart
+func UpdateCartQuantity(collection *mongo.Collection, quantity int, cartItemID, userID string) (*mongo.UpdateResult, error) {
 	ctx := context.Background()
 
-	filter := bson.M{
-		"$and": []bson.M{
-			{
-				"_id": cartItemID,
-			},
-			{
-				"username": username,
-			},
-		},
+	cartItem, err := GetCartItem(collection, cartItemID, userID)
+	if err != nil {
+		return nil, err
 	}
 
-	cartItem, err := GetCartItem(collection, cartItemID, username)
+	product, err := FindOneProduct(db.GetCollection(collection.Database(), "products"), cartItem.ProductID)
 	if err != nil {
 		return nil, err
 	}
 
-	cartItem.Quantity = int64(quantity)
+	if err := applyReservations(collection.Database(), userID, product); err != nil {
+		return nil, err
+	}
 
-	result, err := collection.ReplaceOne(ctx, filter, cartItem)
+	if err := ValidateQuantity(product, int64(quantity)); err != nil {
+		return nil, err
+	}
 
-	return result, err
+	filter := bson.M{"_id": cartItemID, "user_id": userID}
+	update := bson.M{"$set": bson.M{"quantity": int64(quantity), "last_updated": time.Now()}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, holdFlashSale(collection.Database(), userID, product, int64(quantity))
 }
 
 func GetCartItem(collection *mongo.Collection, cartItemID, userID string) (*entity.CartItem, error) {
@@ -152,9 +205,47 @@ func GetUserCartItems(collection *mongo.Collection, userID string, offset, limit
 		cartItems = append(cartItems, cartItem)
 	}
 
+	if err := refreshCartItemPricing(collection.Database(), cartItems); err != nil {
+		return nil, 0, err
+	}
+
 	return cartItems, length, err
 }
 
+// refreshCartItemPricing replaces the pricing of the product snapshots stored with cart items
+// with the current pricing of the products, so the cart agrees with product listings
+func refreshCartItemPricing(database *mongo.Database, cartItems []entity.CartItem) error {
+	ids := make([]string, 0, len(cartItems))
+	for _, item := range cartItems {
+		ids = append(ids, item.ProductID)
+	}
+
+	products, err := findProductsInOrder(db.GetCollection(database, "products"), ids)
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]entity.Product, len(products))
+	for _, p := range products {
+		current[p.ID] = p
+	}
+
+	for i := range cartItems {
+		p, ok := current[cartItems[i].ProductID]
+		if !ok {
+			continue
+		}
+
+		cartItems[i].Product.Price = p.Price
+		cartItems[i].Product.Sales = p.Sales
+		cartItems[i].Product.EffectivePrice = p.EffectivePrice
+		cartItems[i].Product.OnSale = p.OnSale
+		cartItems[i].Product.LowestPrice30Days = p.LowestPrice30Days
+	}
+
+	return nil
+}
+
 func GetAllCartItems(collection *mongo.Collection, offset, limit int) ([]entity.CartItem, int64, error) {
 	ctx := context.Background()
 	var cartItems = []entity.CartItem{}
@@ -201,16 +292,21 @@ func DeleteAllCartItems(collection *mongo.Collection) (*mongo.DeleteResult, erro
 	return result, err
 }
 
-func DeleteAllUserCartItems(collection *mongo.Collection, username string) (*mongo.DeleteResult, error) {
+// DeleteAllUserCartItems empties the cart of a user
+func DeleteAllUserCartItems(collection *mongo.Collection, userID string) (*mongo.DeleteResult, error) {
 	ctx := context.Background()
 
-	filter := bson.M{"username": username}
+	filter := bson.M{"user_id": userID}
 
-	result, err := collection.DeleteOne(ctx, filter)
+	result, err := collection.DeleteMany(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := ReleaseReservations(collection.Database(), userID); err != nil {
+		return nil, err
+	}
+
 	return result, err
 }
 
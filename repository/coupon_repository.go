@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrInvalidCoupon is returned for a coupon that does not exist, belongs to someone else, was used or has expired
+var ErrInvalidCoupon = errors.New("invalid coupon")
+
+// CreateCoupon issues a single use coupon taking percent off an order of userID, valid for ttl
+func CreateCoupon(collection *mongo.Collection, userID string, percent float64, reason string, ttl time.Duration) (*entity.Coupon, error) {
+	token, err := util.SecureToken(5)
+	if err != nil {
+		return nil, err
+	}
+
+	coupon := entity.Coupon{
+		Code:      strings.ToUpper(token),
+		UserID:    userID,
+		Percent:   percent,
+		Reason:    reason,
+		ExpiresAt: time.Now().Add(ttl),
+		CreatedAt: time.Now(),
+	}
+
+	if _, err := collection.InsertOne(context.Background(), coupon); err != nil {
+		return nil, err
+	}
+
+	return &coupon, nil
+}
+
+// redeemCoupon marks a coupon of userID as used and returns it, so it cannot be used by another order
+func redeemCoupon(database *mongo.Database, code, userID string) (*entity.Coupon, error) {
+	var coupon entity.Coupon
+
+	filter := bson.M{
+		"_id":        strings.ToUpper(strings.TrimSpace(code)),
+		"user_id":    userID,
+		"used_at":    bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+	update := bson.M{"$set": bson.M{"used_at": time.Now()}}
+
+	err := db.GetCollection(database, "coupons").FindOneAndUpdate(context.Background(), filter, update).Decode(&coupon)
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("%w: %s cannot be used", ErrInvalidCoupon, code)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &coupon, nil
+}
+
+// restoreCoupon makes a redeemed coupon usable again when the order it was redeemed for fails
+func restoreCoupon(database *mongo.Database, code string) error {
+	update := bson.M{"$unset": bson.M{"used_at": ""}}
+	_, err := db.GetCollection(database, "coupons").UpdateOne(context.Background(), bson.M{"_id": code}, update)
+	return err
+}
@@ -0,0 +1,411 @@
+package repository
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrInvalidDigital       = errors.New("invalid digital product")
+	ErrLocationRequired     = errors.New("a delivery location is required for products that are shipped")
+	ErrInvalidSignature     = errors.New("invalid download link")
+	ErrDownloadExpired      = errors.New("download link has expired")
+	ErrDownloadLimitReached = errors.New("download limit reached")
+	ErrDigitalOrder         = errors.New("digital orders are delivered when they are paid for")
+)
+
+const (
+	defaultDownloadLimit = 5
+	defaultLinkExpiry    = 72 * time.Hour
+)
+
+// PrepareDigital checks the delivery settings of a digital product. Digital products hold no stock of their own:
+// files never run out and licence key products are stocked by their key pool.
+func PrepareDigital(product *entity.Product) error {
+	if !product.IsDigital() {
+		product.Digital = nil
+		return nil
+	}
+
+	if product.Digital == nil {
+		return fmt.Errorf("%w: a digital product needs a delivery", ErrInvalidDigital)
+	}
+
+	// The file is attached by uploading it once the product exists
+	product.Digital.FileName = ""
+	product.Quantity = 0
+
+	return nil
+}
+
+// DigitalFilePath returns where the file of a digital product is kept. Files live under DIGITAL_FILES_DIR.
+func DigitalFilePath(productID, fileName string) string {
+	dir := os.Getenv("DIGITAL_FILES_DIR")
+	if dir == "" {
+		dir = "digital_files"
+	}
+
+	return filepath.Join(dir, productID, filepath.Base(fileName))
+}
+
+// SetDigitalFile records the name of the uploaded file of a file product and returns the name it replaced
+func SetDigitalFile(collection *mongo.Collection, productID, fileName string) (string, error) {
+	ctx := context.Background()
+	var before entity.Product
+
+	filter := bson.M{"_id": productID, "type": entity.ProductTypeDigital, "digital.delivery": entity.DeliveryFile}
+	update := bson.M{"$set": bson.M{"digital.file_name": fileName, "last_updated": time.Now()}}
+
+	err := collection.FindOneAndUpdate(ctx, filter, update).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return "", fmt.Errorf("%w: %s is not a file product", ErrInvalidDigital, productID)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return before.Digital.FileName, nil
+}
+
+// AddLicenceKeys adds keys to the pool of a licence key product, skipping keys already in it.
+// It returns the number of keys added.
+func AddLicenceKeys(collection *mongo.Collection, productID string, keys []string) (int64, error) {
+	ctx := context.Background()
+
+	var product entity.Product
+	err := db.GetCollection(collection.Database(), "products").FindOne(ctx, bson.M{"_id": productID}).Decode(&product)
+	if err != nil {
+		return 0, err
+	}
+
+	if !product.IsDigital() || product.Digital == nil || product.Digital.Delivery != entity.DeliveryLicenceKey {
+		return 0, fmt.Errorf("%w: %s does not use licence keys", ErrInvalidDigital, product.Name)
+	}
+
+	var added int64
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+
+		filter := bson.M{"product_id": productID, "key": key}
+		update := bson.M{"$setOnInsert": entity.LicenceKey{
+			ID:        primitive.NewObjectIDFromTimestamp(time.Now()).Hex(),
+			ProductID: productID,
+			Key:       key,
+			CreatedAt: time.Now(),
+		}}
+
+		result, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+		if err != nil {
+			return added, err
+		}
+		added += result.UpsertedCount
+	}
+
+	return added, nil
+}
+
+// GetLicenceKeyStock counts the free and assigned keys in the pool of a product
+func GetLicenceKeyStock(collection *mongo.Collection, productID string) (*entity.LicenceKeyStock, error) {
+	ctx := context.Background()
+
+	available, err := collection.CountDocuments(ctx, bson.M{"product_id": productID, "order_id": ""})
+	if err != nil {
+		return nil, err
+	}
+
+	assigned, err := collection.CountDocuments(ctx, bson.M{"product_id": productID, "order_id": bson.M{"$ne": ""}})
+	if err != nil {
+		return nil, err
+	}
+
+	return &entity.LicenceKeyStock{ProductID: productID, Available: available, Assigned: assigned}, nil
+}
+
+// resolveDigital sets the stock of licence key products to the number of free keys in their pools
+func resolveDigital(database *mongo.Database, products []entity.Product) error {
+	ctx := context.Background()
+
+	var ids []string
+	for _, p := range products {
+		if p.IsDigital() && !p.UnlimitedStock() {
+			ids = append(ids, p.ID)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"product_id": bson.M{"$in": ids}, "order_id": ""}}},
+		{{Key: "$group", Value: bson.M{"_id": "$product_id", "count": bson.M{"$sum": 1}}}},
+	}
+
+	cursor, err := db.GetCollection(database, "licence_keys").Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	available := make(map[string]int64)
+	for cursor.Next(ctx) {
+		var row struct {
+			ID    string `bson:"_id"`
+			Count int64  `bson:"count"`
+		}
+		if err := cursor.Decode(&row); err != nil {
+			return err
+		}
+		available[row.ID] = row.Count
+	}
+
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	for i := range products {
+		if products[i].IsDigital() && !products[i].UnlimitedStock() {
+			products[i].Quantity = available[products[i].ID]
+		}
+	}
+
+	return nil
+}
+
+// fulfilDigitalLine delivers a digital line of an order by assigning it licence keys or granting it a download.
+// Orders are paid for as they are placed, so this runs before the order is saved; undoDigitalLine
+// reverts it when saving fails.
+func fulfilDigitalLine(database *mongo.Database, order *entity.Order, line *entity.OrderLine) error {
+	ctx := context.Background()
+	product := &line.Product
+
+	if product.Digital == nil {
+		return fmt.Errorf("%w: %s has no delivery", ErrInvalidDigital, product.Name)
+	}
+
+	switch product.Digital.Delivery {
+	case entity.DeliveryLicenceKey:
+		keys, err := assignLicenceKeys(database, product, order, int(line.Quantity))
+		if err != nil {
+			return err
+		}
+		line.LicenceKeys = keys
+
+	case entity.DeliveryFile:
+		if product.Digital.FileName == "" {
+			return fmt.Errorf("%w: %s has no file to download yet", ErrInsufficientStock, product.Name)
+		}
+
+		download := entity.Download{
+			ID:        primitive.NewObjectIDFromTimestamp(time.Now()).Hex(),
+			OrderID:   order.ID,
+			UserID:    order.UserID,
+			ProductID: product.ID,
+			FileName:  product.Digital.FileName,
+			Limit:     downloadLimit(product),
+			ExpiresAt: time.Now().Add(linkExpiry(product)),
+			CreatedAt: time.Now(),
+		}
+
+		if _, err := db.GetCollection(database, "downloads").InsertOne(ctx, download); err != nil {
+			return err
+		}
+		line.Downloads = []entity.Download{download}
+		signDownload(&line.Downloads[0])
+	}
+
+	return countOrders(database, product.ID, line.Quantity)
+}
+
+// undoDigitalLine frees the licence keys and removes the downloads of a line whose order could not be saved
+func undoDigitalLine(database *mongo.Database, order *entity.Order, line *entity.OrderLine) {
+	ctx := context.Background()
+
+	releaseLicenceKeys(database, order.ID, line.ProductID)
+	_, _ = db.GetCollection(database, "downloads").DeleteMany(ctx, bson.M{"order_id": order.ID, "product_id": line.ProductID})
+	_ = countOrders(database, line.ProductID, -line.Quantity)
+}
+
+// assignLicenceKeys takes quantity free keys from a product's pool for an order, all of them or none
+func assignLicenceKeys(database *mongo.Database, product *entity.Product, order *entity.Order, quantity int) ([]string, error) {
+	ctx := context.Background()
+	collection := db.GetCollection(database, "licence_keys")
+
+	keys := make([]string, 0, quantity)
+	for i := 0; i < quantity; i++ {
+		var key entity.LicenceKey
+
+		filter := bson.M{"product_id": product.ID, "order_id": ""}
+		update := bson.M{"$set": bson.M{"order_id": order.ID, "user_id": order.UserID, "assigned_at": time.Now()}}
+
+		err := collection.FindOneAndUpdate(ctx, filter, update).Decode(&key)
+		if err != nil {
+			releaseLicenceKeys(database, order.ID, product.ID)
+
+			if err == mongo.ErrNoDocuments {
+				return nil, fmt.Errorf("%w: only %d licence keys of %s left", ErrInsufficientStock, len(keys), product.Name)
+			}
+			return nil, err
+		}
+
+		keys = append(keys, key.Key)
+	}
+
+	return keys, nil
+}
+
+// releaseLicenceKeys returns the keys of a product assigned to an order to the pool
+func releaseLicenceKeys(database *mongo.Database, orderID, productID string) {
+	filter := bson.M{"order_id": orderID, "product_id": productID}
+	update := bson.M{"$set": bson.M{"order_id": "", "user_id": ""}, "$unset": bson.M{"assigned_at": ""}}
+
+	_, _ = db.GetCollection(database, "licence_keys").UpdateMany(context.Background(), filter, update)
+}
+
+func downloadLimit(product *entity.Product) int {
+	if product.Digital != nil && product.Digital.DownloadLimit > 0 {
+		return product.Digital.DownloadLimit
+	}
+
+	limit, err := strconv.Atoi(os.Getenv("DOWNLOAD_LIMIT"))
+	if err != nil || limit < 1 {
+		return defaultDownloadLimit
+	}
+	return limit
+}
+
+func linkExpiry(product *entity.Product) time.Duration {
+	if product.Digital != nil && product.Digital.LinkExpiryHours > 0 {
+		return time.Duration(product.Digital.LinkExpiryHours) * time.Hour
+	}
+
+	expiry, err := time.ParseDuration(os.Getenv("DOWNLOAD_LINK_TTL"))
+	if err != nil || expiry <= 0 {
+		return defaultLinkExpiry
+	}
+	return expiry
+}
+
+// downloadSignature signs a download and its expiry with DOWNLOAD_SIGNING_KEY, or the token secret when it is unset
+func downloadSignature(downloadID string, expires int64) string {
+	key := os.Getenv("DOWNLOAD_SIGNING_KEY")
+	if key == "" {
+		key = os.Getenv("SECRET_KEY")
+	}
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(downloadID + "." + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signDownload sets the signed link of a download. Links are served by the API itself, under API_URL.
+func signDownload(download *entity.Download) {
+	expires := download.ExpiresAt.Unix()
+
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("signature", downloadSignature(download.ID, expires))
+
+	download.URL = strings.TrimSuffix(os.Getenv("API_URL"), "/") + "/downloads/" + download.ID + "?" + query.Encode()
+}
+
+// UseDownload checks the signature of a download link and counts a download against its limit
+func UseDownload(collection *mongo.Collection, downloadID, expires, signature string) (*entity.Download, error) {
+	ctx := context.Background()
+
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || !hmac.Equal([]byte(signature), []byte(downloadSignature(downloadID, expiresAt))) {
+		return nil, ErrInvalidSignature
+	}
+
+	now := time.Now()
+	if now.Unix() >= expiresAt {
+		return nil, ErrDownloadExpired
+	}
+
+	var download entity.Download
+	filter := bson.M{
+		"_id":        downloadID,
+		"expires_at": bson.M{"$gt": now},
+		"$expr":      bson.M{"$lt": bson.A{"$count", "$limit"}},
+	}
+	update := bson.M{"$inc": bson.M{"count": 1}}
+
+	err = collection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&download)
+	if err == mongo.ErrNoDocuments {
+		// Tell a spent link from a missing one
+		if err := collection.FindOne(ctx, bson.M{"_id": downloadID}).Decode(&download); err != nil {
+			return nil, err
+		}
+		if !download.ExpiresAt.After(now) {
+			return nil, ErrDownloadExpired
+		}
+		return nil, ErrDownloadLimitReached
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &download, nil
+}
+
+// GetUserDownloads returns a user's downloads with fresh signed links, newest first
+func GetUserDownloads(collection *mongo.Collection, userID string, offset, limit int) ([]entity.Download, int64, error) {
+	return findDownloads(collection, bson.M{"user_id": userID}, offset, limit)
+}
+
+// getOrderDownloads returns the downloads granted by an order
+func getOrderDownloads(database *mongo.Database, orderID string) ([]entity.Download, error) {
+	downloads, _, err := findDownloads(db.GetCollection(database, "downloads"), bson.M{"order_id": orderID}, 0, 0)
+	return downloads, err
+}
+
+func findDownloads(collection *mongo.Collection, filter bson.M, offset, limit int) ([]entity.Download, int64, error) {
+	ctx := context.Background()
+	var downloads = []entity.Download{}
+
+	length, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	findOptions := options.Find().SetSort(bson.M{"created_at": -1}).SetSkip(int64(offset)).SetLimit(int64(limit))
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var download entity.Download
+		if err := cursor.Decode(&download); err != nil {
+			return nil, -1, err
+		}
+
+		signDownload(&download)
+		downloads = append(downloads, download)
+	}
+
+	return downloads, length, cursor.Err()
+}
@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/Emmrys-Jay/ecommerce-api/currency"
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AddExchangeRate adds an entry to the exchange-rate table. Rates without an effective date apply immediately.
+func AddExchangeRate(collection *mongo.Collection, rate entity.ExchangeRate) (*entity.ExchangeRate, error) {
+	code, err := currency.Normalize(rate.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	rate.ID = primitive.NewObjectIDFromTimestamp(time.Now()).Hex()
+	rate.Currency = code
+	rate.CreatedAt = time.Now()
+	if rate.EffectiveFrom.IsZero() {
+		rate.EffectiveFrom = rate.CreatedAt
+	}
+
+	_, err = collection.InsertOne(context.Background(), rate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rate, nil
+}
+
+// GetExchangeRates lists the exchange-rate table, optionally for a single currency, newest entries first
+func GetExchangeRates(collection *mongo.Collection, code string) ([]entity.ExchangeRate, error) {
+	ctx := context.Background()
+	var rates = []entity.ExchangeRate{}
+
+	filter := bson.M{}
+	if code != "" {
+		filter["currency"] = code
+	}
+
+	sort := bson.D{{Key: "currency", Value: 1}, {Key: "effective_from", Value: -1}}
+
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(sort))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var rate entity.ExchangeRate
+		if err := cursor.Decode(&rate); err != nil {
+			return nil, err
+		}
+		rates = append(rates, rate)
+	}
+
+	return rates, cursor.Err()
+}
+
+func DeleteExchangeRate(collection *mongo.Collection, id string) (*mongo.DeleteResult, error) {
+	ctx := context.Background()
+
+	return collection.DeleteOne(ctx, bson.M{"_id": id})
+}
+
+// LoadRates returns the rate of every currency in effect at the given time, including the base currency
+func LoadRates(database *mongo.Database, at time.Time) (currency.Rates, error) {
+	ctx := context.Background()
+	rates := currency.Rates{currency.Base(): 1}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"effective_from": bson.M{"$lte": at}}}},
+		{{Key: "$sort", Value: bson.M{"effective_from": -1}}},
+		{{Key: "$group", Value: bson.M{"_id": "$currency", "rate": bson.M{"$first": "$rate"}}}},
+	}
+
+	cursor, err := db.GetCollection(database, "exchange_rates").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var entry struct {
+			Currency string  `bson:"_id"`
+			Rate     float64 `bson:"rate"`
+		}
+		if err := cursor.Decode(&entry); err != nil {
+			return nil, err
+		}
+
+		// The base currency is always worth exactly one unit of itself
+		if entry.Currency != currency.Base() {
+			rates[entry.Currency] = entry.Rate
+		}
+	}
+
+	return rates, cursor.Err()
+}
+
+// ProductCurrency returns the currency a product's price is set in
+func ProductCurrency(product *entity.Product) string {
+	if code, err := currency.Normalize(product.Currency); err == nil {
+		return code
+	}
+	return currency.Base()
+}
+
+// ConvertProductPrice returns the display prices of a product in the given currency.
+// An explicit price for the currency wins over the exchange rate, with any running sale applied proportionally.
+func ConvertProductPrice(rates currency.Rates, product *entity.Product, code string) (*entity.Display, error) {
+	var rate float64
+	var err error
+
+	if explicit, ok := product.Prices[code]; ok && explicit > 0 && product.Price > 0 {
+		rate = explicit / product.Price
+	} else {
+		rate, err = rates.Rate(ProductCurrency(product), code)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	effective := product.EffectivePrice
+	if effective == 0 {
+		effective = product.Price
+	}
+
+	return &entity.Display{
+		Currency:          code,
+		Rate:              rate,
+		Price:             currency.Round(product.Price*rate, code),
+		EffectivePrice:    currency.Round(effective*rate, code),
+		LowestPrice30Days: currency.Round(product.LowestPrice30Days*rate, code),
+	}, nil
+}
+
+// ApplyDisplayCurrency converts the prices of products to the given currency using the rates in effect now
+func ApplyDisplayCurrency(database *mongo.Database, products []entity.Product, code string) error {
+	rates, err := LoadRates(database, time.Now())
+	if err != nil {
+		return err
+	}
+
+	for i := range products {
+		display, err := ConvertProductPrice(rates, &products[i], code)
+		if err != nil {
+			return err
+		}
+		products[i].Display = display
+	}
+
+	return nil
+}
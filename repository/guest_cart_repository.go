@@ -0,0 +1,250 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultGuestCartTTL = 30 * 24 * time.Hour
+
+// Rules for a product that is in both a guest cart and the cart it is merged into, chosen with GUEST_CART_MERGE
+const (
+	// mergeSum adds the two quantities
+	mergeSum = "sum"
+	// mergeMax keeps the larger quantity
+	mergeMax = "max"
+	// mergeGuest keeps the guest cart's quantity
+	mergeGuest = "guest"
+	// mergeUser keeps the user's quantity
+	mergeUser = "user"
+)
+
+// CreateGuestCart starts a cart for a shopper who has not signed in
+func CreateGuestCart(collection *mongo.Collection) (*entity.GuestCart, error) {
+	token, err := util.SecureToken(24)
+	if err != nil {
+		return nil, err
+	}
+
+	cart := entity.GuestCart{
+		ID:         primitive.NewObjectIDFromTimestamp(time.Now()).Hex(),
+		Token:      token,
+		CreatedAt:  time.Now(),
+		LastActive: time.Now(),
+	}
+
+	if _, err := collection.InsertOne(context.Background(), cart); err != nil {
+		return nil, err
+	}
+
+	return &cart, nil
+}
+
+// FindGuestCart returns the guest cart behind a token and marks it active. Expired carts are not found.
+func FindGuestCart(collection *mongo.Collection, token string) (*entity.GuestCart, error) {
+	var cart entity.GuestCart
+
+	filter := bson.M{"token": token, "last_active": bson.M{"$gte": time.Now().Add(-GuestCartTTL())}}
+	update := bson.M{"$set": bson.M{"last_active": time.Now()}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err := collection.FindOneAndUpdate(context.Background(), filter, update, opts).Decode(&cart)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cart, nil
+}
+
+// MergeGuestCart moves the lines of a guest cart into a user's cart and removes the guest cart. Products in both
+// are merged by the GUEST_CART_MERGE rule; lines that would break a product's order limits or stock are dropped
+// and reported as skipped. A missing or expired guest cart merges nothing.
+func MergeGuestCart(database *mongo.Database, token, userID string) (*entity.CartMerge, error) {
+	ctx := context.Background()
+	carts := db.GetCollection(database, "guest_carts")
+	cartItems := db.GetCollection(database, "cart")
+	products := db.GetCollection(database, "products")
+
+	var guest entity.GuestCart
+	filter := bson.M{"token": token, "last_active": bson.M{"$gte": time.Now().Add(-GuestCartTTL())}}
+
+	err := carts.FindOne(ctx, filter).Decode(&guest)
+	if err == mongo.ErrNoDocuments {
+		return &entity.CartMerge{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lines, _, err := GetUserCartItems(cartItems, guest.OwnerID(), 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// What the guest held is free for the user to take over
+	if err := ReleaseReservations(database, guest.OwnerID()); err != nil {
+		return nil, err
+	}
+
+	rule := guestMergeRule()
+	merge := &entity.CartMerge{}
+
+	for _, line := range lines {
+		var existing entity.CartItem
+		lineFilter := bson.M{"user_id": userID, "product_id": line.ProductID}
+
+		err := cartItems.FindOne(ctx, lineFilter).Decode(&existing)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+
+		product, err := FindOneProduct(products, line.ProductID)
+		if err == mongo.ErrNoDocuments {
+			merge.Skipped = append(merge.Skipped, line.ProductID)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if err := applyReservations(database, userID, product); err != nil {
+			return nil, err
+		}
+
+		quantity := mergeQuantity(rule, existing.Quantity, line.Quantity)
+		if err := ValidateQuantity(product, quantity); err != nil {
+			if IsQuantityError(err) {
+				merge.Skipped = append(merge.Skipped, line.ProductID)
+				continue
+			}
+			return nil, err
+		}
+
+		update := bson.M{
+			"$set": bson.M{"quantity": quantity, "product": *product, "last_updated": time.Now()},
+			"$setOnInsert": bson.M{
+				"_id":        primitive.NewObjectIDFromTimestamp(time.Now()).Hex(),
+				"date_added": line.DateAdded,
+				"unit_price": line.UnitPrice,
+			},
+		}
+
+		if _, err := cartItems.UpdateOne(ctx, lineFilter, update, options.Update().SetUpsert(true)); err != nil {
+			return nil, err
+		}
+
+		if err := holdFlashSale(database, userID, product, quantity); err != nil {
+			return nil, err
+		}
+		merge.Merged++
+	}
+
+	if _, err := cartItems.DeleteMany(ctx, bson.M{"user_id": guest.OwnerID()}); err != nil {
+		return nil, err
+	}
+
+	if _, err := carts.DeleteOne(ctx, bson.M{"_id": guest.ID}); err != nil {
+		return nil, err
+	}
+
+	return merge, nil
+}
+
+// mergeQuantity works out the quantity of a product in both carts by rule. The user has none when it is only
+// in the guest cart.
+func mergeQuantity(rule string, user, guest int64) int64 {
+	switch {
+	case user == 0:
+		return guest
+	case rule == mergeMax && guest > user:
+		return guest
+	case rule == mergeMax, rule == mergeUser:
+		return user
+	case rule == mergeGuest:
+		return guest
+	default:
+		return user + guest
+	}
+}
+
+func guestMergeRule() string {
+	switch rule := os.Getenv("GUEST_CART_MERGE"); rule {
+	case mergeMax, mergeGuest, mergeUser:
+		return rule
+	default:
+		return mergeSum
+	}
+}
+
+// GuestCartTTL is how long a guest cart lasts without being used, set with GUEST_CART_TTL
+func GuestCartTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("GUEST_CART_TTL"))
+	if err != nil || ttl <= 0 {
+		return defaultGuestCartTTL
+	}
+	return ttl
+}
+
+// ExpireGuestCarts removes guest carts that have not been used within their TTL, along with their lines.
+// It returns the number of carts removed.
+func ExpireGuestCarts(database *mongo.Database) (int64, error) {
+	ctx := context.Background()
+	carts := db.GetCollection(database, "guest_carts")
+
+	filter := bson.M{"last_active": bson.M{"$lt": time.Now().Add(-GuestCartTTL())}}
+
+	cursor, err := carts.Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	var expired []entity.GuestCart
+	if err := cursor.All(ctx, &expired); err != nil {
+		return 0, err
+	}
+
+	if len(expired) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]string, 0, len(expired))
+	owners := make([]string, 0, len(expired))
+	for _, c := range expired {
+		ids = append(ids, c.ID)
+		owners = append(owners, c.OwnerID())
+	}
+
+	_, err = db.GetCollection(database, "cart").DeleteMany(ctx, bson.M{"user_id": bson.M{"$in": owners}})
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := carts.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return 0, err
+	}
+
+	return result.DeletedCount, nil
+}
+
+// RunGuestCartSweeper removes expired guest carts every interval until the process exits
+func RunGuestCartSweeper(database *mongo.Database, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := ExpireGuestCarts(database); err != nil {
+			log.Printf("expiring guest carts: %v", err)
+		}
+	}
+}
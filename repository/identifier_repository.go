@@ -0,0 +1,293 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	// ErrSlugTaken is returned when a slug is already used by another product
+	ErrSlugTaken = errors.New("slug is already used by another product")
+	// ErrSKUTaken is returned when a SKU is already used by another product
+	ErrSKUTaken = errors.New("sku is already used by another product")
+	// ErrInvalidSlug is returned when a slug has no url safe characters
+	ErrInvalidSlug = errors.New("slug must contain letters or digits")
+)
+
+// AssignProductIdentifiers gives every product without a slug or SKU a unique generated one, and checks
+// the ones that were supplied are not taken. Products in the slice are also kept unique among themselves.
+func AssignProductIdentifiers(collection *mongo.Collection, products []entity.Product) error {
+	slugs := make(map[string]bool)
+	skus := make(map[string]bool)
+
+	for i := range products {
+		p := &products[i]
+
+		if p.Slug == "" {
+			slug, err := uniqueSlug(collection, p.ID, util.Slugify(p.Name), slugs)
+			if err != nil {
+				return err
+			}
+			p.Slug = slug
+		} else {
+			p.Slug = util.Slugify(p.Slug)
+			if p.Slug == "" {
+				return ErrInvalidSlug
+			}
+
+			taken, err := slugTaken(collection, p.ID, p.Slug)
+			if err != nil {
+				return err
+			}
+			if taken || slugs[p.Slug] {
+				return fmt.Errorf("%w: %s", ErrSlugTaken, p.Slug)
+			}
+		}
+		slugs[p.Slug] = true
+
+		if p.SKU == "" {
+			sku, err := uniqueSKU(collection, p.Category, skus)
+			if err != nil {
+				return err
+			}
+			p.SKU = sku
+		} else {
+			p.SKU = strings.TrimSpace(p.SKU)
+
+			taken, err := skuTaken(collection, p.ID, p.SKU)
+			if err != nil {
+				return err
+			}
+			if taken || skus[p.SKU] {
+				return fmt.Errorf("%w: %s", ErrSKUTaken, p.SKU)
+			}
+		}
+		skus[p.SKU] = true
+	}
+
+	return nil
+}
+
+// uniqueSlug returns base, or base with the lowest numeric suffix that is not taken
+func uniqueSlug(collection *mongo.Collection, productID, base string, reserved map[string]bool) (string, error) {
+	if base == "" {
+		base = "product"
+	}
+
+	slug := base
+	for n := 2; ; n++ {
+		taken, err := slugTaken(collection, productID, slug)
+		if err != nil {
+			return "", err
+		}
+
+		if !taken && !reserved[slug] {
+			return slug, nil
+		}
+
+		slug = fmt.Sprintf("%s-%d", base, n)
+	}
+}
+
+// slugTaken reports whether a product other than productID uses slug, now or as a redirect
+func slugTaken(collection *mongo.Collection, productID, slug string) (bool, error) {
+	filter := bson.M{
+		"_id": bson.M{"$ne": productID},
+		"$or": []bson.M{
+			{"slug": slug},
+			{"previous_slugs": slug},
+		},
+	}
+
+	count, err := collection.CountDocuments(context.Background(), filter)
+	return count > 0, err
+}
+
+// uniqueSKU generates a random SKU prefixed with the first letters of the product category, e.g. "ELE-3FA9C1"
+func uniqueSKU(collection *mongo.Collection, category string, reserved map[string]bool) (string, error) {
+	prefix := strings.ToUpper(strings.ReplaceAll(util.Slugify(category), "-", ""))
+	if len(prefix) > 3 {
+		prefix = prefix[:3]
+	}
+	if prefix == "" {
+		prefix = "PRD"
+	}
+
+	for {
+		token, err := util.SecureToken(3)
+		if err != nil {
+			return "", err
+		}
+
+		sku := prefix + "-" + strings.ToUpper(token)
+		taken, err := skuTaken(collection, "", sku)
+		if err != nil {
+			return "", err
+		}
+
+		if !taken && !reserved[sku] {
+			return sku, nil
+		}
+	}
+}
+
+func skuTaken(collection *mongo.Collection, productID, sku string) (bool, error) {
+	filter := bson.M{"_id": bson.M{"$ne": productID}, "sku": sku}
+
+	count, err := collection.CountDocuments(context.Background(), filter)
+	return count > 0, err
+}
+
+// renameSlug moves a product to a new slug, keeping the old one as a redirect.
+// A slug the product used before is taken back off its redirects.
+func renameSlug(product *entity.Product, oldSlug string) {
+	previous := make([]string, 0, len(product.PreviousSlugs)+1)
+	for _, s := range product.PreviousSlugs {
+		if s != product.Slug && s != oldSlug {
+			previous = append(previous, s)
+		}
+	}
+
+	if oldSlug != "" && oldSlug != product.Slug {
+		previous = append(previous, oldSlug)
+	}
+
+	product.PreviousSlugs = previous
+}
+
+// FindProductBySlug returns the product with slug. When slug is one the product was renamed from,
+// the product is returned with redirected set, so callers can send shoppers to its current slug.
+func FindProductBySlug(collection *mongo.Collection, slug string) (*entity.Product, bool, error) {
+	ctx := context.Background()
+	var product entity.Product
+
+	err := collection.FindOne(ctx, bson.M{"slug": slug}).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		err = collection.FindOne(ctx, bson.M{"previous_slugs": slug}).Decode(&product)
+		if err != nil {
+			return nil, false, err
+		}
+
+		return &product, true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := resolveProductPricing(collection.Database(), &product); err != nil {
+		return nil, false, err
+	}
+
+	return &product, false, nil
+}
+
+// FindProductBySKU returns the product with sku
+func FindProductBySKU(collection *mongo.Collection, sku string) (*entity.Product, error) {
+	ctx := context.Background()
+	var product entity.Product
+
+	err := collection.FindOne(ctx, bson.M{"sku": sku}).Decode(&product)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := resolveProductPricing(collection.Database(), &product); err != nil {
+		return nil, err
+	}
+
+	return &product, nil
+}
+
+// UpdateProductIdentifiers changes the slug and/or SKU of a product. Empty values are left unchanged.
+func UpdateProductIdentifiers(collection *mongo.Collection, id, slug, sku string) (*entity.Product, error) {
+	ctx := context.Background()
+
+	product, err := FindOneProduct(collection, id)
+	if err != nil {
+		return nil, err
+	}
+
+	oldSlug := product.Slug
+	if slug != "" {
+		product.Slug = util.Slugify(slug)
+		if product.Slug == "" {
+			return nil, ErrInvalidSlug
+		}
+
+		taken, err := slugTaken(collection, id, product.Slug)
+		if err != nil {
+			return nil, err
+		}
+		if taken {
+			return nil, ErrSlugTaken
+		}
+
+		renameSlug(product, oldSlug)
+	}
+
+	if sku != "" {
+		product.SKU = strings.TrimSpace(sku)
+
+		taken, err := skuTaken(collection, id, product.SKU)
+		if err != nil {
+			return nil, err
+		}
+		if taken {
+			return nil, ErrSKUTaken
+		}
+	}
+
+	update := bson.M{"$set": bson.M{"slug": product.Slug, "sku": product.SKU, "previous_slugs": product.PreviousSlugs}}
+
+	_, err = collection.UpdateByID(ctx, id, update)
+	if err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+// BackfillProductIdentifiers generates slugs and SKUs for products created before they existed
+func BackfillProductIdentifiers(database *mongo.Database) (int, error) {
+	ctx := context.Background()
+	collection := db.GetCollection(database, "products")
+
+	filter := bson.M{
+		"$or": []bson.M{
+			{"slug": bson.M{"$exists": false}},
+			{"sku": bson.M{"$exists": false}},
+		},
+	}
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	var products []entity.Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return 0, err
+	}
+
+	// Assign one at a time so every product sees the identifiers already given to the others
+	for i := range products {
+		if err := AssignProductIdentifiers(collection, products[i:i+1]); err != nil {
+			return i, err
+		}
+
+		update := bson.M{"$set": bson.M{"slug": products[i].Slug, "sku": products[i].SKU}}
+		if _, err := collection.UpdateByID(ctx, products[i].ID, update); err != nil {
+			return i, err
+		}
+	}
+
+	return len(products), nil
+}
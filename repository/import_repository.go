@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func CreateImportJob(collection *mongo.Collection, job entity.ImportJob) (*mongo.InsertOneResult, error) {
+	return collection.InsertOne(context.Background(), job)
+}
+
+func GetImportJob(collection *mongo.Collection, jobID string) (*entity.ImportJob, error) {
+	ctx := context.Background()
+	var job entity.ImportJob
+
+	err := collection.FindOne(ctx, bson.M{"_id": jobID}).Decode(&job)
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// SaveImportJob replaces the stored import job with its current progress
+func SaveImportJob(collection *mongo.Collection, job *entity.ImportJob) error {
+	ctx := context.Background()
+
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": job.ID}, job)
+	return err
+}
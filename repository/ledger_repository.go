@@ -0,0 +1,346 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrInvalidAdjustment is returned for a stock adjustment with a missing or unsuitable reason, delta or location
+var ErrInvalidAdjustment = errors.New("invalid stock adjustment")
+
+// recordStockMovement appends an entry to the stock ledger. Entries are never changed or removed.
+func recordStockMovement(database *mongo.Database, movement entity.StockMovement) error {
+	if movement.Delta == 0 {
+		return nil
+	}
+
+	movement.ID = primitive.NewObjectIDFromTimestamp(time.Now()).Hex()
+	movement.CreatedAt = time.Now()
+
+	_, err := db.GetCollection(database, "stock_ledger").InsertOne(context.Background(), movement)
+	return err
+}
+
+// recordAllocations records the stock an order took, one entry per product and location
+func recordAllocations(database *mongo.Database, allocations []entity.StockAllocation, actor, orderID string) error {
+	for _, a := range allocations {
+		err := recordStockMovement(database, entity.StockMovement{
+			ProductID:   a.ProductID,
+			LocationID:  a.LocationID,
+			Delta:       -a.Quantity,
+			Reason:      entity.StockSale,
+			Actor:       actor,
+			ReferenceID: orderID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RecordInitialStock records the stock new products are created with as a restock
+func RecordInitialStock(database *mongo.Database, products []entity.Product, actor, referenceID string) error {
+	for _, p := range products {
+		if p.IsBundle() || p.IsDigital() {
+			continue
+		}
+
+		err := recordStockMovement(database, entity.StockMovement{
+			ProductID:   p.ID,
+			Delta:       p.Quantity,
+			Reason:      entity.StockRestock,
+			Actor:       actor,
+			ReferenceID: referenceID,
+			Note:        "initial stock",
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AdjustStock changes the stock of a product by hand, e.g. for a delivery, a return or damaged goods.
+// Restocks and returns add stock and damage removes it; plain adjustments go either way. Products kept
+// by location are adjusted at one of their locations.
+func AdjustStock(database *mongo.Database, productID string, adjustment entity.StockMovement) (*entity.ProductStock, error) {
+	ctx := context.Background()
+	products := db.GetCollection(database, "products")
+
+	if err := checkAdjustment(adjustment); err != nil {
+		return nil, err
+	}
+
+	product, err := GetStoredProduct(products, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	if product.IsBundle() {
+		return nil, fmt.Errorf("%w: update the stock of its components instead", ErrInvalidBundle)
+	}
+	if product.IsDigital() {
+		return nil, fmt.Errorf("%w: digital products hold no stock", ErrInvalidDigital)
+	}
+
+	byLocation, err := hasLocationStock(database, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	delta := adjustment.Delta
+	filter := bson.M{"_id": productID}
+
+	switch {
+	case byLocation && adjustment.LocationID == "":
+		return nil, fmt.Errorf("%w: the stock of %s is kept by location, give a location_id", ErrInvalidAdjustment, product.Name)
+	case !byLocation && adjustment.LocationID != "":
+		return nil, fmt.Errorf("%w: %s is not stocked by location yet, set its stock at a location first", ErrInvalidAdjustment, product.Name)
+	case byLocation:
+		if err := adjustLocationStock(database, productID, adjustment.LocationID, delta); err != nil {
+			return nil, err
+		}
+	case delta < 0:
+		filter["quantity"] = bson.M{"$gte": -delta}
+	}
+
+	var before entity.Product
+	update := bson.M{"$inc": bson.M{"quantity": delta}, "$set": bson.M{"last_updated": time.Now()}}
+
+	err = products.FindOneAndUpdate(ctx, filter, update).Decode(&before)
+	if err != nil && byLocation {
+		// Put the location back so it still adds up to the product's stock
+		locationFilter := bson.M{"product_id": productID, "location_id": adjustment.LocationID}
+		_, _ = db.GetCollection(database, "location_stock").UpdateOne(ctx, locationFilter, bson.M{"$inc": bson.M{"quantity": -delta}})
+	}
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("%w: %s has only %d left", ErrInsufficientStock, product.Name, product.Quantity)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	adjustment.ProductID = productID
+	if err := recordStockMovement(database, adjustment); err != nil {
+		return nil, err
+	}
+
+	if err := checkLowStock(database, withStockTaken(&before, -delta), before.Quantity); err != nil {
+		return nil, err
+	}
+
+	if before.Quantity <= 0 && before.Quantity+delta > 0 {
+		notifyProductChanged(productID)
+	}
+
+	return GetProductStock(database, productID)
+}
+
+// checkAdjustment checks the reason of a stock adjustment suits its delta
+func checkAdjustment(adjustment entity.StockMovement) error {
+	switch {
+	case adjustment.Delta == 0:
+		return fmt.Errorf("%w: delta cannot be zero", ErrInvalidAdjustment)
+	case adjustment.Reason == entity.StockRestock || adjustment.Reason == entity.StockReturn:
+		if adjustment.Delta < 0 {
+			return fmt.Errorf("%w: a %s adds stock", ErrInvalidAdjustment, adjustment.Reason)
+		}
+	case adjustment.Reason == entity.StockDamage:
+		if adjustment.Delta > 0 {
+			return fmt.Errorf("%w: damage removes stock", ErrInvalidAdjustment)
+		}
+	case adjustment.Reason != entity.StockAdjustment:
+		return fmt.Errorf("%w: reason must be restock, return, adjustment or damage", ErrInvalidAdjustment)
+	}
+
+	return nil
+}
+
+// adjustLocationStock adds delta to the stock of a product at a location. Stock can be added to a location
+// that has not held the product before.
+func adjustLocationStock(database *mongo.Database, productID, locationID string, delta int64) error {
+	ctx := context.Background()
+
+	err := db.GetCollection(database, "stock_locations").FindOne(ctx, bson.M{"_id": locationID}).Err()
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"product_id": productID, "location_id": locationID}
+	update := bson.M{"$inc": bson.M{"quantity": delta}, "$set": bson.M{"updated_at": time.Now()}}
+	opts := options.Update()
+
+	if delta < 0 {
+		filter["quantity"] = bson.M{"$gte": -delta}
+	} else {
+		update["$setOnInsert"] = bson.M{"_id": primitive.NewObjectIDFromTimestamp(time.Now()).Hex()}
+		opts.SetUpsert(true)
+	}
+
+	result, err := db.GetCollection(database, "location_stock").UpdateOne(ctx, filter, update, opts)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 && result.UpsertedCount == 0 {
+		return fmt.Errorf("%w: the location does not hold %d", ErrInsufficientStock, -delta)
+	}
+
+	return nil
+}
+
+// GetStockMovements returns the ledger entries of a product, newest first
+func GetStockMovements(collection *mongo.Collection, productID string, offset, limit int) ([]entity.StockMovement, int64, error) {
+	ctx := context.Background()
+	var movements = []entity.StockMovement{}
+
+	filter := bson.M{"product_id": productID}
+
+	length, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	findOptions := options.Find().SetSort(bson.M{"created_at": -1}).SetSkip(int64(offset)).SetLimit(int64(limit))
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	if err := cursor.All(ctx, &movements); err != nil {
+		return nil, -1, err
+	}
+
+	return movements, length, nil
+}
+
+// GetStockReconciliation compares the stock of every product that holds stock with the sum of its ledger entries.
+// Unless all is set only products whose stock and ledger disagree are returned.
+func GetStockReconciliation(collection *mongo.Collection, all bool, offset, limit int) ([]entity.StockReconciliation, int64, error) {
+	ctx := context.Background()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"type": bson.M{"$nin": []string{entity.ProductTypeBundle, entity.ProductTypeDigital}}}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from": "stock_ledger",
+			"let":  bson.M{"product_id": "$_id"},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{"$product_id", "$$product_id"}}}},
+				bson.M{"$group": bson.M{"_id": nil, "total": bson.M{"$sum": "$delta"}}},
+			},
+			"as": "ledger",
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"name":         1,
+			"quantity":     1,
+			"ledger_total": bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$ledger.total", 0}}, 0}},
+		}}},
+		{{Key: "$addFields", Value: bson.M{"difference": bson.M{"$subtract": bson.A{"$quantity", "$ledger_total"}}}}},
+	}
+
+	if !all {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"difference": bson.M{"$ne": 0}}}})
+	}
+
+	pipeline = append(pipeline, bson.D{{Key: "$facet", Value: bson.M{
+		"results": bson.A{
+			bson.M{"$sort": bson.M{"_id": 1}},
+			bson.M{"$skip": offset},
+			bson.M{"$limit": limit},
+		},
+		"total": bson.A{bson.M{"$count": "count"}},
+	}}})
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	var page []struct {
+		Results []entity.StockReconciliation `bson:"results"`
+		Total   []struct {
+			Count int64 `bson:"count"`
+		} `bson:"total"`
+	}
+	if err := cursor.All(ctx, &page); err != nil {
+		return nil, -1, err
+	}
+
+	var rows = []entity.StockReconciliation{}
+	var length int64
+	if len(page) > 0 {
+		rows = append(rows, page[0].Results...)
+		if len(page[0].Total) > 0 {
+			length = page[0].Total[0].Count
+		}
+	}
+
+	return rows, length, nil
+}
+
+// OpenStockLedger records the stock of products that have no ledger entries yet as an opening balance, so stock
+// held before the ledger existed reconciles. It returns the number of products opened.
+func OpenStockLedger(database *mongo.Database) (int64, error) {
+	ctx := context.Background()
+	ledger := db.GetCollection(database, "stock_ledger")
+
+	filter := bson.M{"type": bson.M{"$nin": []string{entity.ProductTypeBundle, entity.ProductTypeDigital}}}
+	cursor, err := db.GetCollection(database, "products").Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var opened int64
+	for cursor.Next(ctx) {
+		var product entity.Product
+		if err := cursor.Decode(&product); err != nil {
+			return opened, err
+		}
+
+		count, err := ledger.CountDocuments(ctx, bson.M{"product_id": product.ID}, options.Count().SetLimit(1))
+		if err != nil {
+			return opened, err
+		}
+		if count > 0 {
+			continue
+		}
+
+		rows, err := findLocationStock(database, product.ID)
+		if err != nil {
+			return opened, err
+		}
+
+		movements := []entity.StockMovement{{ProductID: product.ID, Delta: product.Quantity}}
+		if len(rows) > 0 {
+			movements = movements[:0]
+			for _, r := range rows {
+				movements = append(movements, entity.StockMovement{ProductID: product.ID, LocationID: r.LocationID, Delta: r.Quantity})
+			}
+		}
+
+		for _, m := range movements {
+			m.Reason = entity.StockAdjustment
+			m.Note = "opening balance"
+			if err := recordStockMovement(database, m); err != nil {
+				return opened, err
+			}
+		}
+		opened++
+	}
+
+	return opened, cursor.Err()
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	// ErrNotForSale is returned when a draft, archived or not yet published product is added to a cart or ordered
+	ErrNotForSale = errors.New("product is not for sale")
+	// ErrInvalidStatus is returned for an unknown product status
+	ErrInvalidStatus = errors.New("invalid product status")
+)
+
+// PrepareStatus schedules a product that is given a future publish time but no status.
+// Products without either are published straight away.
+func PrepareStatus(product *entity.Product) {
+	if product.Status == "" && product.PublishAt.After(time.Now()) {
+		product.Status = entity.ProductScheduled
+	}
+}
+
+// ParseStatuses checks a list of statuses, where "all" stands for every status
+func ParseStatuses(statuses []string) ([]string, error) {
+	for _, s := range statuses {
+		if s == "all" {
+			return entity.ProductStatuses, nil
+		}
+
+		valid := false
+		for _, status := range entity.ProductStatuses {
+			valid = valid || s == status
+		}
+		if !valid {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidStatus, s)
+		}
+	}
+
+	return statuses, nil
+}
+
+// statusCondition matches products in any of statuses at the given time. Scheduled products whose publish time
+// has passed count as published. With no statuses it matches the products live in the storefront.
+func statusCondition(statuses []string, at time.Time) bson.M {
+	if len(statuses) == 0 {
+		statuses = []string{entity.ProductPublished}
+	}
+
+	var conditions []bson.M
+	for _, status := range statuses {
+		switch status {
+		case entity.ProductPublished:
+			conditions = append(conditions,
+				bson.M{"status": bson.M{"$in": bson.A{nil, entity.ProductPublished}}},
+				bson.M{"status": entity.ProductScheduled, "publish_at": bson.M{"$lte": at}},
+			)
+		case entity.ProductScheduled:
+			conditions = append(conditions, bson.M{"status": entity.ProductScheduled, "publish_at": bson.M{"$gt": at}})
+		default:
+			conditions = append(conditions, bson.M{"status": status})
+		}
+	}
+
+	return bson.M{"$or": conditions}
+}
+
+// PublishScheduledProducts marks scheduled products whose publish time has passed as published.
+// They are already shown from their publish time on; this keeps their stored status accurate.
+func PublishScheduledProducts(collection *mongo.Collection) (int64, error) {
+	now := time.Now()
+
+	filter := bson.M{"status": entity.ProductScheduled, "publish_at": bson.M{"$lte": now}}
+	update := bson.M{"$set": bson.M{"status": entity.ProductPublished, "last_updated": now}}
+
+	result, err := collection.UpdateMany(context.Background(), filter, update)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// RunProductPublisher publishes scheduled products every interval until the process exits
+func RunProductPublisher(database *mongo.Database, interval time.Duration) {
+	collection := db.GetCollection(database, "products")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := PublishScheduledProducts(collection); err != nil {
+			log.Printf("publishing scheduled products: %v", err)
+		}
+	}
+}
@@ -0,0 +1,438 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrLocationInUse is returned when a location that still holds stock is deleted
+	ErrLocationInUse = errors.New("location still holds stock")
+	// ErrStockByLocation is returned when the total stock of a product that is kept by location is set directly
+	ErrStockByLocation = errors.New("stock of this product is kept by location, set it per location instead")
+)
+
+// Allocation strategies, chosen with STOCK_ALLOCATION
+const (
+	// allocateNearest takes stock from the nearest locations first, splitting an order between them when needed
+	allocateNearest = "nearest"
+	// allocateSingle prefers the nearest location that can ship the whole order alone, and only splits when none can
+	allocateSingle = "single"
+)
+
+// CreateLocation adds a stock location
+func CreateLocation(collection *mongo.Collection, location entity.StockLocation) (*entity.StockLocation, error) {
+	location.ID = primitive.NewObjectIDFromTimestamp(time.Now()).Hex()
+	location.CreatedAt = time.Now()
+	location.UpdatedAt = location.CreatedAt
+
+	if _, err := collection.InsertOne(context.Background(), location); err != nil {
+		return nil, err
+	}
+
+	return &location, nil
+}
+
+// GetLocations returns every stock location by name
+func GetLocations(collection *mongo.Collection) ([]entity.StockLocation, error) {
+	ctx := context.Background()
+	var locations = []entity.StockLocation{}
+
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cursor.All(ctx, &locations); err != nil {
+		return nil, err
+	}
+
+	return locations, nil
+}
+
+// UpdateLocation replaces the details of a stock location
+func UpdateLocation(collection *mongo.Collection, id string, location entity.StockLocation) (*entity.StockLocation, error) {
+	var updated entity.StockLocation
+
+	update := bson.M{"$set": bson.M{
+		"name":         location.Name,
+		"country":      location.Country,
+		"state":        location.State,
+		"city_or_town": location.CityOrTown,
+		"priority":     location.Priority,
+		"updated_at":   time.Now(),
+	}}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := collection.FindOneAndUpdate(context.Background(), bson.M{"_id": id}, update, opts).Decode(&updated)
+	if err != nil {
+		return nil, err
+	}
+
+	return &updated, nil
+}
+
+// DeleteLocation removes a stock location that no longer holds any stock
+func DeleteLocation(collection *mongo.Collection, id string) (*mongo.DeleteResult, error) {
+	ctx := context.Background()
+	stock := db.GetCollection(collection.Database(), "location_stock")
+
+	held, err := stock.CountDocuments(ctx, bson.M{"location_id": id, "quantity": bson.M{"$gt": 0}})
+	if err != nil {
+		return nil, err
+	}
+	if held > 0 {
+		return nil, ErrLocationInUse
+	}
+
+	if _, err := stock.DeleteMany(ctx, bson.M{"location_id": id}); err != nil {
+		return nil, err
+	}
+
+	return collection.DeleteOne(ctx, bson.M{"_id": id})
+}
+
+// GetProductStock returns the stock of a product at every location that has held it
+func GetProductStock(database *mongo.Database, productID string) (*entity.ProductStock, error) {
+	product, err := GetStoredProduct(db.GetCollection(database, "products"), productID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := findLocationStock(database, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	locations, err := findLocations(database, rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range rows {
+		rows[i].LocationName = locations[rows[i].LocationID].Name
+	}
+
+	return &entity.ProductStock{ProductID: productID, Total: product.Quantity, Locations: rows}, nil
+}
+
+// SetLocationStock sets the stock of a product at a location. Once a product has stock at any location,
+// its quantity is the sum of its location stock, so the first location set replaces the quantity it had before.
+// The change is recorded in the ledger against actor as an adjustment.
+func SetLocationStock(database *mongo.Database, productID, locationID string, quantity int64, actor string) (*entity.ProductStock, error) {
+	ctx := context.Background()
+	products := db.GetCollection(database, "products")
+	stock := db.GetCollection(database, "location_stock")
+
+	if quantity < 0 {
+		return nil, ErrInvalidQuantity
+	}
+
+	product, err := GetStoredProduct(products, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	if product.IsBundle() {
+		return nil, fmt.Errorf("%w: update the stock of its components instead", ErrInvalidBundle)
+	}
+	if product.IsDigital() {
+		return nil, fmt.Errorf("%w: digital products hold no stock", ErrInvalidDigital)
+	}
+
+	err = db.GetCollection(database, "stock_locations").FindOne(ctx, bson.M{"_id": locationID}).Err()
+	if err != nil {
+		return nil, err
+	}
+
+	byLocation, err := hasLocationStock(database, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	var before entity.LocationStock
+	filter := bson.M{"product_id": productID, "location_id": locationID}
+	update := bson.M{
+		"$set":         bson.M{"quantity": quantity, "updated_at": time.Now()},
+		"$setOnInsert": bson.M{"_id": primitive.NewObjectIDFromTimestamp(time.Now()).Hex()},
+	}
+
+	err = stock.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetUpsert(true)).Decode(&before)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	productUpdate := bson.M{"$inc": bson.M{"quantity": quantity - before.Quantity}, "$set": bson.M{"last_updated": time.Now()}}
+	if !byLocation {
+		productUpdate = bson.M{"$set": bson.M{"quantity": quantity, "last_updated": time.Now()}}
+	}
+
+	if err := products.FindOneAndUpdate(ctx, bson.M{"_id": productID}, productUpdate).Decode(product); err != nil {
+		return nil, err
+	}
+
+	oldQuantity := product.Quantity
+	if byLocation {
+		product.Quantity += quantity - before.Quantity
+	} else {
+		product.Quantity = quantity
+	}
+
+	movements := []entity.StockMovement{
+		{ProductID: productID, LocationID: locationID, Delta: quantity - before.Quantity, Note: "location stock set"},
+	}
+	if !byLocation {
+		// The stock held before moves into the location stock
+		movements = append(movements, entity.StockMovement{ProductID: productID, Delta: -oldQuantity, Note: "moved to location stock"})
+	}
+
+	for _, m := range movements {
+		m.Reason = entity.StockAdjustment
+		m.Actor = actor
+		if err := recordStockMovement(database, m); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkLowStock(database, product, oldQuantity); err != nil {
+		return nil, err
+	}
+
+	if oldQuantity <= 0 && product.Quantity > 0 {
+		notifyProductChanged(productID)
+	}
+
+	return GetProductStock(database, productID)
+}
+
+// hasLocationStock reports whether the stock of a product is kept by location
+func hasLocationStock(database *mongo.Database, productID string) (bool, error) {
+	count, err := db.GetCollection(database, "location_stock").CountDocuments(context.Background(), bson.M{"product_id": productID})
+	return count > 0, err
+}
+
+func findLocationStock(database *mongo.Database, productID string) ([]entity.LocationStock, error) {
+	ctx := context.Background()
+	var rows = []entity.LocationStock{}
+
+	cursor, err := db.GetCollection(database, "location_stock").Find(ctx, bson.M{"product_id": productID})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// findLocations returns the locations of stock rows keyed by ID
+func findLocations(database *mongo.Database, rows []entity.LocationStock) (map[string]entity.StockLocation, error) {
+	ctx := context.Background()
+
+	ids := make([]string, 0, len(rows))
+	for _, r := range rows {
+		ids = append(ids, r.LocationID)
+	}
+
+	cursor, err := db.GetCollection(database, "stock_locations").Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+
+	var locations []entity.StockLocation
+	if err := cursor.All(ctx, &locations); err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]entity.StockLocation, len(locations))
+	for _, l := range locations {
+		byID[l.ID] = l
+	}
+
+	return byID, nil
+}
+
+func allocationStrategy() string {
+	if os.Getenv("STOCK_ALLOCATION") == allocateSingle {
+		return allocateSingle
+	}
+	return allocateNearest
+}
+
+// distance ranks how far a location is from a delivery address: 0 for the same state, 1 for the same country, 2 otherwise
+func distance(location entity.StockLocation, destination entity.Location) int {
+	if !strings.EqualFold(location.Country, destination.Country) {
+		return 2
+	}
+	if location.State != "" && strings.EqualFold(location.State, destination.State) {
+		return 0
+	}
+	return 1
+}
+
+// planAllocation decides how much of quantity to take from each location, nearest first.
+// It returns nil when the locations do not hold enough between them.
+func planAllocation(productID string, rows []entity.LocationStock, locations map[string]entity.StockLocation,
+	quantity int64, destination entity.Location, strategy string) []entity.StockAllocation {
+
+	var candidates []entity.LocationStock
+	for _, r := range rows {
+		if _, ok := locations[r.LocationID]; ok && r.Quantity > 0 {
+			candidates = append(candidates, r)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := locations[candidates[i].LocationID], locations[candidates[j].LocationID]
+		if da, dB := distance(a, destination), distance(b, destination); da != dB {
+			return da < dB
+		}
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		return candidates[i].Quantity > candidates[j].Quantity
+	})
+
+	if strategy == allocateSingle {
+		for _, c := range candidates {
+			if c.Quantity >= quantity {
+				return []entity.StockAllocation{{ProductID: productID, LocationID: c.LocationID, Quantity: quantity}}
+			}
+		}
+	}
+
+	var plan []entity.StockAllocation
+	remaining := quantity
+	for _, c := range candidates {
+		if remaining == 0 {
+			break
+		}
+
+		take := c.Quantity
+		if take > remaining {
+			take = remaining
+		}
+
+		plan = append(plan, entity.StockAllocation{ProductID: productID, LocationID: c.LocationID, Quantity: take})
+		remaining -= take
+	}
+
+	if remaining > 0 {
+		return nil
+	}
+
+	return plan
+}
+
+// allocateStock takes quantity of a product's stock for an order shipping to destination, and returns where it was taken from.
+// Products kept by location are allocated by the STOCK_ALLOCATION strategy; other products lose it from their quantity.
+// When it fails nothing has been taken.
+func allocateStock(database *mongo.Database, productID string, quantity int64, destination entity.Location) ([]entity.StockAllocation, error) {
+	ctx := context.Background()
+	products := db.GetCollection(database, "products")
+	stock := db.GetCollection(database, "location_stock")
+
+	rows, err := findLocationStock(database, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		before, err := takeStock(products, productID, quantity)
+		if err != nil {
+			return nil, err
+		}
+
+		warnLowStock(database, withStockTaken(before, quantity), before.Quantity)
+		return []entity.StockAllocation{{ProductID: productID, Quantity: quantity}}, nil
+	}
+
+	locations, err := findLocations(database, rows)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := planAllocation(productID, rows, locations, quantity, destination, allocationStrategy())
+	if plan == nil {
+		return nil, ErrInsufficientStock
+	}
+
+	for i, a := range plan {
+		filter := bson.M{"product_id": productID, "location_id": a.LocationID, "quantity": bson.M{"$gte": a.Quantity}}
+		update := bson.M{"$inc": bson.M{"quantity": -a.Quantity}, "$set": bson.M{"updated_at": time.Now()}}
+
+		result, err := stock.UpdateOne(ctx, filter, update)
+		if err == nil && result.MatchedCount == 0 {
+			// Another order took the stock since the plan was made
+			err = ErrInsufficientStock
+		}
+		if err != nil {
+			// Only the location rows have been taken from so far
+			for _, taken := range plan[:i] {
+				filter := bson.M{"product_id": productID, "location_id": taken.LocationID}
+				_, _ = stock.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"quantity": taken.Quantity}})
+			}
+			return nil, err
+		}
+	}
+
+	var before entity.Product
+	update := bson.M{"$inc": bson.M{"quantity": -quantity}, "$set": bson.M{"last_updated": time.Now()}}
+	if err := products.FindOneAndUpdate(ctx, bson.M{"_id": productID}, update).Decode(&before); err != nil {
+		// The locations were taken from but the product was not, so only they are put back
+		for _, taken := range plan {
+			filter := bson.M{"product_id": productID, "location_id": taken.LocationID}
+			_, _ = stock.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"quantity": taken.Quantity}})
+		}
+		return nil, err
+	}
+
+	warnLowStock(database, withStockTaken(&before, quantity), before.Quantity)
+	return plan, nil
+}
+
+// warnLowStock raises a low stock alert for stock taken by an order. The stock is already gone, so a failure to
+// write the alert is only logged rather than failing the order.
+func warnLowStock(database *mongo.Database, product *entity.Product, oldQuantity int64) {
+	if err := checkLowStock(database, product, oldQuantity); err != nil {
+		log.Printf("could not check the stock of %s: %v", product.ID, err)
+	}
+}
+
+// withStockTaken returns a copy of product with quantity taken off its stock
+func withStockTaken(product *entity.Product, quantity int64) *entity.Product {
+	p := *product
+	p.Quantity -= quantity
+	return &p
+}
+
+// releaseStock puts allocated stock back where it was taken from, e.g. when its order could not be saved
+func releaseStock(database *mongo.Database, allocations []entity.StockAllocation) {
+	ctx := context.Background()
+	products := db.GetCollection(database, "products")
+	stock := db.GetCollection(database, "location_stock")
+
+	for _, a := range allocations {
+		if a.LocationID != "" {
+			filter := bson.M{"product_id": a.ProductID, "location_id": a.LocationID}
+			_, _ = stock.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"quantity": a.Quantity}})
+		}
+
+		_, _ = products.UpdateByID(ctx, a.ProductID, bson.M{"$inc": bson.M{"quantity": a.Quantity}})
+	}
+}
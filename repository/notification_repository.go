@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/notification"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	sendersOnce     sync.Once
+	externalSenders map[string]notification.Sender
+)
+
+// inAppSender stores messages in the notifications collection for the user to read in the store
+type inAppSender struct {
+	collection *mongo.Collection
+}
+
+func (s inAppSender) Send(ctx context.Context, to notification.Recipient, msg notification.Message) error {
+	n := entity.Notification{
+		ID:        primitive.NewObjectIDFromTimestamp(time.Now()).Hex(),
+		UserID:    to.UserID,
+		Subject:   msg.Subject,
+		Body:      msg.Body,
+		Link:      msg.Link,
+		CreatedAt: time.Now(),
+	}
+
+	_, err := s.collection.InsertOne(ctx, n)
+	return err
+}
+
+func senderFor(database *mongo.Database, channel string) notification.Sender {
+	sendersOnce.Do(func() {
+		externalSenders = map[string]notification.Sender{
+			notification.ChannelEmail: notification.NewEmailSender(),
+			notification.ChannelSMS:   notification.NewSMSSender(),
+		}
+	})
+
+	if channel == notification.ChannelInApp {
+		return inAppSender{collection: db.GetCollection(database, "notifications")}
+	}
+
+	return externalSenders[channel]
+}
+
+// NotifyUser sends msg to a user on every channel they have enabled. Every channel is tried even if one fails.
+func NotifyUser(database *mongo.Database, userID string, msg notification.Message) error {
+	ctx := context.Background()
+
+	user, err := GetUser(db.GetCollection(database, "users"), userID)
+	if err != nil {
+		return err
+	}
+
+	to := notification.Recipient{
+		UserID:       user.ID,
+		Fullname:     user.Fullname,
+		Email:        user.Email,
+		MobileNumber: user.MobileNumber,
+	}
+
+	channels := user.NotificationChannels
+	if len(channels) == 0 {
+		channels = notification.DefaultChannels
+	}
+
+	var failed []string
+	for _, channel := range channels {
+		sender := senderFor(database, channel)
+		if sender == nil {
+			continue
+		}
+
+		if err := sender.Send(ctx, to, msg); err != nil {
+			log.Printf("could not notify user %s by %s: %v", userID, channel, err)
+			failed = append(failed, channel)
+		}
+	}
+
+	if len(failed) == len(channels) && len(failed) > 0 {
+		return fmt.Errorf("could not notify user on %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// storeLink returns a deep link into the store, prefixed with STORE_URL when it is set
+func storeLink(path string) string {
+	return strings.TrimSuffix(os.Getenv("STORE_URL"), "/") + path
+}
+
+// SetNotificationChannels sets the channels a user is notified on
+func SetNotificationChannels(collection *mongo.Collection, userID string, channels []string) error {
+	for _, c := range channels {
+		if !notification.IsChannel(c) {
+			return fmt.Errorf("%w: %s", notification.ErrUnknownChannel, c)
+		}
+	}
+
+	update := bson.M{"$set": bson.M{"notification_channels": channels, "last_updated": time.Now()}}
+
+	result, err := collection.UpdateByID(context.Background(), userID, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// GetUserNotifications returns a page of a user's in-app notifications, newest first
+func GetUserNotifications(collection *mongo.Collection, userID string, offset, limit int) ([]entity.Notification, int64, error) {
+	ctx := context.Background()
+	var notifications = []entity.Notification{}
+
+	filter := bson.M{"user_id": userID}
+
+	length, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	findOptions := options.Find().SetSort(bson.M{"created_at": -1}).SetSkip(int64(offset)).SetLimit(int64(limit))
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &notifications); err != nil {
+		return nil, -1, err
+	}
+
+	return notifications, length, nil
+}
+
+// MarkNotificationRead marks one of a user's notifications as read
+func MarkNotificationRead(collection *mongo.Collection, id, userID string) (*mongo.UpdateResult, error) {
+	filter := bson.M{"_id": id, "user_id": userID}
+	update := bson.M{"$set": bson.M{"read": true}}
+
+	return collection.UpdateOne(context.Background(), filter, update)
+}
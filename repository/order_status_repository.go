@@ -0,0 +1,292 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrIllegalTransition is returned when an order is moved to a status it cannot reach from its current one
+var ErrIllegalTransition = errors.New("illegal order status change")
+
+// orderTransitions lists the statuses each status of an order can move to. Cancelled and refunded orders are final.
+var orderTransitions = map[string][]string{
+	entity.OrderPendingPayment: {entity.OrderPaid, entity.OrderCancelled},
+	entity.OrderPaid:           {entity.OrderProcessing, entity.OrderShipped, entity.OrderDelivered, entity.OrderCancelled, entity.OrderRefunded},
+	entity.OrderProcessing:     {entity.OrderShipped, entity.OrderDelivered, entity.OrderCancelled, entity.OrderRefunded},
+	entity.OrderShipped:        {entity.OrderDelivered, entity.OrderRefunded},
+	entity.OrderDelivered:      {entity.OrderCompleted, entity.OrderRefunded},
+	entity.OrderCompleted:      {entity.OrderRefunded},
+}
+
+// CanTransition reports whether an order may move from one status to another
+func CanTransition(from, to string) bool {
+	for _, s := range orderTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// advanceOrder moves an order that has not been saved yet to status, recording who moved it
+func advanceOrder(order *entity.Order, status, actor, note string) error {
+	if order.Status != "" && !CanTransition(order.Status, status) {
+		return fmt.Errorf("%w: a %s order cannot be %s", ErrIllegalTransition, order.Status, status)
+	}
+
+	order.History = append(order.History, entity.OrderTransition{
+		From:  order.Status,
+		To:    status,
+		Actor: actor,
+		Note:  note,
+		At:    time.Now(),
+	})
+	order.Status = status
+
+	return nil
+}
+
+// TransitionOrder moves a saved order to status for actor. The move only happens when the order still has the
+// status it was read with, so two requests cannot both move it. Orders cancelled or refunded before they were
+// shipped have their stock put back; when that fails, moving the order to the same status again finishes it.
+func TransitionOrder(database *mongo.Database, orderID, status, actor, note string) (*entity.Order, error) {
+	ctx := context.Background()
+	collection := db.GetCollection(database, "orders")
+
+	var order entity.Order
+	if err := collection.FindOne(ctx, bson.M{"_id": orderID}).Decode(&order); err != nil {
+		return nil, err
+	}
+
+	if order.Status == status && order.RestockPending {
+		return &order, restockOrder(database, &order, actor)
+	}
+
+	from := order.Status
+	if err := advanceOrder(&order, status, actor, note); err != nil {
+		return nil, err
+	}
+
+	set := bson.M{"status": status}
+
+	// The stock is owed back from the moment the status changes, so a failed restock can be retried
+	restock := (status == entity.OrderCancelled || status == entity.OrderRefunded) && !hasShipped(from)
+	if restock {
+		set["restock_pending"] = true
+		set["restocked"] = 0
+	}
+
+	filter := bson.M{"_id": orderID, "status": from}
+	update := bson.M{
+		"$set":  set,
+		"$push": bson.M{"history": order.History[len(order.History)-1]},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("%w: the order is no longer %s", ErrIllegalTransition, from)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if restock {
+		if err := restockOrder(database, &order, actor); err != nil {
+			return nil, err
+		}
+	}
+
+	return &order, nil
+}
+
+// GetOrderTimeline returns the status changes of an order. Orders of other users are not found unless
+// userID is empty.
+func GetOrderTimeline(collection *mongo.Collection, orderID, userID string) ([]entity.OrderTransition, error) {
+	filter := bson.M{"_id": orderID}
+	if userID != "" {
+		filter["user_id"] = userID
+	}
+
+	var order entity.Order
+	opts := options.FindOne().SetProjection(bson.M{"history": 1})
+
+	if err := collection.FindOne(context.Background(), filter, opts).Decode(&order); err != nil {
+		return nil, err
+	}
+
+	if order.History == nil {
+		return []entity.OrderTransition{}, nil
+	}
+
+	return order.History, nil
+}
+
+// hasShipped reports whether an order in status has left the store
+func hasShipped(status string) bool {
+	switch status {
+	case entity.OrderPendingPayment, entity.OrderPaid, entity.OrderProcessing:
+		return false
+	default:
+		return true
+	}
+}
+
+// restockOrder puts the stock taken by an order back where it came from and records it as returned, carrying on
+// from the allocations already returned. Each allocation is claimed on the order before its stock is returned,
+// so two attempts never return the same one. A cancelled order gives its coupon back. Licence keys and downloads
+// of digital lines were delivered with the order and are not taken back.
+func restockOrder(database *mongo.Database, order *entity.Order, actor string) error {
+	ctx := context.Background()
+	orders := db.GetCollection(database, "orders")
+
+	for i := order.Restocked; i < len(order.Allocations); i++ {
+		a := order.Allocations[i]
+
+		claim := bson.M{"_id": order.ID, "restock_pending": true, "restocked": i}
+		result, err := orders.UpdateOne(ctx, claim, bson.M{"$inc": bson.M{"restocked": 1}})
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return fmt.Errorf("%w: the stock of order %s is already being put back", ErrIllegalTransition, order.ID)
+		}
+
+		if err := returnAllocation(database, a); err != nil {
+			unclaim := bson.M{"_id": order.ID, "restocked": i + 1}
+			_, _ = orders.UpdateOne(ctx, unclaim, bson.M{"$inc": bson.M{"restocked": -1}})
+			return err
+		}
+		order.Restocked = i + 1
+
+		err = recordStockMovement(database, entity.StockMovement{
+			ProductID:   a.ProductID,
+			LocationID:  a.LocationID,
+			Delta:       a.Quantity,
+			Reason:      entity.StockReturn,
+			Actor:       actor,
+			ReferenceID: order.ID,
+			Note:        "order " + order.Status,
+		})
+		if err != nil {
+			log.Printf("could not record the stock returned by order %s: %v", order.ID, err)
+		}
+	}
+
+	// Only the attempt that clears the marker settles the order counts and the coupon
+	update := bson.M{"$unset": bson.M{"restock_pending": "", "restocked": ""}}
+	result, err := orders.UpdateOne(ctx, bson.M{"_id": order.ID, "restock_pending": true}, update)
+	if err != nil {
+		return err
+	}
+	order.RestockPending, order.Restocked = false, 0
+
+	if result.MatchedCount == 0 {
+		return nil
+	}
+
+	for _, line := range order.Lines {
+		if line.Product.IsDigital() {
+			continue
+		}
+
+		if err := countOrders(database, line.ProductID, -line.Quantity); err != nil {
+			log.Printf("could not uncount order %s of %s: %v", order.ID, line.ProductID, err)
+		}
+	}
+
+	if order.Status == entity.OrderCancelled && order.CouponCode != "" {
+		if err := restoreCoupon(database, order.CouponCode); err != nil {
+			log.Printf("could not restore coupon %s: %v", order.CouponCode, err)
+		}
+	}
+
+	return nil
+}
+
+// returnAllocation puts stock taken from a location, or from the product when it is not kept by location, back
+func returnAllocation(database *mongo.Database, a entity.StockAllocation) error {
+	ctx := context.Background()
+
+	stock := db.GetCollection(database, "location_stock")
+	filter := bson.M{"product_id": a.ProductID, "location_id": a.LocationID}
+
+	if a.LocationID != "" {
+		if _, err := stock.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"quantity": a.Quantity}}); err != nil {
+			return err
+		}
+	}
+
+	_, err := db.GetCollection(database, "products").UpdateByID(ctx, a.ProductID, bson.M{"$inc": bson.M{"quantity": a.Quantity}})
+	if err != nil && a.LocationID != "" {
+		// Take it off the location again so it still adds up to the product's stock
+		_, _ = stock.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"quantity": -a.Quantity}})
+	}
+	return err
+}
+
+// MigrateOrderStatuses gives orders saved with delivered and received flags a status: received orders are
+// completed, delivered ones delivered and the rest paid. It returns the number of orders migrated.
+func MigrateOrderStatuses(database *mongo.Database) (int64, error) {
+	ctx := context.Background()
+	collection := db.GetCollection(database, "orders")
+
+	cursor, err := collection.Find(ctx, bson.M{"status": bson.M{"$exists": false}})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var migrated int64
+	for cursor.Next(ctx) {
+		var legacy struct {
+			ID            string    `bson:"_id"`
+			CreatedAt     time.Time `bson:"created_at"`
+			IsDelivered   bool      `bson:"is_delivered"`
+			TimeDelivered time.Time `bson:"time_delivered"`
+			IsReceived    bool      `bson:"is_received"`
+		}
+		if err := cursor.Decode(&legacy); err != nil {
+			return migrated, err
+		}
+
+		status, at := entity.OrderPaid, legacy.CreatedAt
+		switch {
+		case legacy.IsReceived:
+			status = entity.OrderCompleted
+		case legacy.IsDelivered:
+			status = entity.OrderDelivered
+		}
+		if legacy.IsDelivered && !legacy.TimeDelivered.IsZero() {
+			at = legacy.TimeDelivered
+		}
+
+		history := []entity.OrderTransition{{
+			To:    status,
+			Actor: entity.OrderActorSystem,
+			Note:  "status set from the order's delivered and received flags",
+			At:    at,
+		}}
+
+		update := bson.M{
+			"$set":   bson.M{"status": status, "history": history},
+			"$unset": bson.M{"is_delivered": "", "time_delivered": "", "is_received": ""},
+		}
+
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": legacy.ID}, update); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+
+	return migrated, cursor.Err()
+}
@@ -2,8 +2,11 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"log"
 	"time"
 
+	"github.com/Emmrys-Jay/ecommerce-api/currency"
 	"github.com/Emmrys-Jay/ecommerce-api/db"
 	"github.com/Emmrys-Jay/ecommerce-api/entity"
 	"go.mongodb.org/mongo-driver/bson"
@@ -12,49 +15,235 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// ErrEmptyCart is returned when a cart with no lines is ordered
+var ErrEmptyCart = errors.New("cart is empty")
+
+// OrderProductDirectly places an order of a single product. The order is priced in orderCurrency,
+// or the store base currency when it is empty, using the exchange rates in effect now.
 func OrderProductDirectly(
 	collection *mongo.Collection, location *entity.Location, quantity int,
-	userID, fullname, productID, paymentMethod string) (*mongo.InsertOneResult, string, error) {
+	userID, fullname, productID, paymentMethod, orderCurrency string) (*entity.Order, error) {
+
+	var deliveryLocation entity.Location
+	if location != nil {
+		deliveryLocation = *location
+	}
+
+	items := []entity.CartItem{{ProductID: productID, Quantity: int64(quantity)}}
+
+	order, err := placeOrder(collection, deliveryLocation, items, userID, fullname, paymentMethod, orderCurrency, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	recoverAbandonedCart(collection.Database(), userID, order)
+
+	return order, nil
+}
+
+// placeOrder places one order of every item, taking coupon off every line when one is given. Either every line
+// gets its stock or, when one cannot, the order is not placed.
+func placeOrder(
+	collection *mongo.Collection, location entity.Location, items []entity.CartItem,
+	userID, fullname, paymentMethod, orderCurrency string, coupon *entity.Coupon) (*entity.Order, error) {
 
 	ctx := context.Background()
+	database := collection.Database()
 
-	productsCollection := db.GetCollection(collection.Database(), "products")
+	if orderCurrency == "" {
+		orderCurrency = currency.Base()
+	}
 
-	product, err := FindOneProduct(productsCollection, productID)
+	rates, err := LoadRates(database, time.Now())
 	if err != nil {
-		return nil, "", err
+		return nil, err
 	}
 
 	order := entity.Order{
 		ID:               primitive.NewObjectIDFromTimestamp(time.Now()).Hex(),
 		UserID:           userID,
 		FullName:         fullname,
-		DeliveryLocation: *location,
-		Product:          *product,
-		ProductQuantity:  quantity,
-		IsDelivered:      false,
+		DeliveryLocation: location,
+		Lines:            make([]entity.OrderLine, 0, len(items)),
+		Currency:         orderCurrency,
+		PaymentMethod:    paymentMethod,
 		CreatedAt:        time.Now(),
 	}
 
-	result, err := collection.InsertOne(ctx, order)
+	// Payment is taken with the order, so it is placed and paid at once
+	_ = advanceOrder(&order, entity.OrderPendingPayment, userID, "order placed")
+	_ = advanceOrder(&order, entity.OrderPaid, userID, "paid by "+paymentMethod)
+
+	if coupon != nil {
+		order.CouponCode = coupon.Code
+	}
+
+	// Check every line first so a bad line does not leave stock taken for the others
+	for _, item := range items {
+		line, err := priceOrderLine(database, rates, userID, item, orderCurrency, coupon)
+		if err != nil {
+			return nil, err
+		}
+		order.Lines = append(order.Lines, *line)
+	}
+
+	if order.Shipped() && location == (entity.Location{}) {
+		return nil, ErrLocationRequired
+	}
+
+	// A bundle takes its stock from its components, all of them or none. A digital line skips shipping
+	// and gets its licence keys or download straight away. Other products are allocated from the stock
+	// locations chosen for the delivery address.
+	for i := range order.Lines {
+		if err := takeLineStock(database, &order, &order.Lines[i]); err != nil {
+			undoOrderStock(database, &order, i)
+			return nil, err
+		}
+	}
+
+	for _, line := range order.Lines {
+		order.Subtotal += currency.Round(line.UnitPrice*float64(line.Quantity), orderCurrency)
+		order.Discount += line.Discount
+	}
+
+	goods := order.Subtotal - order.Discount
+
+	order.Shipping, err = ShippingFee(rates, orderCurrency, goods, order.Shipped())
 	if err != nil {
-		return nil, "", err
+		undoOrderStock(database, &order, len(order.Lines))
+		return nil, err
+	}
+
+	order.Tax = Tax(goods, orderCurrency)
+	order.Subtotal = currency.Round(order.Subtotal, orderCurrency)
+	order.Discount = currency.Round(order.Discount, orderCurrency)
+	order.GrandTotal = currency.Round(goods+order.Shipping+order.Tax, orderCurrency)
+
+	if !order.Shipped() {
+		order.DeliveryLocation = entity.Location{}
+		_ = advanceOrder(&order, entity.OrderDelivered, entity.OrderActorSystem, "digital products delivered")
 	}
 
-	// Update product quantity left and number of orders of that product
-	_, err = UpdateProduct(productsCollection, productID, 0.00, -int64(quantity), int64(quantity))
+	if _, err := collection.InsertOne(ctx, order); err != nil {
+		undoOrderStock(database, &order, len(order.Lines))
+		return nil, err
+	}
+
+	// The order is placed once it is saved, so what is left is bookkeeping that must not undo it
+	if err := recordAllocations(database, order.Allocations, userID, order.ID); err != nil {
+		log.Printf("could not record the stock taken by order %s: %v", order.ID, err)
+	}
+
+	for _, line := range order.Lines {
+		// The stock the user held has now been taken for real
+		if err := releaseReservation(database, userID, line.ProductID); err != nil {
+			log.Printf("could not release the hold of order %s on %s: %v", order.ID, line.ProductID, err)
+		}
+
+		if line.Product.IsBundle() || line.Product.IsDigital() {
+			continue
+		}
+
+		if err := countOrders(database, line.ProductID, line.Quantity); err != nil {
+			log.Printf("could not count order %s of %s: %v", order.ID, line.ProductID, err)
+		}
+	}
+
+	return &order, nil
+}
+
+// priceOrderLine checks a product can be ordered in the quantity of item and prices it in orderCurrency
+func priceOrderLine(database *mongo.Database, rates currency.Rates, userID string, item entity.CartItem,
+	orderCurrency string, coupon *entity.Coupon) (*entity.OrderLine, error) {
+
+	product, err := FindOneProduct(db.GetCollection(database, "products"), item.ProductID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Stock other shoppers hold is not for sale, but what this user holds is
+	if err := applyReservations(database, userID, product); err != nil {
+		return nil, err
+	}
+
+	if err := ValidateQuantity(product, item.Quantity); err != nil {
+		return nil, err
+	}
+
+	price, err := ConvertProductPrice(rates, product, orderCurrency)
 	if err != nil {
-		return nil, "", err
+		return nil, err
+	}
+
+	quantity := float64(item.Quantity)
+	discount := (price.Price - price.EffectivePrice) * quantity
+	if coupon != nil {
+		discount += price.EffectivePrice * quantity * coupon.Percent / 100
+	}
+
+	line := &entity.OrderLine{
+		ProductID:    product.ID,
+		Product:      *product,
+		Variant:      product.SKU,
+		Quantity:     item.Quantity,
+		UnitPrice:    price.Price,
+		Discount:     currency.Round(discount, orderCurrency),
+		ExchangeRate: price.Rate,
+	}
+	line.LineTotal = currency.Round(line.UnitPrice*quantity-line.Discount, orderCurrency)
+
+	return line, nil
+}
+
+// takeLineStock takes the stock of a line of an order
+func takeLineStock(database *mongo.Database, order *entity.Order, line *entity.OrderLine) error {
+	switch {
+	case line.Product.IsBundle():
+		allocations, err := decrementBundleStock(database, &line.Product, line.Quantity, order.DeliveryLocation)
+		order.Allocations = append(order.Allocations, allocations...)
+		if err != nil {
+			return err
+		}
+	case line.Product.IsDigital():
+		return fulfilDigitalLine(database, order, line)
+	default:
+		allocations, err := allocateStock(database, line.ProductID, line.Quantity, order.DeliveryLocation)
+		order.Allocations = append(order.Allocations, allocations...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// undoOrderStock puts back the stock taken for the first n lines of an order that could not be placed
+func undoOrderStock(database *mongo.Database, order *entity.Order, n int) {
+	for i := 0; i < n; i++ {
+		line := &order.Lines[i]
+
+		switch {
+		case line.Product.IsBundle():
+			_ = countOrders(database, line.ProductID, -line.Quantity)
+		case line.Product.IsDigital():
+			undoDigitalLine(database, order, line)
+		}
 	}
 
-	return result, product.Name, nil
+	releaseStock(database, order.Allocations)
+	order.Allocations = nil
 }
 
-func GetSingleOrder(collection *mongo.Collection, orderID string) (*entity.Order, error) {
+// GetSingleOrder returns an order with its licence keys and download links. Orders of other users are not found
+// unless userID is empty.
+func GetSingleOrder(collection *mongo.Collection, orderID, userID string) (*entity.Order, error) {
 	ctx := context.Background()
 	var order entity.Order
 
 	filter := bson.M{"_id": orderID}
+	if userID != "" {
+		filter["user_id"] = userID
+	}
 
 	result := collection.FindOne(ctx, filter)
 	if result.Err() != nil {
@@ -66,13 +255,16 @@ func GetSingleOrder(collection *mongo.Collection, orderID string) (*entity.Order
 		return nil, err
 	}
 
+	if err := attachDownloads(collection.Database(), &order); err != nil {
+		return nil, err
+	}
+
 	return &order, nil
 }
 
 func GetOrdersByUser(collection *mongo.Collection, userID string, limit, offset int) ([]entity.Order, int64, error) {
 	ctx := context.Background()
 	var orders = []entity.Order{}
-	var order = entity.Order{}
 
 	filter := bson.M{"user_id": userID}
 
@@ -91,6 +283,7 @@ func GetOrdersByUser(collection *mongo.Collection, userID string, limit, offset
 	}
 
 	for cursor.Next(ctx) {
+		var order entity.Order
 		err := cursor.Decode(&order)
 		if err != nil {
 			return nil, -1, err
@@ -103,7 +296,6 @@ func GetOrdersByUser(collection *mongo.Collection, userID string, limit, offset
 
 func GetAllOrders(collection *mongo.Collection, limit, offset int) ([]entity.Order, int64, error) {
 	ctx := context.Background()
-	var order = entity.Order{}
 	var orders = []entity.Order{}
 
 	filter := bson.M{}
@@ -123,6 +315,7 @@ func GetAllOrders(collection *mongo.Collection, limit, offset int) ([]entity.Ord
 	}
 
 	for cursor.Next(ctx) {
+		var order entity.Order
 		err := cursor.Decode(&order)
 		if err != nil {
 			return nil, -1, err
@@ -133,76 +326,104 @@ func GetAllOrders(collection *mongo.Collection, limit, offset int) ([]entity.Ord
 	return orders, length, nil
 }
 
-func DeliverOrder(collection *mongo.Collection, orderID string) (*mongo.UpdateResult, error) {
-	ctx := context.Background()
-
-	filter := bson.M{"_id": orderID}
-
-	order, err := GetSingleOrder(collection, orderID)
+// DeliverOrder marks an order that had to be shipped as delivered
+func DeliverOrder(database *mongo.Database, orderID, actor string) (*entity.Order, error) {
+	order, err := GetSingleOrder(db.GetCollection(database, "orders"), orderID, "")
 	if err != nil {
 		return nil, err
 	}
 
-	currentTime := time.Now()
+	if !order.Shipped() {
+		return nil, ErrDigitalOrder
+	}
 
-	order.IsDelivered = true
-	order.TimeDelivered = currentTime
+	return TransitionOrder(database, orderID, entity.OrderDelivered, actor, "")
+}
+
+// ReceiveOrder lets a user confirm they received an order of theirs, which completes it
+func ReceiveOrder(database *mongo.Database, userID, orderID string) (*entity.Order, error) {
+	filter := bson.M{"_id": orderID, "user_id": userID}
 
-	result, err := collection.ReplaceOne(ctx, filter, order)
+	count, err := db.GetCollection(database, "orders").CountDocuments(context.Background(), filter)
 	if err != nil {
 		return nil, err
 	}
+	if count == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
 
-	return result, nil
+	return TransitionOrder(database, orderID, entity.OrderCompleted, userID, "received")
 }
 
-func ReceiveOrder(collection *mongo.Collection, username, orderID string) (*mongo.UpdateResult, error) {
-	ctx := context.Background()
+// OrderAllCartItems places one order of every line of a user's cart. A coupon code, when given, is taken off
+// every line.
+func OrderAllCartItems(collection *mongo.Collection, userID, fullname, paymentMethod, orderCurrency, couponCode string, location entity.Location) (*entity.Order, error) {
 
-	filter := bson.M{
-		"$and": []bson.M{
-			{
-				"username": username,
-			},
-			{
-				"_id": orderID,
-			},
-		},
-	}
+	cartCollection := db.GetCollection(collection.Database(), "cart")
 
-	order, err := GetSingleOrder(collection, orderID)
+	cartItems, _, err := GetUserCartItems(cartCollection, userID, 0, 0)
 	if err != nil {
 		return nil, err
 	}
 
-	order.IsReceived = true
+	if len(cartItems) == 0 {
+		return nil, ErrEmptyCart
+	}
+
+	var coupon *entity.Coupon
+	if couponCode != "" {
+		coupon, err = redeemCoupon(collection.Database(), couponCode, userID)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	result, err := collection.ReplaceOne(ctx, filter, order)
+	order, err := placeOrder(collection, location, cartItems, userID, fullname, paymentMethod, orderCurrency, coupon)
 	if err != nil {
+		// The coupon is only spent once something was ordered with it
+		if coupon != nil {
+			if err := restoreCoupon(collection.Database(), coupon.Code); err != nil {
+				log.Printf("could not restore coupon %s: %v", coupon.Code, err)
+			}
+		}
 		return nil, err
 	}
 
-	return result, nil
+	recoverAbandonedCart(collection.Database(), userID, order)
+
+	// Checkout is over, so nothing the user holds is needed any more
+	if err := ReleaseReservations(collection.Database(), userID); err != nil {
+		log.Printf("could not release the holds of user %s after order %s: %v", userID, order.ID, err)
+	}
+
+	return order, nil
 }
 
-func OrderAllCartItems(collection *mongo.Collection, userID, fullname, paymentMethod string, location entity.Location) (int, error) {
+// attachDownloads adds the downloads granted by an order to its digital lines, with fresh signed links
+func attachDownloads(database *mongo.Database, order *entity.Order) error {
+	digital := false
+	for _, l := range order.Lines {
+		digital = digital || l.Product.IsDigital()
+	}
 
-	cartCollection := db.GetCollection(collection.Database(), "cart")
+	if !digital {
+		return nil
+	}
 
-	cartItems, _, err := GetUserCartItems(cartCollection, userID, 0, 0)
+	downloads, err := getOrderDownloads(database, order.ID)
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	for _, val := range cartItems {
-		_, _, err := OrderProductDirectly(collection, &location, int(val.Quantity), userID, fullname, val.Product.ID, paymentMethod)
-		if err != nil {
-			return 0, err
+	for i := range order.Lines {
+		for _, d := range downloads {
+			if d.ProductID == order.Lines[i].ProductID {
+				order.Lines[i].Downloads = append(order.Lines[i].Downloads, d)
+			}
 		}
 	}
 
-	return len(cartItems), nil
-
+	return nil
 }
 
 func DeleteOrder(collection *mongo.Collection, id string) (*mongo.DeleteResult, error) {
@@ -243,3 +464,88 @@ func DeleteAllOrders(collection *mongo.Collection) (*mongo.DeleteResult, error)
 
 	return result, nil
 }
+
+// MigrateSingleProductOrders moves orders saved with one product and its quantity on the order into a single
+// order line, and gives them the order totals. Orders placed before order currencies existed are taken to be
+// in the product currency. It returns the number of orders migrated.
+func MigrateSingleProductOrders(database *mongo.Database) (int64, error) {
+	ctx := context.Background()
+	collection := db.GetCollection(database, "orders")
+
+	filter := bson.M{"product": bson.M{"$exists": true}, "lines": bson.M{"$exists": false}}
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var migrated int64
+	for cursor.Next(ctx) {
+		var legacy struct {
+			ID              string         `bson:"_id"`
+			Product         entity.Product `bson:"product"`
+			ProductQuantity int64          `bson:"product_quantity"`
+			UnitPrice       float64        `bson:"unit_price"`
+			Currency        string         `bson:"currency"`
+			ExchangeRate    float64        `bson:"exchange_rate"`
+			DeliveryFee     float64        `bson:"delivery_fee"`
+			Discount        float64        `bson:"discount"`
+			LicenceKeys     []string       `bson:"licence_keys"`
+		}
+		if err := cursor.Decode(&legacy); err != nil {
+			return migrated, err
+		}
+
+		if legacy.Currency == "" {
+			legacy.Currency = legacy.Product.Currency
+			if legacy.Currency == "" {
+				legacy.Currency = currency.Base()
+			}
+			legacy.UnitPrice = legacy.Product.Price
+			legacy.ExchangeRate = 1
+		}
+
+		code := legacy.Currency
+		subtotal := currency.Round(legacy.UnitPrice*float64(legacy.ProductQuantity), code)
+
+		line := entity.OrderLine{
+			ProductID:    legacy.Product.ID,
+			Product:      legacy.Product,
+			Variant:      legacy.Product.SKU,
+			Quantity:     legacy.ProductQuantity,
+			UnitPrice:    legacy.UnitPrice,
+			Discount:     legacy.Discount,
+			LineTotal:    currency.Round(subtotal-legacy.Discount, code),
+			ExchangeRate: legacy.ExchangeRate,
+			LicenceKeys:  legacy.LicenceKeys,
+		}
+
+		update := bson.M{
+			"$set": bson.M{
+				"lines":       []entity.OrderLine{line},
+				"currency":    code,
+				"subtotal":    subtotal,
+				"shipping":    legacy.DeliveryFee,
+				"tax":         0,
+				"discount":    legacy.Discount,
+				"grand_total": currency.Round(subtotal-legacy.Discount+legacy.DeliveryFee, code),
+			},
+			"$unset": bson.M{
+				"product":          "",
+				"product_quantity": "",
+				"unit_price":       "",
+				"exchange_rate":    "",
+				"delivery_fee":     "",
+				"licence_keys":     "",
+			},
+		}
+
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": legacy.ID}, update); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+
+	return migrated, cursor.Err()
+}
@@ -0,0 +1,220 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// lowestPriceWindow is how far back the lowest recent price of a product looks
+const lowestPriceWindow = 30 * 24 * time.Hour
+
+var (
+	// ErrSaleOverlap is returned when a new sale overlaps an existing sale of the same product
+	ErrSaleOverlap = errors.New("sale overlaps an existing sale of this product")
+	// ErrInvalidSale is returned when a sale ends before it starts or is priced above the product
+	ErrInvalidSale = errors.New("sale must end after it starts and be cheaper than the product price")
+)
+
+// RecordPriceChange adds a base price change of a product to the price history
+func RecordPriceChange(collection *mongo.Collection, productID string, oldPrice, newPrice float64) error {
+	change := entity.PriceChange{
+		ID:        primitive.NewObjectIDFromTimestamp(time.Now()).Hex(),
+		ProductID: productID,
+		OldPrice:  oldPrice,
+		NewPrice:  newPrice,
+		ChangedAt: time.Now(),
+	}
+
+	_, err := collection.InsertOne(context.Background(), change)
+	return err
+}
+
+// GetPriceHistory returns a page of a product's price changes, newest first
+func GetPriceHistory(collection *mongo.Collection, productID string, offset, limit int) ([]entity.PriceChange, int64, error) {
+	ctx := context.Background()
+	var changes = []entity.PriceChange{}
+
+	filter := bson.M{"product_id": productID}
+
+	length, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	findOptions := options.Find().SetSort(bson.M{"changed_at": -1}).SetSkip(int64(offset)).SetLimit(int64(limit))
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var change entity.PriceChange
+		if err := cursor.Decode(&change); err != nil {
+			return nil, -1, err
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, length, nil
+}
+
+// ResolvePricing fills in the effective price, sale flag and lowest price of the last 30 days of products.
+// It reads the price history of all products in a single query.
+func ResolvePricing(database *mongo.Database, products []entity.Product) error {
+	ctx := context.Background()
+	now := time.Now()
+	windowStart := now.Add(-lowestPriceWindow)
+
+	if len(products) == 0 {
+		return nil
+	}
+
+	bundlePrices, err := resolveBundles(database, products)
+	if err != nil {
+		return err
+	}
+
+	if err := resolveDigital(database, products); err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(products))
+	for _, p := range products {
+		ids = append(ids, p.ID)
+	}
+
+	filter := bson.M{
+		"product_id": bson.M{"$in": ids},
+		"changed_at": bson.M{"$gte": windowStart},
+	}
+
+	cursor, err := db.GetCollection(database, "price_history").Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	// Every base price in effect during the window is either the current price or one side of a change in it
+	lowest := make(map[string]float64)
+	for cursor.Next(ctx) {
+		var change entity.PriceChange
+		if err := cursor.Decode(&change); err != nil {
+			return err
+		}
+		lowest[change.ProductID] = minPrice(lowest[change.ProductID], change.OldPrice, change.NewPrice)
+	}
+
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	for i := range products {
+		p := &products[i]
+
+		p.EffectivePrice = p.PriceAt(now)
+		if p.IsBundle() {
+			// Component sales carry over to the bundle, as does a sale on the bundle itself
+			p.EffectivePrice = minPrice(p.EffectivePrice, bundlePrices[p.ID])
+		}
+		p.OnSale = p.EffectivePrice < p.Price
+
+		low := minPrice(lowest[p.ID], p.Price, p.EffectivePrice)
+		for _, sale := range p.Sales {
+			if sale.StartsAt.Before(now) && sale.EndsAt.After(windowStart) {
+				low = minPrice(low, sale.Price)
+			}
+		}
+		p.LowestPrice30Days = low
+	}
+
+	return nil
+}
+
+// resolveProductPricing is a shorthand of ResolvePricing for a single product
+func resolveProductPricing(database *mongo.Database, product *entity.Product) error {
+	products := []entity.Product{*product}
+	if err := ResolvePricing(database, products); err != nil {
+		return err
+	}
+
+	*product = products[0]
+	return nil
+}
+
+// AddProductSale schedules a sale for a product. Sales of a product may not overlap,
+// and sales that ended outside the lowest price window are dropped.
+func AddProductSale(collection *mongo.Collection, productID string, sale entity.Sale) (*entity.Product, error) {
+	ctx := context.Background()
+
+	product, err := FindOneProduct(collection, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !sale.EndsAt.After(sale.StartsAt) || sale.Price >= product.Price {
+		return nil, ErrInvalidSale
+	}
+
+	windowStart := time.Now().Add(-lowestPriceWindow)
+	sales := []entity.Sale{}
+	for _, s := range product.Sales {
+		if s.EndsAt.Before(windowStart) {
+			continue
+		}
+
+		if sale.StartsAt.Before(s.EndsAt) && s.StartsAt.Before(sale.EndsAt) {
+			return nil, ErrSaleOverlap
+		}
+		sales = append(sales, s)
+	}
+
+	sale.ID = primitive.NewObjectIDFromTimestamp(time.Now()).Hex()
+	sale.CreatedAt = time.Now()
+	sales = append(sales, sale)
+
+	update := bson.M{"$set": bson.M{"sales": sales, "last_updated": time.Now()}}
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": productID}, update)
+	if err != nil {
+		return nil, err
+	}
+
+	// A sale that has already started may meet price drop subscriptions, later ones are caught by the sweep
+	if !sale.StartsAt.After(time.Now()) {
+		notifyProductChanged(productID)
+	}
+
+	return FindOneProduct(collection, productID)
+}
+
+// RemoveProductSale removes a scheduled sale from a product
+func RemoveProductSale(collection *mongo.Collection, productID, saleID string) (*mongo.UpdateResult, error) {
+	ctx := context.Background()
+
+	update := bson.M{
+		"$pull": bson.M{"sales": bson.M{"_id": saleID}},
+		"$set":  bson.M{"last_updated": time.Now()},
+	}
+
+	return collection.UpdateOne(ctx, bson.M{"_id": productID}, update)
+}
+
+// minPrice returns the smallest positive price, ignoring unset (zero) values
+func minPrice(prices ...float64) float64 {
+	var low float64
+	for _, p := range prices {
+		if p > 0 && (low == 0 || p < low) {
+			low = p
+		}
+	}
+	return low
+}
@@ -2,9 +2,14 @@ package repository
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/Emmrys-Jay/ecommerce-api/db"
 	"github.com/Emmrys-Jay/ecommerce-api/entity"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -36,10 +41,15 @@ func FindOneProduct(collection *mongo.Collection, productID string) (*entity.Pro
 		return nil, err
 	}
 
+	if err := resolveProductPricing(collection.Database(), &product); err != nil {
+		return nil, err
+	}
+
 	return &product, nil
 }
 
-func FindProducts(collection *mongo.Collection, name string, offset, limit int64) ([]entity.Product, int64, error) {
+// FindProducts returns products whose name, description or tags match name, narrowed by productFilter
+func FindProducts(collection *mongo.Collection, name string, productFilter ProductFilter, offset, limit int64) ([]entity.Product, int64, error) {
 	ctx := context.Background()
 	filter := bson.M{}
 	findOptions := options.Find()
@@ -63,10 +73,23 @@ func FindProducts(collection *mongo.Collection, name string, offset, limit int64
 						},
 					},
 				},
+				{
+					"tags": bson.M{
+						"$regex": primitive.Regex{
+							Pattern: name,
+							Options: "i",
+						},
+					},
+				},
 			},
 		}
 	}
 
+	filter, err := productFilter.apply(filter)
+	if err != nil {
+		return nil, -1, err
+	}
+
 	length, err := collection.CountDocuments(ctx, filter)
 	if err != nil {
 		return nil, -1, err
@@ -88,6 +111,10 @@ func FindProducts(collection *mongo.Collection, name string, offset, limit int64
 		products = append(products, product)
 	}
 
+	if err := ResolvePricing(collection.Database(), products); err != nil {
+		return nil, -1, err
+	}
+
 	return products, length, nil
 }
 
@@ -123,10 +150,32 @@ func UpdateProduct(collection *mongo.Collection, id string, price float64, quant
 		return nil, err
 	}
 
+	// The price and stock of a bundle are worked out from its components
+	if product.IsBundle() && (price != 0 || quantity != 0) {
+		return nil, fmt.Errorf("%w: update the price or stock of its components instead", ErrInvalidBundle)
+	}
+
+	// Files never run out and licence keys are stocked by adding keys to the pool
+	if product.IsDigital() && quantity != 0 {
+		return nil, fmt.Errorf("%w: digital products hold no stock", ErrInvalidDigital)
+	}
+
+	if quantity != 0 {
+		byLocation, err := hasLocationStock(collection.Database(), id)
+		if err != nil {
+			return nil, err
+		}
+		if byLocation {
+			return nil, ErrStockByLocation
+		}
+	}
+
 	filter := bson.M{"_id": id}
+	oldPrice := product.Price
+	oldQuantity := product.Quantity
 
 	if price != 0 {
-		if price <= 0 {
+		if price > 0 {
 			product.Price = price
 			product.LastUpdated = time.Now()
 		}
@@ -147,38 +196,261 @@ func UpdateProduct(collection *mongo.Collection, id string, price float64, quant
 	}
 
 	result, err := collection.ReplaceOne(ctx, filter, product)
+	if err != nil {
+		return nil, err
+	}
+
+	if product.Price != oldPrice {
+		err = RecordPriceChange(db.GetCollection(collection.Database(), "price_history"), id, oldPrice, product.Price)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if product.Quantity != oldQuantity {
+		movement := entity.StockMovement{ProductID: id, Delta: product.Quantity - oldQuantity, Reason: entity.StockAdjustment}
+		if err := recordStockMovement(collection.Database(), movement); err != nil {
+			return result, err
+		}
+
+		err = checkLowStock(collection.Database(), product, oldQuantity)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	// Let the subscription dispatcher tell shoppers who are waiting for a restock or a lower price
+	if (oldQuantity <= 0 && product.Quantity > 0) || product.Price < oldPrice {
+		notifyProductChanged(id)
+	}
 
 	return result, err
 }
 
-func AddProductReview(collection *mongo.Collection, productID string, review entity.Review) (*mongo.UpdateResult, error) {
+// ErrReadOnlyField is returned when a patch sets a product field that does not exist or cannot be edited
+var ErrReadOnlyField = errors.New("field cannot be edited")
+
+// patchableProductFields maps the json name of every product field an admin may patch to its bson name.
+// The type, sales, redirect slugs, ratings and order counts are kept by the store or have their own endpoints.
+var patchableProductFields = map[string]string{
+	"name":                "name",
+	"sku":                 "sku",
+	"slug":                "slug",
+	"price":               "price",
+	"pictures":            "pictures",
+	"videos":              "videos",
+	"currency":            "currency",
+	"quantity":            "quantity",
+	"description":         "description",
+	"category":            "category",
+	"features":            "features",
+	"attributes":          "attributes",
+	"tags":                "tags",
+	"components":          "components",
+	"bundle_discount":     "bundle_discount",
+	"digital":             "digital",
+	"prices":              "prices",
+	"slashed_price":       "slashed_price",
+	"minimum_order":       "minimum_order",
+	"maximum_per_order":   "maximum_per_order",
+	"low_stock_threshold": "low_stock_threshold",
+	"flash_sale":          "flash_sale",
+	"status":              "status",
+	"publish_at":          "publish_at",
+}
+
+// ProductPatchFields checks every member of a product patch may be edited and returns their bson names
+func ProductPatchFields(patch map[string]json.RawMessage) ([]string, error) {
+	fields := make([]string, 0, len(patch))
+	for key := range patch {
+		field, ok := patchableProductFields[key]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrReadOnlyField, key)
+		}
+		fields = append(fields, field)
+	}
+
+	sort.Strings(fields)
+	return fields, nil
+}
+
+// GetStoredProduct returns a product as it is stored, without the prices and stock worked out at read time
+func GetStoredProduct(collection *mongo.Collection, id string) (*entity.Product, error) {
+	var product entity.Product
+
+	err := collection.FindOne(context.Background(), bson.M{"_id": id}).Decode(&product)
+	if err != nil {
+		return nil, err
+	}
+
+	return &product, nil
+}
+
+// SaveProductPatch stores the patched fields of updated, a validated copy of existing with a patch applied.
+// When quantityDelta is set it is added to the stock in the same write rather than quantity being set,
+// and fails with ErrInsufficientStock when it would take the stock below zero. Stock changes are recorded
+// in the ledger against actor. It returns the updated product.
+func SaveProductPatch(collection *mongo.Collection, existing, updated *entity.Product, fields []string, quantityDelta *int64, actor string) (*entity.Product, error) {
 	ctx := context.Background()
 
-	product, err := FindOneProduct(collection, productID)
+	patched := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		patched[f] = true
+	}
+
+	stockChanged := patched["quantity"] || quantityDelta != nil
+
+	if existing.IsBundle() {
+		if patched["price"] || stockChanged {
+			return nil, fmt.Errorf("%w: update the price or stock of its components instead", ErrInvalidBundle)
+		}
+
+		if patched["components"] || patched["bundle_discount"] {
+			if err := PrepareBundle(collection, updated); err != nil {
+				return nil, err
+			}
+			patched["price"], patched["currency"] = true, true
+		}
+	}
+
+	if existing.IsDigital() {
+		if stockChanged {
+			return nil, fmt.Errorf("%w: digital products hold no stock", ErrInvalidDigital)
+		}
+
+		// The file is replaced by uploading a new one
+		if updated.Digital != nil {
+			updated.Digital.FileName = ""
+			if existing.Digital != nil && updated.Digital.Delivery == entity.DeliveryFile {
+				updated.Digital.FileName = existing.Digital.FileName
+			}
+		}
+	}
+
+	if stockChanged {
+		byLocation, err := hasLocationStock(collection.Database(), existing.ID)
+		if err != nil {
+			return nil, err
+		}
+		if byLocation {
+			return nil, ErrStockByLocation
+		}
+	}
+
+	if patched["status"] || patched["publish_at"] {
+		PrepareStatus(updated)
+		patched["status"] = true
+	}
+
+	// A renamed product gets a slug for its new name unless the patch sets one, as on import
+	if updated.Name != existing.Name && !patched["slug"] {
+		updated.Slug = ""
+		patched["slug"] = true
+	}
+
+	if patched["slug"] || patched["sku"] {
+		products := []entity.Product{*updated}
+		if err := AssignProductIdentifiers(collection, products); err != nil {
+			return nil, err
+		}
+		*updated = products[0]
+
+		renameSlug(updated, existing.Slug)
+		patched["slug"], patched["sku"], patched["previous_slugs"] = true, true, true
+	}
+
+	raw, err := bson.Marshal(updated)
 	if err != nil {
 		return nil, err
 	}
 
-	review.CreatedAt = time.Now()
-	product.Reviews = append(product.Reviews, review)
-	product.NoOfReviews++
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
 
-	filter := bson.M{"_id": productID}
+	set := bson.M{"last_updated": time.Now()}
+	unset := bson.M{}
+	for field := range patched {
+		if value, ok := doc[field]; ok {
+			set[field] = value
+		} else {
+			unset[field] = ""
+		}
+	}
 
-	result, err := collection.ReplaceOne(ctx, filter, product)
+	filter := bson.M{"_id": existing.ID}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	if quantityDelta != nil {
+		update["$inc"] = bson.M{"quantity": *quantityDelta}
+		if *quantityDelta < 0 {
+			filter["quantity"] = bson.M{"$gte": -*quantityDelta}
+		}
+	}
+
+	var before entity.Product
+	err = collection.FindOneAndUpdate(ctx, filter, update).Decode(&before)
+	if err == mongo.ErrNoDocuments && quantityDelta != nil {
+		return nil, fmt.Errorf("%w: cannot remove %d from the stock of %s", ErrInsufficientStock, -*quantityDelta, existing.Name)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return result, nil
+	after, err := GetStoredProduct(collection, existing.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if after.Price != before.Price {
+		err = RecordPriceChange(db.GetCollection(collection.Database(), "price_history"), after.ID, before.Price, after.Price)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if stockChanged {
+		delta := updated.Quantity - before.Quantity
+		if quantityDelta != nil {
+			delta = *quantityDelta
+		}
+
+		movement := entity.StockMovement{ProductID: after.ID, Delta: delta, Reason: entity.StockAdjustment, Actor: actor, Note: "product update"}
+		if err := recordStockMovement(collection.Database(), movement); err != nil {
+			return nil, err
+		}
+	}
+
+	if stockChanged && after.Quantity != before.Quantity {
+		if err := checkLowStock(collection.Database(), after, before.Quantity); err != nil {
+			return nil, err
+		}
+	}
+
+	if (stockChanged && before.Quantity <= 0 && after.Quantity > 0) || after.Price < before.Price {
+		notifyProductChanged(after.ID)
+	}
+
+	if err := resolveProductPricing(collection.Database(), after); err != nil {
+		return nil, err
+	}
+
+	return after, nil
 }
 
-func GetProductsByCategory(collection *mongo.Collection, ctgy string, offset, limit int) ([]entity.Product, int64, error) {
+// GetProductsByCategory returns the products in a category, narrowed by productFilter
+func GetProductsByCategory(collection *mongo.Collection, ctgy string, productFilter ProductFilter, offset, limit int) ([]entity.Product, int64, error) {
 	ctx := context.Background()
 	var products = []entity.Product{}
-	var product entity.Product
 
-	filter := bson.M{"category": ctgy}
+	filter, err := productFilter.apply(bson.M{"category": ctgy})
+	if err != nil {
+		return nil, -1, err
+	}
 
 	length, err := collection.CountDocuments(ctx, filter)
 	if err != nil {
@@ -195,6 +467,7 @@ func GetProductsByCategory(collection *mongo.Collection, ctgy string, offset, li
 	defer cursor.Close(context.Background())
 
 	for cursor.Next(ctx) {
+		var product entity.Product
 		err := cursor.Decode(&product)
 		if err != nil {
 			return nil, -1, err
@@ -203,21 +476,24 @@ func GetProductsByCategory(collection *mongo.Collection, ctgy string, offset, li
 		products = append(products, product)
 	}
 
+	if err := ResolvePricing(collection.Database(), products); err != nil {
+		return nil, -1, err
+	}
+
 	return products, length, nil
 }
 
 func GetProductsByReviews(collection *mongo.Collection, offset, limit int) ([]entity.Product, int64, error) {
 	ctx := context.Background()
 	var products = []entity.Product{}
-	var product entity.Product
 
-	filter := bson.M{}
+	filter := statusCondition(nil, time.Now())
 	length, err := collection.CountDocuments(ctx, filter)
 	if err != nil {
 		return nil, -1, err
 	}
 
-	myOptions := options.Find().SetLimit(int64(limit)).SetSkip(int64(offset)).SetSort(bson.M{"noofreviews": -1})
+	myOptions := options.Find().SetLimit(int64(limit)).SetSkip(int64(offset)).SetSort(bson.M{"no_of_reviews": -1})
 
 	cursor, err := collection.Find(ctx, filter, myOptions)
 	if err != nil {
@@ -227,6 +503,7 @@ func GetProductsByReviews(collection *mongo.Collection, offset, limit int) ([]en
 	defer cursor.Close(context.Background())
 
 	for cursor.Next(ctx) {
+		var product entity.Product
 		err := cursor.Decode(&product)
 		if err != nil {
 			return nil, -1, err
@@ -235,5 +512,241 @@ func GetProductsByReviews(collection *mongo.Collection, offset, limit int) ([]en
 		products = append(products, product)
 	}
 
+	if err := ResolvePricing(collection.Database(), products); err != nil {
+		return nil, -1, err
+	}
+
 	return products, length, nil
 }
+
+// importedFields are the product fields an import file may change, by csv column or json key. Everything else
+// on an existing product, such as its reviews, sales and order count, is owned by the store.
+var importedFields = map[string]bool{
+	"name": true, "description": true, "category": true, "price": true, "currency": true, "pictures": true,
+	"videos": true, "features": true, "slashed_price": true, "minimum_order": true, "maximum_per_order": true,
+	"low_stock_threshold": true, "tags": true, "attributes": true, "type": true, "components": true,
+	"bundle_discount": true, "digital": true, "prices": true, "flash_sale": true,
+}
+
+// IsImportedField reports whether an import file may change field of an existing product
+func IsImportedField(field string) bool {
+	return importedFields[field]
+}
+
+// FindImportedProduct returns the existing product an imported one updates, the one with the same SKU
+// (or name when no SKU is given)
+func FindImportedProduct(collection *mongo.Collection, product entity.Product) (*entity.Product, error) {
+	var existing entity.Product
+
+	filter := bson.M{"name": product.Name}
+	if product.SKU != "" {
+		filter = bson.M{"sku": product.SKU}
+	}
+
+	if err := collection.FindOne(context.Background(), filter).Decode(&existing); err != nil {
+		return nil, err
+	}
+
+	return &existing, nil
+}
+
+// UpsertProduct inserts a product or updates the existing product with the same SKU (or name when no SKU is given).
+// An existing product only changes in the fields the import row carries. It reports whether an existing product
+// was updated. Stock changes are recorded in the ledger against actor and referenceID.
+func UpsertProduct(collection *mongo.Collection, product entity.Product, fields []string, actor, referenceID string) (bool, error) {
+	ctx := context.Background()
+
+	existing, err := FindImportedProduct(collection, product)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			return false, err
+		}
+
+		product.ID = primitive.NewObjectIDFromTimestamp(time.Now()).Hex()
+		product.CreatedAt = time.Now()
+		product.LastUpdated = product.CreatedAt
+		product.NumOfOrders = 0
+
+		products := []entity.Product{product}
+		if err := AssignProductIdentifiers(collection, products); err != nil {
+			return false, err
+		}
+
+		if _, err := collection.InsertOne(ctx, products[0]); err != nil {
+			return false, err
+		}
+
+		return false, RecordInitialStock(collection.Database(), products, actor, referenceID)
+	}
+
+	carried := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		carried[f] = true
+	}
+
+	// The file of a digital product is uploaded separately, not imported
+	if product.Digital != nil && existing.Digital != nil && product.Digital.FileName == "" {
+		product.Digital.FileName = existing.Digital.FileName
+	}
+
+	raw, err := bson.Marshal(product)
+	if err != nil {
+		return true, err
+	}
+
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return true, err
+	}
+
+	set := bson.M{"last_updated": time.Now()}
+	unset := bson.M{}
+	for field := range carried {
+		if !importedFields[field] {
+			continue
+		}
+		if v, ok := doc[field]; ok {
+			set[field] = v
+		} else {
+			unset[field] = ""
+		}
+	}
+
+	if product.SKU != "" {
+		set["sku"] = product.SKU
+	}
+
+	// Products are only published, drafted or archived by an import that says so
+	if product.Status != "" {
+		set["status"] = product.Status
+		if product.PublishAt.IsZero() {
+			unset["publish_at"] = ""
+		} else {
+			set["publish_at"] = product.PublishAt
+		}
+	}
+
+	// A renamed product gets a slug for its new name unless the file sets one
+	if product.Slug == "" && product.Name == existing.Name {
+		product.Slug = existing.Slug
+	}
+
+	if product.Slug != existing.Slug {
+		product.ID = existing.ID
+		product.PreviousSlugs = existing.PreviousSlugs
+
+		products := []entity.Product{product}
+		if err := AssignProductIdentifiers(collection, products); err != nil {
+			return true, err
+		}
+		product.Slug = products[0].Slug
+		renameSlug(&product, existing.Slug)
+
+		set["slug"] = product.Slug
+		set["previous_slugs"] = product.PreviousSlugs
+	}
+
+	// Shoppers waiting for the product hear about it once, whether it came back in stock or got cheaper
+	notify := false
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	productType := existing.Type
+	if carried["type"] {
+		productType = product.Type
+	}
+
+	// Stock kept by location is set per location, and bundles and digital products have no stock of their own
+	stocked := carried["quantity"] && productType != entity.ProductTypeBundle && productType != entity.ProductTypeDigital
+	if stocked {
+		byLocation, err := hasLocationStock(collection.Database(), existing.ID)
+		if err != nil {
+			return true, err
+		}
+		stocked = !byLocation
+	}
+
+	if !stocked {
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": existing.ID}, update); err != nil {
+			return true, err
+		}
+	} else {
+		// Orders take stock while the file is imported, so the stock is only set if it is still what was read
+		current := existing.Quantity
+		for attempt := 0; ; attempt++ {
+			update["$inc"] = bson.M{"quantity": product.Quantity - current}
+
+			result, err := collection.UpdateOne(ctx, bson.M{"_id": existing.ID, "quantity": current}, update)
+			if err != nil {
+				return true, err
+			}
+			if result.MatchedCount == 1 {
+				break
+			}
+			if attempt == 4 {
+				return true, fmt.Errorf("the stock of %s kept changing during the import", existing.Name)
+			}
+
+			var latest entity.Product
+			if err := collection.FindOne(ctx, bson.M{"_id": existing.ID}).Decode(&latest); err != nil {
+				return true, err
+			}
+			current = latest.Quantity
+		}
+
+		movement := entity.StockMovement{
+			ProductID:   existing.ID,
+			Delta:       product.Quantity - current,
+			Reason:      entity.StockAdjustment,
+			Actor:       actor,
+			ReferenceID: referenceID,
+			Note:        "import",
+		}
+		if err := recordStockMovement(collection.Database(), movement); err != nil {
+			return true, err
+		}
+
+		notify = current <= 0 && product.Quantity > 0
+	}
+
+	if carried["price"] && product.Price != existing.Price {
+		if err := RecordPriceChange(db.GetCollection(collection.Database(), "price_history"), existing.ID, existing.Price, product.Price); err != nil {
+			return true, err
+		}
+
+		notify = notify || product.Price < existing.Price
+	}
+
+	if notify {
+		notifyProductChanged(existing.ID)
+	}
+
+	return true, nil
+}
+
+// StreamProducts calls fn for every product in the collection without loading them all into memory
+func StreamProducts(collection *mongo.Collection, fn func(entity.Product) error) error {
+	ctx := context.Background()
+
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var product entity.Product
+		if err := cursor.Decode(&product); err != nil {
+			return err
+		}
+
+		if err := fn(product); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
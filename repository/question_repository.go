@@ -0,0 +1,379 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/moderation"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// answersPerQuestion is how many answers are shown with each question in listings
+const answersPerQuestion = 3
+
+var (
+	// ErrNotVerifiedBuyer is returned when a shopper who has not received a product tries to answer a question about it
+	ErrNotVerifiedBuyer = errors.New("only shoppers who have received this product can answer questions about it")
+	// ErrOwnAnswer is returned when a shopper votes on their own answer
+	ErrOwnAnswer = errors.New("you cannot vote on your own answer")
+)
+
+// answerSort orders answers by helpfulness, store answers first among equals
+var answerSort = bson.D{{Key: "helpful_votes", Value: -1}, {Key: "by_admin", Value: -1}, {Key: "created_at", Value: 1}}
+
+// moderateContent runs the content filter over user text and returns its flags and initial moderation status
+func moderateContent(texts ...string) ([]string, string) {
+	filter := moderation.DefaultFilter()
+
+	flags := filter.Check(texts...)
+	if filter.NeedsApproval(flags) {
+		return flags, entity.ModerationPending
+	}
+
+	return flags, entity.ModerationApproved
+}
+
+// CreateQuestion adds a shopper's question to a product
+func CreateQuestion(collection *mongo.Collection, productID, userID, username, body string) (*entity.Question, error) {
+	ctx := context.Background()
+
+	_, err := FindOneProduct(db.GetCollection(collection.Database(), "products"), productID)
+	if err != nil {
+		return nil, err
+	}
+
+	question := entity.Question{
+		ID:        primitive.NewObjectIDFromTimestamp(time.Now()).Hex(),
+		ProductID: productID,
+		UserID:    userID,
+		User:      username,
+		Body:      body,
+		CreatedAt: time.Now(),
+	}
+	question.Flags, question.Status = moderateContent(body)
+
+	_, err = collection.InsertOne(ctx, question)
+	if err != nil {
+		return nil, err
+	}
+
+	return &question, nil
+}
+
+// GetQuestion gets a single question by its ID
+func GetQuestion(collection *mongo.Collection, questionID string) (*entity.Question, error) {
+	var question entity.Question
+
+	err := collection.FindOne(context.Background(), bson.M{"_id": questionID}).Decode(&question)
+	if err != nil {
+		return nil, err
+	}
+
+	return &question, nil
+}
+
+// GetProductQuestions returns a page of a product's approved questions, most answered first, each with its most helpful answers
+func GetProductQuestions(collection *mongo.Collection, productID string, offset, limit int) ([]entity.Question, int64, error) {
+	ctx := context.Background()
+
+	filter := bson.M{"product_id": productID, "status": visibleReviews}
+
+	length, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	questions, err := findQuestions(collection, filter, offset, limit)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	return questions, length, nil
+}
+
+// GetTopQuestions returns up to limit of a product's answered questions, most answered first
+func GetTopQuestions(database *mongo.Database, productID string, limit int) ([]entity.Question, error) {
+	filter := bson.M{"product_id": productID, "status": visibleReviews, "answer_count": bson.M{"$gt": 0}}
+
+	return findQuestions(db.GetCollection(database, "questions"), filter, 0, limit)
+}
+
+func findQuestions(collection *mongo.Collection, filter bson.M, offset, limit int) ([]entity.Question, error) {
+	ctx := context.Background()
+	var questions = []entity.Question{}
+
+	sort := bson.D{{Key: "answer_count", Value: -1}, {Key: "created_at", Value: -1}}
+	findOptions := options.Find().SetSort(sort).SetSkip(int64(offset)).SetLimit(int64(limit))
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &questions); err != nil {
+		return nil, err
+	}
+
+	answersCollection := db.GetCollection(collection.Database(), "answers")
+	for i := range questions {
+		if questions[i].AnswerCount == 0 {
+			continue
+		}
+
+		questions[i].Answers, _, err = GetQuestionAnswers(answersCollection, questions[i].ID, 0, answersPerQuestion)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return questions, nil
+}
+
+// CreateAnswer answers a visible question. Shoppers must have received the product; admins can always answer.
+func CreateAnswer(collection *mongo.Collection, questionID, userID, username, body string, byAdmin bool) (*entity.Answer, error) {
+	ctx := context.Background()
+	database := collection.Database()
+
+	question, err := GetQuestion(db.GetCollection(database, "questions"), questionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if question.Status != entity.ModerationApproved {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	verified, err := HasReceivedProduct(db.GetCollection(database, "orders"), userID, question.ProductID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !verified && !byAdmin {
+		return nil, ErrNotVerifiedBuyer
+	}
+
+	answer := entity.Answer{
+		ID:            primitive.NewObjectIDFromTimestamp(time.Now()).Hex(),
+		QuestionID:    questionID,
+		ProductID:     question.ProductID,
+		UserID:        userID,
+		User:          username,
+		Body:          body,
+		ByAdmin:       byAdmin,
+		VerifiedBuyer: verified,
+		CreatedAt:     time.Now(),
+	}
+
+	// The store's own answers skip the content filter
+	answer.Status = entity.ModerationApproved
+	if !byAdmin {
+		answer.Flags, answer.Status = moderateContent(body)
+	}
+
+	_, err = collection.InsertOne(ctx, answer)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := refreshAnswerCount(database, questionID); err != nil {
+		return nil, err
+	}
+
+	return &answer, nil
+}
+
+// refreshAnswerCount recounts the approved answers of a question
+func refreshAnswerCount(database *mongo.Database, questionID string) error {
+	ctx := context.Background()
+
+	count, err := db.GetCollection(database, "answers").CountDocuments(ctx, bson.M{"question_id": questionID, "status": visibleReviews})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.GetCollection(database, "questions").UpdateByID(ctx, questionID, bson.M{"$set": bson.M{"answer_count": count}})
+	return err
+}
+
+// GetQuestionAnswers returns a page of a question's approved answers, most helpful first
+func GetQuestionAnswers(collection *mongo.Collection, questionID string, offset, limit int) ([]entity.Answer, int64, error) {
+	ctx := context.Background()
+	var answers = []entity.Answer{}
+
+	filter := bson.M{"question_id": questionID, "status": visibleReviews}
+
+	length, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	findOptions := options.Find().SetSort(answerSort).SetSkip(int64(offset)).SetLimit(int64(limit))
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &answers); err != nil {
+		return nil, -1, err
+	}
+
+	return answers, length, nil
+}
+
+// VoteAnswer records whether a shopper found an answer helpful. Voting again changes the shopper's vote.
+func VoteAnswer(collection *mongo.Collection, answerID, userID string, helpful bool) (*entity.Answer, error) {
+	ctx := context.Background()
+	votesCollection := db.GetCollection(collection.Database(), "answer_votes")
+	var answer entity.Answer
+
+	err := collection.FindOne(ctx, bson.M{"_id": answerID, "status": visibleReviews}).Decode(&answer)
+	if err != nil {
+		return nil, err
+	}
+
+	if answer.UserID == userID {
+		return nil, ErrOwnAnswer
+	}
+
+	var vote entity.AnswerVote
+	inc := bson.M{}
+
+	err = votesCollection.FindOne(ctx, bson.M{"answer_id": answerID, "user_id": userID}).Decode(&vote)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		vote = entity.AnswerVote{
+			ID:        primitive.NewObjectIDFromTimestamp(time.Now()).Hex(),
+			AnswerID:  answerID,
+			UserID:    userID,
+			Helpful:   helpful,
+			CreatedAt: time.Now(),
+		}
+
+		if _, err := votesCollection.InsertOne(ctx, vote); err != nil {
+			return nil, err
+		}
+		inc[voteField(helpful)] = 1
+	case err != nil:
+		return nil, err
+	case vote.Helpful == helpful:
+		return &answer, nil
+	default:
+		_, err := votesCollection.UpdateOne(ctx, bson.M{"_id": vote.ID}, bson.M{"$set": bson.M{"helpful": helpful}})
+		if err != nil {
+			return nil, err
+		}
+		inc[voteField(helpful)] = 1
+		inc[voteField(!helpful)] = -1
+	}
+
+	after := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = collection.FindOneAndUpdate(ctx, bson.M{"_id": answerID}, bson.M{"$inc": inc}, after).Decode(&answer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &answer, nil
+}
+
+func moderationUpdate(status, note string) bson.M {
+	return bson.M{
+		"$set": bson.M{
+			"status":          status,
+			"moderation_note": note,
+			"moderated_at":    time.Now(),
+		},
+	}
+}
+
+// ModerateQuestion approves or rejects a question
+func ModerateQuestion(collection *mongo.Collection, questionID, status, note string) (*entity.Question, error) {
+	var question entity.Question
+	after := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err := collection.FindOneAndUpdate(context.Background(), bson.M{"_id": questionID}, moderationUpdate(status, note), after).Decode(&question)
+	if err != nil {
+		return nil, err
+	}
+
+	return &question, nil
+}
+
+// ModerateAnswer approves or rejects an answer and recounts the answers of its question
+func ModerateAnswer(collection *mongo.Collection, answerID, status, note string) (*entity.Answer, error) {
+	var answer entity.Answer
+	after := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err := collection.FindOneAndUpdate(context.Background(), bson.M{"_id": answerID}, moderationUpdate(status, note), after).Decode(&answer)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := refreshAnswerCount(collection.Database(), answer.QuestionID); err != nil {
+		return nil, err
+	}
+
+	return &answer, nil
+}
+
+// GetQuestionsByStatus returns a page of questions with the given moderation status, oldest first
+func GetQuestionsByStatus(collection *mongo.Collection, status string, offset, limit int) ([]entity.Question, int64, error) {
+	ctx := context.Background()
+	var questions = []entity.Question{}
+
+	filter := bson.M{"status": status}
+
+	length, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	findOptions := options.Find().SetSort(bson.M{"created_at": 1}).SetSkip(int64(offset)).SetLimit(int64(limit))
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &questions); err != nil {
+		return nil, -1, err
+	}
+
+	return questions, length, nil
+}
+
+// GetAnswersByStatus returns a page of answers with the given moderation status, oldest first
+func GetAnswersByStatus(collection *mongo.Collection, status string, offset, limit int) ([]entity.Answer, int64, error) {
+	ctx := context.Background()
+	var answers = []entity.Answer{}
+
+	filter := bson.M{"status": status}
+
+	length, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	findOptions := options.Find().SetSort(bson.M{"created_at": 1}).SetSkip(int64(offset)).SetLimit(int64(limit))
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &answers); err != nil {
+		return nil, -1, err
+	}
+
+	return answers, length, nil
+}
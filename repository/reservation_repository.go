@@ -0,0 +1,206 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultCheckoutHold  = 15 * time.Minute
+	defaultFlashSaleHold = 10 * time.Minute
+)
+
+// ReserveCheckout holds the stock of every line in a user's cart while they check out, replacing holds they
+// already have. Either every line is held or, when one cannot be ordered, none are.
+func ReserveCheckout(database *mongo.Database, userID string) ([]entity.Reservation, error) {
+	cartItems, _, err := GetUserCartItems(db.GetCollection(database, "cart"), userID, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	products := db.GetCollection(database, "products")
+	held := make([]*entity.Product, 0, len(cartItems))
+
+	for _, item := range cartItems {
+		product, err := FindOneProduct(products, item.ProductID)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := applyReservations(database, userID, product); err != nil {
+			return nil, err
+		}
+
+		if err := ValidateQuantity(product, item.Quantity); err != nil {
+			return nil, err
+		}
+
+		held = append(held, product)
+	}
+
+	reservations := make([]entity.Reservation, 0, len(held))
+	for i, product := range held {
+		reservation, err := reserve(database, userID, product, cartItems[i].Quantity, entity.ReservationCheckout, checkoutHold())
+		if err != nil {
+			return nil, err
+		}
+		reservations = append(reservations, *reservation)
+	}
+
+	return reservations, nil
+}
+
+// GetReservations returns the unexpired holds of a cart owner
+func GetReservations(collection *mongo.Collection, ownerID string) ([]entity.Reservation, error) {
+	ctx := context.Background()
+	var reservations = []entity.Reservation{}
+
+	filter := bson.M{"user_id": ownerID, "expires_at": bson.M{"$gt": time.Now()}}
+
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"expires_at": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cursor.All(ctx, &reservations); err != nil {
+		return nil, err
+	}
+
+	return reservations, nil
+}
+
+// ReleaseReservations drops every hold of a cart owner, e.g. when they leave checkout or have ordered
+func ReleaseReservations(database *mongo.Database, ownerID string) error {
+	_, err := db.GetCollection(database, "reservations").DeleteMany(context.Background(), bson.M{"user_id": ownerID})
+	return err
+}
+
+// releaseReservation drops the hold of a cart owner on a product
+func releaseReservation(database *mongo.Database, ownerID, productID string) error {
+	filter := bson.M{"user_id": ownerID, "product_id": productID}
+	_, err := db.GetCollection(database, "reservations").DeleteOne(context.Background(), filter)
+	return err
+}
+
+// holdFlashSale holds quantity of a flash sale product for the cart of ownerID. Other products are not held
+// until checkout. The product's stock must already exclude what others hold.
+func holdFlashSale(database *mongo.Database, ownerID string, product *entity.Product, quantity int64) error {
+	if !product.FlashSale {
+		return nil
+	}
+
+	_, err := reserve(database, ownerID, product, quantity, entity.ReservationFlashSale, flashSaleHold())
+	return err
+}
+
+// reserve holds quantity of a product for ownerID until ttl from now, replacing any hold they have on it.
+// The product's stock must already exclude what others hold.
+func reserve(database *mongo.Database, ownerID string, product *entity.Product, quantity int64, reason string,
+	ttl time.Duration) (*entity.Reservation, error) {
+
+	if !product.UnlimitedStock() && quantity > product.Quantity {
+		return nil, fmt.Errorf("%w: only %d of %s can be held", ErrInsufficientStock, product.Quantity, product.Name)
+	}
+
+	var reservation entity.Reservation
+
+	filter := bson.M{"user_id": ownerID, "product_id": product.ID}
+	update := bson.M{
+		"$set": bson.M{"quantity": quantity, "reason": reason, "expires_at": time.Now().Add(ttl)},
+		"$setOnInsert": bson.M{
+			"_id":        primitive.NewObjectIDFromTimestamp(time.Now()).Hex(),
+			"created_at": time.Now(),
+		},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	err := db.GetCollection(database, "reservations").FindOneAndUpdate(context.Background(), filter, update, opts).Decode(&reservation)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reservation, nil
+}
+
+// applyReservations takes the stock other shoppers hold off the stock of products, leaving what ownerID
+// may add or order. Holds of ownerID count as available to them.
+func applyReservations(database *mongo.Database, ownerID string, products ...*entity.Product) error {
+	ctx := context.Background()
+
+	ids := make([]string, 0, len(products))
+	for _, p := range products {
+		if !p.UnlimitedStock() {
+			ids = append(ids, p.ID)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"product_id": bson.M{"$in": ids},
+			"user_id":    bson.M{"$ne": ownerID},
+			"expires_at": bson.M{"$gt": time.Now()},
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": "$product_id", "quantity": bson.M{"$sum": "$quantity"}}}},
+	}
+
+	cursor, err := db.GetCollection(database, "reservations").Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+
+	var rows []struct {
+		ProductID string `bson:"_id"`
+		Quantity  int64  `bson:"quantity"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return err
+	}
+
+	held := make(map[string]int64, len(rows))
+	for _, r := range rows {
+		held[r.ProductID] = r.Quantity
+	}
+
+	for _, p := range products {
+		if p.UnlimitedStock() {
+			continue
+		}
+
+		p.Quantity -= held[p.ID]
+		if p.Quantity < 0 {
+			p.Quantity = 0
+		}
+	}
+
+	return nil
+}
+
+func checkoutHold() time.Duration {
+	return durationFromEnv("CHECKOUT_HOLD", defaultCheckoutHold)
+}
+
+func flashSaleHold() time.Duration {
+	return durationFromEnv("FLASH_SALE_HOLD", defaultFlashSaleHold)
+}
+
+// durationFromEnv reads a duration such as "15m" from an environment variable, falling back to def
+func durationFromEnv(key string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || d <= 0 {
+		return def
+	}
+	return d
+}
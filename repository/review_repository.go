@@ -0,0 +1,582 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/moderation"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrAlreadyReviewed is returned when a user tries to review a product a second time
+	ErrAlreadyReviewed = errors.New("you have already reviewed this product")
+	// ErrAlreadyReported is returned when a user reports the same review twice
+	ErrAlreadyReported = errors.New("you have already reported this review")
+	// ErrOwnReview is returned when a user votes on or reports their own review
+	ErrOwnReview = errors.New("you cannot vote on or report your own review")
+)
+
+// visibleReviews matches reviews shown on the storefront. Reviews created before moderation
+// was introduced have no status and are treated as approved.
+var visibleReviews = bson.M{"$nin": []string{entity.ModerationPending, entity.ModerationRejected}}
+
+// reviewSorts maps the sort query values accepted by review listings to their mongo sort documents
+var reviewSorts = map[string]bson.D{
+	"newest":  {{Key: "created_at", Value: -1}},
+	"oldest":  {{Key: "created_at", Value: 1}},
+	"highest": {{Key: "stars", Value: -1}, {Key: "created_at", Value: -1}},
+	"lowest":  {{Key: "stars", Value: 1}, {Key: "created_at", Value: -1}},
+	"helpful": {{Key: "helpful_votes", Value: -1}, {Key: "created_at", Value: -1}},
+}
+
+// IsValidReviewSort reports whether sort is an accepted review listing sort
+func IsValidReviewSort(sort string) bool {
+	_, ok := reviewSorts[sort]
+	return ok
+}
+
+// CreateReview stores a new review of a product and refreshes the product's ratings.
+// The review is flagged as a verified purchase when the user has received an order of the product,
+// and is held for moderation when the content filter flags it.
+func CreateReview(collection *mongo.Collection, productID, userID, username string, req entity.ReviewRequest) (*entity.Review, error) {
+	ctx := context.Background()
+	database := collection.Database()
+
+	_, err := FindOneProduct(db.GetCollection(database, "products"), productID)
+	if err != nil {
+		return nil, err
+	}
+
+	verified, err := HasReceivedProduct(db.GetCollection(database, "orders"), userID, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	review := entity.Review{
+		ID:               primitive.NewObjectIDFromTimestamp(time.Now()).Hex(),
+		ProductID:        productID,
+		UserID:           userID,
+		User:             username,
+		Stars:            req.Stars,
+		Title:            req.Title,
+		Comment:          req.Comment,
+		VerifiedPurchase: verified,
+		CreatedAt:        time.Now(),
+	}
+	applyReviewFilter(&review)
+
+	_, err = collection.InsertOne(ctx, review)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrAlreadyReviewed
+		}
+		return nil, err
+	}
+
+	_, err = UpdateProductRating(database, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &review, nil
+}
+
+// HasReceivedProduct reports whether a user has received at least one order containing the product
+func HasReceivedProduct(collection *mongo.Collection, userID, productID string) (bool, error) {
+	ctx := context.Background()
+
+	filter := bson.M{
+		"user_id":          userID,
+		"lines.product_id": productID,
+		"status":           entity.OrderCompleted,
+	}
+
+	count, err := collection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// GetUserProductReview gets the review a user left on a product
+func GetUserProductReview(collection *mongo.Collection, productID, userID string) (*entity.Review, error) {
+	ctx := context.Background()
+	var review entity.Review
+
+	filter := bson.M{"product_id": productID, "user_id": userID}
+
+	err := collection.FindOne(ctx, filter).Decode(&review)
+	if err != nil {
+		return nil, err
+	}
+
+	return &review, nil
+}
+
+// UpdateReview edits the review a user left on a product and refreshes the product's ratings
+func UpdateReview(collection *mongo.Collection, productID, userID string, req entity.ReviewRequest) (*entity.Review, error) {
+	ctx := context.Background()
+
+	review, err := GetUserProductReview(collection, productID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	review.Title = req.Title
+	review.Comment = req.Comment
+	applyReviewFilter(review)
+
+	// Votes and reports can come in while the review is edited, so only the edited fields are written
+	update := bson.M{"$set": bson.M{
+		"stars":      req.Stars,
+		"title":      req.Title,
+		"comment":    req.Comment,
+		"flags":      review.Flags,
+		"status":     review.Status,
+		"updated_at": time.Now(),
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err = collection.FindOneAndUpdate(ctx, bson.M{"_id": review.ID}, update, opts).Decode(review)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = UpdateProductRating(collection.Database(), productID)
+	if err != nil {
+		return nil, err
+	}
+
+	return review, nil
+}
+
+// DeleteReview removes the review a user left on a product and refreshes the product's ratings
+func DeleteReview(collection *mongo.Collection, productID, userID string) (*mongo.DeleteResult, error) {
+	ctx := context.Background()
+
+	filter := bson.M{"product_id": productID, "user_id": userID}
+
+	result, err := collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.DeletedCount > 0 {
+		_, err = UpdateProductRating(collection.Database(), productID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// GetProductReviews returns a page of a product's approved reviews in the order given by sort
+func GetProductReviews(collection *mongo.Collection, productID, sort string, offset, limit int) ([]entity.Review, int64, error) {
+	ctx := context.Background()
+	var reviews = []entity.Review{}
+
+	filter := bson.M{"product_id": productID, "status": visibleReviews}
+
+	length, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	sortDoc, ok := reviewSorts[sort]
+	if !ok {
+		sortDoc = reviewSorts["newest"]
+	}
+
+	findOptions := options.Find().SetSort(sortDoc).SetSkip(int64(offset)).SetLimit(int64(limit))
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var review entity.Review
+		if err := cursor.Decode(&review); err != nil {
+			return nil, -1, err
+		}
+		reviews = append(reviews, review)
+	}
+
+	return reviews, length, nil
+}
+
+// GetRatingSummary aggregates the approved reviews of a product into a count, average and star histogram
+func GetRatingSummary(collection *mongo.Collection, productID string) (*entity.RatingSummary, error) {
+	ctx := context.Background()
+	var summary entity.RatingSummary
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"product_id": productID, "status": visibleReviews}}},
+		{{Key: "$group", Value: bson.M{"_id": "$stars", "count": bson.M{"$sum": 1}}}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var total int64
+	for cursor.Next(ctx) {
+		var group struct {
+			Stars int64 `bson:"_id"`
+			Count int64 `bson:"count"`
+		}
+		if err := cursor.Decode(&group); err != nil {
+			return nil, err
+		}
+
+		switch group.Stars {
+		case 1:
+			summary.Histogram.One = group.Count
+		case 2:
+			summary.Histogram.Two = group.Count
+		case 3:
+			summary.Histogram.Three = group.Count
+		case 4:
+			summary.Histogram.Four = group.Count
+		case 5:
+			summary.Histogram.Five = group.Count
+		default:
+			continue
+		}
+
+		summary.NoOfReviews += group.Count
+		total += group.Stars * group.Count
+	}
+
+	if summary.NoOfReviews > 0 {
+		// Round to 2 decimal places so the stored average is stable for display
+		summary.AverageRating = math.Round(float64(total)/float64(summary.NoOfReviews)*100) / 100
+	}
+
+	return &summary, cursor.Err()
+}
+
+// UpdateProductRating recomputes a product's rating summary from the reviews collection and stores it on the product
+func UpdateProductRating(database *mongo.Database, productID string) (*entity.RatingSummary, error) {
+	ctx := context.Background()
+
+	summary, err := GetRatingSummary(db.GetCollection(database, "reviews"), productID)
+	if err != nil {
+		return nil, err
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"no_of_reviews":    summary.NoOfReviews,
+			"average_rating":   summary.AverageRating,
+			"rating_histogram": summary.Histogram,
+		},
+	}
+
+	_, err = db.GetCollection(database, "products").UpdateOne(ctx, bson.M{"_id": productID}, update)
+	if err != nil {
+		return nil, err
+	}
+
+	return summary, nil
+}
+
+// MigrateEmbeddedReviews moves reviews still embedded in product documents into the reviews collection.
+// Reviewers are matched by username; reviews from users that no longer exist are dropped.
+func MigrateEmbeddedReviews(database *mongo.Database) (int, error) {
+	ctx := context.Background()
+	productsCollection := db.GetCollection(database, "products")
+	reviewsCollection := db.GetCollection(database, "reviews")
+	usersCollection := db.GetCollection(database, "users")
+	ordersCollection := db.GetCollection(database, "orders")
+
+	filter := bson.M{"reviews.0": bson.M{"$exists": true}}
+
+	cursor, err := productsCollection.Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		var product struct {
+			ID      string `bson:"_id"`
+			Reviews []struct {
+				User      string    `bson:"user"`
+				Stars     int64     `bson:"stars"`
+				Comment   string    `bson:"comment"`
+				CreatedAt time.Time `bson:"createdat"`
+			} `bson:"reviews"`
+		}
+		if err := cursor.Decode(&product); err != nil {
+			return migrated, err
+		}
+
+		for _, r := range product.Reviews {
+			user, err := GetUser(usersCollection, "", r.User)
+			if err != nil {
+				if err == mongo.ErrNoDocuments {
+					continue
+				}
+				return migrated, err
+			}
+
+			verified, err := HasReceivedProduct(ordersCollection, user.ID, product.ID)
+			if err != nil {
+				return migrated, err
+			}
+
+			review := entity.Review{
+				ID:               primitive.NewObjectIDFromTimestamp(r.CreatedAt).Hex(),
+				ProductID:        product.ID,
+				UserID:           user.ID,
+				User:             user.Username,
+				Stars:            r.Stars,
+				Comment:          r.Comment,
+				VerifiedPurchase: verified,
+				CreatedAt:        r.CreatedAt,
+				Status:           entity.ModerationApproved,
+			}
+
+			_, err = reviewsCollection.InsertOne(ctx, review)
+			if err != nil {
+				// Only the first review a user left on a product is kept
+				if mongo.IsDuplicateKeyError(err) {
+					continue
+				}
+				return migrated, err
+			}
+			migrated++
+		}
+
+		_, err = productsCollection.UpdateOne(ctx, bson.M{"_id": product.ID}, bson.M{"$unset": bson.M{"reviews": ""}})
+		if err != nil {
+			return migrated, err
+		}
+
+		if _, err := UpdateProductRating(database, product.ID); err != nil {
+			return migrated, err
+		}
+	}
+
+	return migrated, cursor.Err()
+}
+
+// applyReviewFilter runs the content filter over a review and sets its moderation status. New reviews are
+// approved unless the filter holds them; an edit keeps a pending or rejected review as it is, so only the
+// filter can send a review back for moderation.
+func applyReviewFilter(review *entity.Review) {
+	filter := moderation.DefaultFilter()
+
+	review.Flags = filter.Check(review.Title, review.Comment)
+	if filter.NeedsApproval(review.Flags) {
+		review.Status = entity.ModerationPending
+	} else if review.Status == "" {
+		review.Status = entity.ModerationApproved
+	}
+}
+
+// GetReview gets a single review by its ID
+func GetReview(collection *mongo.Collection, reviewID string) (*entity.Review, error) {
+	ctx := context.Background()
+	var review entity.Review
+
+	err := collection.FindOne(ctx, bson.M{"_id": reviewID}).Decode(&review)
+	if err != nil {
+		return nil, err
+	}
+
+	return &review, nil
+}
+
+// ReportReview records a shopper's report of a review. Once a review collects enough reports
+// it is sent back to the moderation queue and hidden until an admin approves it again.
+func ReportReview(collection *mongo.Collection, reviewID, userID, reason string) (*entity.Review, error) {
+	ctx := context.Background()
+	database := collection.Database()
+
+	review, err := GetReview(collection, reviewID)
+	if err != nil {
+		return nil, err
+	}
+
+	if review.UserID == userID {
+		return nil, ErrOwnReview
+	}
+
+	report := entity.ReviewReport{
+		ID:        primitive.NewObjectIDFromTimestamp(time.Now()).Hex(),
+		ReviewID:  reviewID,
+		UserID:    userID,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	}
+
+	_, err = db.GetCollection(database, "review_reports").InsertOne(ctx, report)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrAlreadyReported
+		}
+		return nil, err
+	}
+
+	update := bson.M{"$inc": bson.M{"report_count": 1}}
+	after := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err = collection.FindOneAndUpdate(ctx, bson.M{"_id": reviewID}, update, after).Decode(review)
+	if err != nil {
+		return nil, err
+	}
+
+	if review.Status != entity.ModerationPending && review.ReportCount >= moderation.DefaultFilter().ReportThreshold {
+		update = bson.M{
+			"$set":      bson.M{"status": entity.ModerationPending},
+			"$addToSet": bson.M{"flags": moderation.FlagReported},
+		}
+
+		err = collection.FindOneAndUpdate(ctx, bson.M{"_id": reviewID}, update, after).Decode(review)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := UpdateProductRating(database, review.ProductID); err != nil {
+			return nil, err
+		}
+	}
+
+	return review, nil
+}
+
+// VoteReview records or changes a shopper's helpful or unhelpful vote on a review
+func VoteReview(collection *mongo.Collection, reviewID, userID string, helpful bool) (*entity.Review, error) {
+	ctx := context.Background()
+	votesCollection := db.GetCollection(collection.Database(), "review_votes")
+
+	review, err := GetReview(collection, reviewID)
+	if err != nil {
+		return nil, err
+	}
+
+	if review.UserID == userID {
+		return nil, ErrOwnReview
+	}
+
+	var vote entity.ReviewVote
+	inc := bson.M{}
+
+	err = votesCollection.FindOne(ctx, bson.M{"review_id": reviewID, "user_id": userID}).Decode(&vote)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		vote = entity.ReviewVote{
+			ID:        primitive.NewObjectIDFromTimestamp(time.Now()).Hex(),
+			ReviewID:  reviewID,
+			UserID:    userID,
+			Helpful:   helpful,
+			CreatedAt: time.Now(),
+		}
+
+		if _, err := votesCollection.InsertOne(ctx, vote); err != nil {
+			return nil, err
+		}
+		inc[voteField(helpful)] = 1
+	case err != nil:
+		return nil, err
+	case vote.Helpful == helpful:
+		// Same vote again, nothing changes
+		return review, nil
+	default:
+		_, err := votesCollection.UpdateOne(ctx, bson.M{"_id": vote.ID}, bson.M{"$set": bson.M{"helpful": helpful}})
+		if err != nil {
+			return nil, err
+		}
+		inc[voteField(helpful)] = 1
+		inc[voteField(!helpful)] = -1
+	}
+
+	after := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = collection.FindOneAndUpdate(ctx, bson.M{"_id": reviewID}, bson.M{"$inc": inc}, after).Decode(review)
+	if err != nil {
+		return nil, err
+	}
+
+	return review, nil
+}
+
+func voteField(helpful bool) string {
+	if helpful {
+		return "helpful_votes"
+	}
+	return "unhelpful_votes"
+}
+
+// ModerateReview approves or rejects a review and refreshes the product's ratings
+func ModerateReview(collection *mongo.Collection, reviewID, status, note string) (*entity.Review, error) {
+	ctx := context.Background()
+	var review entity.Review
+
+	update := bson.M{
+		"$set": bson.M{
+			"status":          status,
+			"moderation_note": note,
+			"moderated_at":    time.Now(),
+		},
+	}
+	after := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err := collection.FindOneAndUpdate(ctx, bson.M{"_id": reviewID}, update, after).Decode(&review)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := UpdateProductRating(collection.Database(), review.ProductID); err != nil {
+		return nil, err
+	}
+
+	return &review, nil
+}
+
+// GetReviewsByStatus returns a page of reviews with the given moderation status, most reported first
+func GetReviewsByStatus(collection *mongo.Collection, status string, offset, limit int) ([]entity.Review, int64, error) {
+	ctx := context.Background()
+	var reviews = []entity.Review{}
+
+	filter := bson.M{"status": status}
+
+	length, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	sort := bson.D{{Key: "report_count", Value: -1}, {Key: "created_at", Value: 1}}
+	findOptions := options.Find().SetSort(sort).SetSkip(int64(offset)).SetLimit(int64(limit))
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var review entity.Review
+		if err := cursor.Decode(&review); err != nil {
+			return nil, -1, err
+		}
+		reviews = append(reviews, review)
+	}
+
+	return reviews, length, nil
+}
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SaveForLater moves a line of a user's cart to their saved for later list. A product that is already saved
+// has the line's quantity added to it. Any stock held for the line is released.
+func SaveForLater(database *mongo.Database, cartItemID, userID string) (*entity.SavedItem, error) {
+	ctx := context.Background()
+	cart := db.GetCollection(database, "cart")
+
+	item, err := GetCartItem(cart, cartItemID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"user_id": userID, "product_id": item.ProductID}
+	update := bson.M{
+		"$inc": bson.M{"quantity": item.Quantity},
+		"$set": bson.M{"saved_at": time.Now()},
+		"$setOnInsert": bson.M{
+			"_id": primitive.NewObjectIDFromTimestamp(time.Now()).Hex(),
+		},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var saved entity.SavedItem
+	err = db.GetCollection(database, "saved_items").FindOneAndUpdate(ctx, filter, update, opts).Decode(&saved)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := RemoveFromCart(cart, item.ID, userID); err != nil {
+		return nil, err
+	}
+
+	return &saved, nil
+}
+
+// MoveSavedItemToCart adds a saved product back to a user's cart with the quantity it was saved with and
+// removes it from the list. It stays saved when the cart cannot take it, e.g. because it sold out.
+func MoveSavedItemToCart(database *mongo.Database, savedItemID, userID string) (*entity.CartItem, error) {
+	ctx := context.Background()
+	saved := db.GetCollection(database, "saved_items")
+
+	var item entity.SavedItem
+	err := saved.FindOne(ctx, bson.M{"_id": savedItemID, "user_id": userID}).Decode(&item)
+	if err != nil {
+		return nil, err
+	}
+
+	cartItem, err := AddToCart(db.GetCollection(database, "cart"), item.Quantity, item.ProductID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := saved.DeleteOne(ctx, bson.M{"_id": item.ID}); err != nil {
+		return nil, err
+	}
+
+	return cartItem, nil
+}
+
+// RemoveSavedItem removes a product from a user's saved for later list
+func RemoveSavedItem(collection *mongo.Collection, savedItemID, userID string) (*mongo.DeleteResult, error) {
+	return collection.DeleteOne(context.Background(), bson.M{"_id": savedItemID, "user_id": userID})
+}
+
+// GetSavedItems returns a user's saved for later list, most recently saved first, with the current price of
+// each product and the stock other shoppers have not held
+func GetSavedItems(collection *mongo.Collection, userID string) ([]entity.SavedItem, error) {
+	ctx := context.Background()
+	var items = []entity.SavedItem{}
+
+	opts := options.Find().SetSort(bson.M{"saved_at": -1})
+
+	cursor, err := collection.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ProductID)
+	}
+
+	products, err := findProductsInOrder(db.GetCollection(collection.Database(), "products"), ids)
+	if err != nil {
+		return nil, err
+	}
+
+	held := make([]*entity.Product, len(products))
+	current := make(map[string]*entity.Product, len(products))
+	for i := range products {
+		held[i] = &products[i]
+		current[products[i].ID] = &products[i]
+	}
+
+	if err := applyReservations(collection.Database(), userID, held...); err != nil {
+		return nil, err
+	}
+
+	for i := range items {
+		items[i].Product = current[items[i].ProductID]
+	}
+
+	return items, nil
+}
@@ -0,0 +1,199 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// defaultLowStockThreshold is used for products without a threshold when LOW_STOCK_THRESHOLD is not set
+const defaultLowStockThreshold = 5
+
+var (
+	// ErrInvalidQuantity is returned for quantities below one
+	ErrInvalidQuantity = errors.New("quantity must be at least 1")
+	// ErrBelowMinimumOrder is returned when a quantity is below the product's minimum order
+	ErrBelowMinimumOrder = errors.New("quantity is below the minimum order")
+	// ErrAboveMaximumOrder is returned when a quantity is above the product's maximum per order
+	ErrAboveMaximumOrder = errors.New("quantity is above the maximum per order")
+	// ErrInsufficientStock is returned when there is not enough stock on hand for a quantity
+	ErrInsufficientStock = errors.New("not enough stock")
+)
+
+// IsQuantityError reports whether err was caused by an invalid order or cart quantity
+func IsQuantityError(err error) bool {
+	return errors.Is(err, ErrInvalidQuantity) || errors.Is(err, ErrBelowMinimumOrder) ||
+		errors.Is(err, ErrAboveMaximumOrder) || errors.Is(err, ErrInsufficientStock) || errors.Is(err, ErrNotForSale)
+}
+
+// ValidateQuantity checks a cart or order quantity against a product's minimum order,
+// maximum per order and stock on hand. Products not live in the storefront cannot be bought at all.
+func ValidateQuantity(product *entity.Product, quantity int64) error {
+	if !product.IsLive(time.Now()) {
+		return fmt.Errorf("%w: %s", ErrNotForSale, product.Name)
+	}
+
+	if quantity < 1 {
+		return ErrInvalidQuantity
+	}
+
+	if product.MinimumOrder > 0 && quantity < product.MinimumOrder {
+		return fmt.Errorf("%w of %d for %s", ErrBelowMinimumOrder, product.MinimumOrder, product.Name)
+	}
+
+	if product.MaximumPerOrder > 0 && quantity > product.MaximumPerOrder {
+		return fmt.Errorf("%w of %d for %s", ErrAboveMaximumOrder, product.MaximumPerOrder, product.Name)
+	}
+
+	if !product.UnlimitedStock() && quantity > product.Quantity {
+		return fmt.Errorf("%w: only %d of %s left", ErrInsufficientStock, product.Quantity, product.Name)
+	}
+
+	return nil
+}
+
+// LowStockThreshold returns the stock level at which a product is considered low on stock
+func LowStockThreshold(product *entity.Product) int64 {
+	if product.LowStockThreshold > 0 {
+		return product.LowStockThreshold
+	}
+	return defaultThreshold()
+}
+
+func defaultThreshold() int64 {
+	threshold, err := strconv.ParseInt(os.Getenv("LOW_STOCK_THRESHOLD"), 10, 64)
+	if err != nil || threshold < 0 {
+		return defaultLowStockThreshold
+	}
+	return threshold
+}
+
+// checkLowStock raises an alert when a stock change takes a product to or below its threshold,
+// and resolves open alerts when it is restocked above it
+func checkLowStock(database *mongo.Database, product *entity.Product, oldQuantity int64) error {
+	ctx := context.Background()
+	collection := db.GetCollection(database, "stock_alerts")
+	threshold := LowStockThreshold(product)
+
+	switch {
+	case oldQuantity > threshold && product.Quantity <= threshold:
+		alert := entity.StockAlert{
+			ID:          primitive.NewObjectIDFromTimestamp(time.Now()).Hex(),
+			ProductID:   product.ID,
+			ProductName: product.Name,
+			Quantity:    product.Quantity,
+			Threshold:   threshold,
+			CreatedAt:   time.Now(),
+		}
+
+		log.Printf("low stock: %s (%s) has %d left, threshold is %d", product.Name, product.ID, product.Quantity, threshold)
+
+		_, err := collection.InsertOne(ctx, alert)
+		return err
+	case oldQuantity <= threshold && product.Quantity > threshold:
+		filter := bson.M{"product_id": product.ID, "resolved": false}
+		update := bson.M{"$set": bson.M{"resolved": true, "resolved_at": time.Now()}}
+
+		_, err := collection.UpdateMany(ctx, filter, update)
+		return err
+	}
+
+	return nil
+}
+
+// GetLowStockProducts returns products at or below their low stock threshold, lowest stock first
+func GetLowStockProducts(collection *mongo.Collection, offset, limit int) ([]entity.Product, int64, error) {
+	ctx := context.Background()
+	var products = []entity.Product{}
+
+	// Products without their own threshold use the store default. Bundles have no stock of their own.
+	filter := bson.M{
+		"type": bson.M{"$nin": []string{entity.ProductTypeBundle, entity.ProductTypeDigital}},
+		"$expr": bson.M{
+			"$lte": bson.A{
+				"$quantity",
+				bson.M{"$cond": bson.A{
+					bson.M{"$gt": bson.A{"$low_stock_threshold", 0}},
+					"$low_stock_threshold",
+					defaultThreshold(),
+				}},
+			},
+		},
+	}
+
+	length, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	findOptions := options.Find().SetSort(bson.M{"quantity": 1}).SetSkip(int64(offset)).SetLimit(int64(limit))
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var product entity.Product
+		if err := cursor.Decode(&product); err != nil {
+			return nil, -1, err
+		}
+		products = append(products, product)
+	}
+
+	return products, length, nil
+}
+
+// GetStockAlerts returns a page of open or resolved low stock alerts, newest first
+func GetStockAlerts(collection *mongo.Collection, resolved bool, offset, limit int) ([]entity.StockAlert, int64, error) {
+	ctx := context.Background()
+	var alerts = []entity.StockAlert{}
+
+	filter := bson.M{"resolved": resolved}
+
+	length, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	findOptions := options.Find().SetSort(bson.M{"created_at": -1}).SetSkip(int64(offset)).SetLimit(int64(limit))
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var alert entity.StockAlert
+		if err := cursor.Decode(&alert); err != nil {
+			return nil, -1, err
+		}
+		alerts = append(alerts, alert)
+	}
+
+	return alerts, length, nil
+}
+
+// MigrateMinimumOrderField renames the minimum order field stored before it had a bson tag
+func MigrateMinimumOrderField(database *mongo.Database) error {
+	ctx := context.Background()
+
+	filter := bson.M{"minimumorder": bson.M{"$exists": true}}
+	update := bson.M{"$rename": bson.M{"minimumorder": "minimum_order"}}
+
+	_, err := db.GetCollection(database, "products").UpdateMany(ctx, filter, update)
+	return err
+}
@@ -0,0 +1,221 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/notification"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrAlreadySubscribed is returned when a user already has the same subscription for a product
+	ErrAlreadySubscribed = errors.New("you are already subscribed to this product")
+	// ErrSubscriptionMet is returned when a subscription would be met straight away
+	ErrSubscriptionMet = errors.New("product is already in stock or at that price")
+	// ErrInvalidSubscription is returned for unknown subscription types or target prices
+	ErrInvalidSubscription = errors.New("invalid subscription")
+)
+
+// productChanges queues products that were restocked or repriced for the subscription dispatcher
+var productChanges = make(chan string, 256)
+
+// notifyProductChanged asks the subscription dispatcher to check a product. It never blocks;
+// if the queue is full the product is picked up by the dispatcher's next sweep instead.
+func notifyProductChanged(productID string) {
+	select {
+	case productChanges <- productID:
+	default:
+	}
+}
+
+// CreateSubscription subscribes a user to a product coming back in stock or dropping to targetPrice
+func CreateSubscription(collection *mongo.Collection, userID, productID, subscriptionType string, targetPrice float64) (*entity.Subscription, error) {
+	ctx := context.Background()
+
+	product, err := FindOneProduct(db.GetCollection(collection.Database(), "products"), productID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch subscriptionType {
+	case entity.SubscriptionBackInStock:
+		if product.Quantity > 0 {
+			return nil, ErrSubscriptionMet
+		}
+		targetPrice = 0
+	case entity.SubscriptionPriceDrop:
+		if targetPrice <= 0 {
+			return nil, fmt.Errorf("%w: target price must be greater than 0", ErrInvalidSubscription)
+		}
+		if product.EffectivePrice <= targetPrice {
+			return nil, ErrSubscriptionMet
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown type %q", ErrInvalidSubscription, subscriptionType)
+	}
+
+	subscription := entity.Subscription{
+		ID:          primitive.NewObjectIDFromTimestamp(time.Now()).Hex(),
+		UserID:      userID,
+		ProductID:   productID,
+		Type:        subscriptionType,
+		TargetPrice: targetPrice,
+		CreatedAt:   time.Now(),
+	}
+
+	_, err = collection.InsertOne(ctx, subscription)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrAlreadySubscribed
+		}
+		return nil, err
+	}
+
+	return &subscription, nil
+}
+
+// GetUserSubscriptions returns a user's pending subscriptions, newest first
+func GetUserSubscriptions(collection *mongo.Collection, userID string) ([]entity.Subscription, error) {
+	ctx := context.Background()
+	var subscriptions = []entity.Subscription{}
+
+	cursor, err := collection.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &subscriptions); err != nil {
+		return nil, err
+	}
+
+	return subscriptions, nil
+}
+
+// DeleteSubscription removes one of a user's subscriptions
+func DeleteSubscription(collection *mongo.Collection, id, userID string) (*mongo.DeleteResult, error) {
+	return collection.DeleteOne(context.Background(), bson.M{"_id": id, "user_id": userID})
+}
+
+// RunSubscriptionDispatcher notifies subscribers of products as they are restocked or repriced.
+// Every interval it also sweeps all subscribed products, which catches sales that start on a schedule.
+// It never returns and is meant to be started in its own goroutine.
+func RunSubscriptionDispatcher(database *mongo.Database, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case productID := <-productChanges:
+			if err := dispatchProductSubscriptions(database, productID); err != nil {
+				log.Printf("subscriptions of product %s: %v", productID, err)
+			}
+		case <-ticker.C:
+			if err := sweepSubscriptions(database); err != nil {
+				log.Printf("subscription sweep: %v", err)
+			}
+		}
+	}
+}
+
+func sweepSubscriptions(database *mongo.Database) error {
+	productIDs, err := db.GetCollection(database, "subscriptions").Distinct(context.Background(), "product_id", bson.M{})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range productIDs {
+		productID, ok := id.(string)
+		if !ok {
+			continue
+		}
+
+		if err := dispatchProductSubscriptions(database, productID); err != nil {
+			log.Printf("subscriptions of product %s: %v", productID, err)
+		}
+	}
+
+	return nil
+}
+
+// dispatchProductSubscriptions notifies every subscriber whose condition a product now meets.
+// Each subscription is claimed by deleting it before the user is notified, so it is only ever sent once.
+func dispatchProductSubscriptions(database *mongo.Database, productID string) error {
+	ctx := context.Background()
+	collection := db.GetCollection(database, "subscriptions")
+
+	product, err := FindOneProduct(db.GetCollection(database, "products"), productID)
+	if err != nil {
+		return err
+	}
+
+	met := []bson.M{
+		{"type": entity.SubscriptionPriceDrop, "target_price": bson.M{"$gte": product.EffectivePrice}},
+	}
+	if product.Quantity > 0 {
+		met = append(met, bson.M{"type": entity.SubscriptionBackInStock})
+	}
+
+	cursor, err := collection.Find(ctx, bson.M{"product_id": productID, "$or": met})
+	if err != nil {
+		return err
+	}
+
+	var subscriptions []entity.Subscription
+	if err := cursor.All(ctx, &subscriptions); err != nil {
+		return err
+	}
+
+	for _, s := range subscriptions {
+		result, err := collection.DeleteOne(ctx, bson.M{"_id": s.ID})
+		if err != nil {
+			return err
+		}
+
+		// Another dispatcher got to it first
+		if result.DeletedCount == 0 {
+			continue
+		}
+
+		if err := NotifyUser(database, s.UserID, subscriptionMessage(product, s)); err != nil {
+			log.Printf("could not notify user %s about product %s: %v", s.UserID, productID, err)
+
+			// Put it back so the next sweep tries again
+			if _, err := collection.InsertOne(ctx, s); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func subscriptionMessage(product *entity.Product, s entity.Subscription) notification.Message {
+	msg := notification.Message{
+		Link: storeLink("/products/slug/" + product.Slug),
+	}
+
+	if product.Slug == "" {
+		msg.Link = storeLink("/products/find_one/" + product.ID)
+	}
+
+	if s.Type == entity.SubscriptionBackInStock {
+		msg.Subject = fmt.Sprintf("%s is back in stock", product.Name)
+		msg.Body = fmt.Sprintf("%s is available again. Order it before it sells out.", product.Name)
+	} else {
+		msg.Subject = fmt.Sprintf("%s dropped in price", product.Name)
+		msg.Body = fmt.Sprintf("%s now costs %.2f %s, at or below the %.2f you were waiting for.",
+			product.Name, product.EffectivePrice, product.Currency, s.TargetPrice)
+	}
+
+	return msg
+}
@@ -45,7 +45,6 @@ func GetUser(collection *mongo.Collection, userID string, trigger ...string) (*e
 
 func GetAllUsers(collection *mongo.Collection, limit, offset int) ([]entity.User, int64, error) {
 	ctx := context.Background()
-	var user = entity.User{}
 	var users = []entity.User{}
 	filter := bson.M{}
 
@@ -62,6 +61,7 @@ func GetAllUsers(collection *mongo.Collection, limit, offset int) ([]entity.User
 	}
 
 	for cursor.Next(ctx) {
+		var user entity.User
 		cursor.Decode(&user)
 		users = append(users, user)
 	}
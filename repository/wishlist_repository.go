@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/Emmrys-Jay/ecommerce-api/db"
+	"github.com/Emmrys-Jay/ecommerce-api/entity"
+	"github.com/Emmrys-Jay/ecommerce-api/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AddToWishlist adds a product to a user's favourite products, ignoring products already on the list
+func AddToWishlist(collection *mongo.Collection, userID, productID string) (*mongo.UpdateResult, error) {
+	ctx := context.Background()
+
+	_, err := FindOneProduct(db.GetCollection(collection.Database(), "products"), productID)
+	if err != nil {
+		return nil, err
+	}
+
+	update := bson.M{"$addToSet": bson.M{"favourite_products": productID}}
+
+	return collection.UpdateOne(ctx, bson.M{"_id": userID}, update)
+}
+
+// RemoveFromWishlist removes a product from a user's favourite products
+func RemoveFromWishlist(collection *mongo.Collection, userID, productID string) (*mongo.UpdateResult, error) {
+	ctx := context.Background()
+
+	update := bson.M{"$pull": bson.M{"favourite_products": productID}}
+
+	return collection.UpdateOne(ctx, bson.M{"_id": userID}, update)
+}
+
+// GetWishlistProducts returns the current data of a user's favourite products in the order they were added.
+// Products that have since been deleted are left out.
+func GetWishlistProducts(collection *mongo.Collection, userID string) ([]entity.Product, error) {
+	user, err := GetUser(collection, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return findProductsInOrder(db.GetCollection(collection.Database(), "products"), user.FavouriteProducts)
+}
+
+// ShareWishlist enables or disables the public link to a user's wishlist.
+// Enabling returns the share token, reusing the existing one if the list is already shared.
+func ShareWishlist(collection *mongo.Collection, userID string, enabled bool) (string, error) {
+	ctx := context.Background()
+	filter := bson.M{"_id": userID}
+
+	if !enabled {
+		_, err := collection.UpdateOne(ctx, filter, bson.M{"$unset": bson.M{"wishlist_share_token": ""}})
+		return "", err
+	}
+
+	user, err := GetUser(collection, userID)
+	if err != nil {
+		return "", err
+	}
+
+	if user.WishlistShareToken != "" {
+		return user.WishlistShareToken, nil
+	}
+
+	token, err := util.SecureToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"wishlist_share_token": token}})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// GetSharedWishlist returns the wishlist behind a public share token
+func GetSharedWishlist(collection *mongo.Collection, token string) (*entity.SharedWishlist, error) {
+	ctx := context.Background()
+	var user entity.User
+
+	err := collection.FindOne(ctx, bson.M{"wishlist_share_token": token}).Decode(&user)
+	if err != nil {
+		return nil, err
+	}
+
+	products, err := findProductsInOrder(db.GetCollection(collection.Database(), "products"), user.FavouriteProducts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entity.SharedWishlist{Owner: user.Fullname, Products: products}, nil
+}
+
+// GetMostWishlistedProducts returns the products found on the most wishlists, most wishlisted first
+func GetMostWishlistedProducts(collection *mongo.Collection, limit int) ([]entity.WishlistReportEntry, error) {
+	ctx := context.Background()
+	var entries = []entity.WishlistReportEntry{}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$unwind", Value: "$favourite_products"}},
+		{{Key: "$group", Value: bson.M{"_id": "$favourite_products", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         "products",
+			"localField":   "_id",
+			"foreignField": "_id",
+			"as":           "product",
+		}}},
+		{{Key: "$unwind", Value: "$product"}},
+		{{Key: "$project", Value: bson.M{
+			"count":    1,
+			"name":     "$product.name",
+			"price":    "$product.price",
+			"quantity": "$product.quantity",
+		}}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var entry entity.WishlistReportEntry
+		if err := cursor.Decode(&entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, cursor.Err()
+}
+
+// findProductsInOrder fetches products by ID and returns them in the order of ids, skipping missing ones
+func findProductsInOrder(collection *mongo.Collection, ids []string) ([]entity.Product, error) {
+	ctx := context.Background()
+	var products = []entity.Product{}
+
+	if len(ids) == 0 {
+		return products, nil
+	}
+
+	cursor, err := collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	found := make(map[string]entity.Product, len(ids))
+	for cursor.Next(ctx) {
+		var product entity.Product
+		if err := cursor.Decode(&product); err != nil {
+			return nil, err
+		}
+		found[product.ID] = product
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		if product, ok := found[id]; ok {
+			products = append(products, product)
+		}
+	}
+
+	if err := ResolvePricing(collection.Database(), products); err != nil {
+		return nil, err
+	}
+
+	return products, nil
+}
@@ -0,0 +1,51 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// MergePatch applies a JSON Merge Patch (RFC 7396) to a JSON document. Members of the patch replace those of the
+// document, objects are merged member by member and null removes a member.
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	var target, changes interface{}
+
+	if err := decodeNumbers(doc, &target); err != nil {
+		return nil, err
+	}
+
+	if err := decodeNumbers(patch, &changes); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(mergeValue(target, changes))
+}
+
+func mergeValue(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergeValue(targetObject[key], value)
+	}
+
+	return targetObject
+}
+
+// decodeNumbers decodes JSON keeping numbers as written, so large integers survive the round trip
+func decodeNumbers(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
@@ -0,0 +1,74 @@
+package util
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergePatch(t *testing.T) {
+	doc := `{
+		"name": "Desk Lamp",
+		"price": 25,
+		"quantity": 9007199254740993,
+		"tags": ["desk", "light"],
+		"digital": {"delivery": "file", "file_name": "lamp.pdf", "limits": {"downloads": 5, "hours": 72}}
+	}`
+
+	testCases := []struct {
+		name  string
+		patch string
+		want  string
+	}{
+		{
+			name:  "null removes a member",
+			patch: `{"tags": null, "missing": null}`,
+			want:  `{"name": "Desk Lamp", "price": 25, "quantity": 9007199254740993, "digital": {"delivery": "file", "file_name": "lamp.pdf", "limits": {"downloads": 5, "hours": 72}}}`,
+		},
+		{
+			name:  "nested objects are merged member by member",
+			patch: `{"digital": {"file_name": null, "limits": {"hours": 24}}}`,
+			want:  `{"name": "Desk Lamp", "price": 25, "quantity": 9007199254740993, "tags": ["desk", "light"], "digital": {"delivery": "file", "limits": {"downloads": 5, "hours": 24}}}`,
+		},
+		{
+			name:  "arrays are replaced whole",
+			patch: `{"tags": ["lamp"]}`,
+			want:  `{"name": "Desk Lamp", "price": 25, "quantity": 9007199254740993, "tags": ["lamp"], "digital": {"delivery": "file", "file_name": "lamp.pdf", "limits": {"downloads": 5, "hours": 72}}}`,
+		},
+		{
+			name:  "an object replaces a value of another kind",
+			patch: `{"name": {"en": "Desk Lamp"}, "digital": "none"}`,
+			want:  `{"name": {"en": "Desk Lamp"}, "price": 25, "quantity": 9007199254740993, "tags": ["desk", "light"], "digital": "none"}`,
+		},
+		{
+			name:  "an empty patch changes nothing",
+			patch: `{}`,
+			want:  doc,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			merged, err := MergePatch([]byte(doc), []byte(tc.patch))
+			require.NoError(t, err)
+			require.JSONEq(t, tc.want, string(merged))
+		})
+	}
+}
+
+func TestMergePatchKeepsLargeNumbers(t *testing.T) {
+	merged, err := MergePatch([]byte(`{"quantity": 9007199254740993}`), []byte(`{"name": "Lamp"}`))
+	require.NoError(t, err)
+
+	var doc struct {
+		Quantity json.Number `json:"quantity"`
+	}
+	require.NoError(t, json.Unmarshal(merged, &doc))
+	require.Equal(t, json.Number("9007199254740993"), doc.Quantity)
+}
+
+func TestMergePatchInvalidJSON(t *testing.T) {
+	_, err := MergePatch([]byte(`{"name": "Lamp"}`), []byte(`{"name":`))
+	require.Error(t, err)
+}
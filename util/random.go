@@ -1,6 +1,8 @@
 package util
 
 import (
+	crand "crypto/rand"
+	"encoding/hex"
 	"math/rand"
 	"time"
 )
@@ -12,8 +14,19 @@ func init() {
 func RandomString() string {
 	text := ""
 	for i := 0; i < 12; i++ {
-		char := string(rand.Intn(26) + 97)
+		char := string(rune(rand.Intn(26) + 97))
 		text += char
 	}
 	return text
 }
+
+// SecureToken returns a hex encoded, cryptographically random token of n bytes,
+// suitable for links and identifiers that must not be guessable
+func SecureToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := crand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,26 @@
+package util
+
+import (
+	"strings"
+)
+
+// Slugify turns s into a lowercase, url safe slug, e.g. "Men's Shoes (Red)" becomes "men-s-shoes-red"
+func Slugify(s string) string {
+	var b strings.Builder
+	dash := false
+
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+
+		if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+
+	return strings.TrimSuffix(b.String(), "-")
+}